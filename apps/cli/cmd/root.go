@@ -19,29 +19,37 @@ func RootCmd(writer io.Writer) *cobra.Command {
 		RunE:  utils.HelpFuncRunE,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 
-			if *config.GetGlobal().Verbose {
-				logger.SetLevel(zapcore.DebugLevel)
-				logger.Global().Debug("verbose mode active")
-			}
-
-			if home, err := utils.GetHomeDir(); err != nil {
-				return fmt.Errorf("could not get home dir: %w", err)
+			utils.SetHomeDir(*config.GetGlobal().Home)
+			if dirs, err := utils.GetDirs(); err != nil {
+				return fmt.Errorf("could not get dirs: %w", err)
 			} else {
-				stat, statErr := os.Stat(home)
-				if statErr != nil {
-					if os.IsNotExist(statErr) {
-						if err := os.Mkdir(home, 0755); err != nil {
-							return fmt.Errorf("could not create home dir: %w", err)
+				for _, dir := range dirs.All() {
+					stat, statErr := os.Stat(dir)
+					if statErr != nil {
+						if os.IsNotExist(statErr) {
+							if err := os.MkdirAll(dir, 0755); err != nil {
+								return fmt.Errorf("could not create dir (%s): %w", dir, err)
+							}
 						} else {
-							stat, statErr = os.Stat(home)
+							return fmt.Errorf("could not get dir (%s): %w", dir, statErr)
 						}
-					} else {
-						return fmt.Errorf("could not get home dir (%s): %w", home, statErr)
+					} else if !stat.IsDir() {
+						return fmt.Errorf("%s is not a directory", dir)
 					}
-				} else if !stat.IsDir() {
-					return fmt.Errorf("home dir (%s) is not a directory", home)
 				}
-				logger.Global().Debugf("home dir: %s", home)
+
+				// sensitive settings are resolved once a command unlocks the secrets store
+				settings, err := config.LoadSettings(dirs, nil, cmd.Flags())
+				if err != nil {
+					return err
+				}
+				if settings.Bool("log.verbose") {
+					*config.GetGlobal().Verbose = true
+					logger.SetLevel(zapcore.DebugLevel)
+					logger.Global().Debug("verbose mode active")
+				}
+
+				logger.Global().Debugf("config dir: %s, data dir: %s, log dir: %s", dirs.Config, dirs.Data, dirs.Log)
 			}
 
 			return nil
@@ -51,9 +59,23 @@ func RootCmd(writer io.Writer) *cobra.Command {
 	cmd.SetErr(writer)
 
 	cmd.PersistentFlags().BoolVarP(config.GetGlobal().Verbose, "verbose", "v", false, "verbose output")
+	cmd.PersistentFlags().StringVar(config.GetGlobal().Home, "home", "", fmt.Sprintf("keep every file of the instance in this dir (env: %s)", utils.HomeEnv))
 
 	cmd.AddCommand(
 		subcommands.ServeCommand(),
+		subcommands.SecretsCommand(),
+		subcommands.KeysCommand(),
+		subcommands.ConfigCommand(),
+		subcommands.MailCommand(),
+		subcommands.HomeCommand(),
+		subcommands.PullCommand(),
+		subcommands.ImagesCommand(),
+		subcommands.LogsCommand(),
+		subcommands.StatusCommand(),
+		subcommands.RestartCommand(),
+		subcommands.DownCommand(),
+		subcommands.ExportCommand(),
+		subcommands.KongCommand(),
 	)
 
 	return cmd
@@ -0,0 +1,272 @@
+package subcommands
+
+import (
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+	"text/tabwriter"
+)
+
+func ConfigCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "inspect and change the instance's configuration",
+		Long: fmt.Sprintf(`Inspect and change the configuration of this ProjDocs instance.
+
+Each setting is resolved from, in increasing order of precedence:
+
+  1. its default
+  2. the config file (%s in the config dir); sensitive settings are kept in the
+     encrypted secrets store instead
+  3. its environment variable (%s<KEY>, e.g. %sSERVER_PORT)
+  4. its command-line flag, if it has one (e.g. serve --port)`, config.SettingsFileName, config.SettingsEnvPrefix, config.SettingsEnvPrefix),
+		RunE: utils.HelpFuncRunE,
+	}
+
+	cmd.AddCommand(
+		configGetCommand(),
+		configSetCommand(),
+		configUnsetCommand(),
+		configListCommand(),
+		configValidateCommand(),
+	)
+
+	return cmd
+}
+
+// loadSettings resolves the settings; with unlock, sensitive ones are resolved from the secrets store if it can be unlocked
+func loadSettings(cmd *cobra.Command, unlock bool) (*config.Settings, *secrets.Store, error) {
+
+	dirs, err := utils.GetDirs() // error is checked in persistent prerun
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get dirs: %w", err)
+	}
+
+	var store *secrets.Store
+	if unlock {
+		if store, err = secrets.Open(dirs.Config); err != nil {
+			logger.Global().Warnf("could not unlock secrets store, sensitive settings are not resolved from it: %v", err)
+			store = nil
+		}
+	}
+
+	settings, err := config.LoadSettings(dirs, store, cmd.Flags())
+	if err != nil {
+		return nil, nil, err
+	}
+	return settings, store, nil
+}
+
+// displayValue returns a setting's value for display, masking sensitive values unless reveal is set
+func displayValue(v config.Value, reveal bool) string {
+	if v.Sensitive() && !reveal {
+		return maskSecret(v.Raw)
+	}
+	return v.Raw
+}
+
+// displaySource returns the layer a setting's value came from, with its origin
+func displaySource(v config.Value) string {
+	if v.Origin == "" {
+		return string(v.Source)
+	}
+	return fmt.Sprintf("%s (%s)", v.Source, v.Origin)
+}
+
+func configGetCommand() *cobra.Command {
+
+	var (
+		reveal     *bool = utils.Pointer(false)
+		showSource *bool = utils.Pointer(false)
+	)
+
+	cmd := &cobra.Command{
+		Use:           "get <key>",
+		Short:         "print the resolved value of a setting",
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			setting, err := config.LookupSetting(args[0])
+			if err != nil {
+				return err
+			}
+
+			settings, _, err := loadSettings(cmd, setting.Sensitive())
+			if err != nil {
+				return err
+			}
+
+			v, err := settings.Get(setting.Key)
+			if err != nil {
+				return err
+			}
+
+			if *showSource {
+				_, err = fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\n", displayValue(v, *reveal), displaySource(v))
+			} else {
+				_, err = fmt.Fprintln(cmd.OutOrStdout(), displayValue(v, *reveal))
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().BoolVar(reveal, "reveal", *reveal, "print sensitive values in plaintext")
+	cmd.Flags().BoolVarP(showSource, "source", "s", *showSource, "also print the layer the value came from")
+
+	return cmd
+}
+
+func configSetCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:           "set <key> <value>",
+		Short:         "persist a setting in the config file (or, for sensitive settings, the secrets store)",
+		Args:          cobra.ExactArgs(2),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			key, value := args[0], args[1]
+			setting, err := config.LookupSetting(key)
+			if err != nil {
+				return err
+			}
+
+			settings, store, err := loadSettings(cmd, setting.Sensitive())
+			if err != nil {
+				return err
+			}
+			if setting.Sensitive() && store == nil {
+				return fmt.Errorf("%s is sensitive and is kept in the secrets store, which could not be unlocked", key)
+			}
+
+			if err := config.SetSetting(settings.Dirs().Config, store, key, value); err != nil {
+				return err
+			}
+			if setting.Sensitive() {
+				logger.Global().Infof("saved %s in the secrets store", key)
+			} else {
+				logger.Global().Infof("saved %s in %s", key, settings.File())
+			}
+
+			warnIfOverridden(cmd, key)
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func configUnsetCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:           "unset <key>",
+		Short:         "remove a persisted setting, so it falls back to its default",
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			key := args[0]
+			setting, _ := config.LookupSetting(key) // unknown keys are removed from the config file
+
+			settings, store, err := loadSettings(cmd, setting.Sensitive())
+			if err != nil {
+				return err
+			}
+			if setting.Sensitive() && store == nil {
+				return fmt.Errorf("%s is sensitive and is kept in the secrets store, which could not be unlocked", key)
+			}
+
+			if err := config.UnsetSetting(settings.Dirs().Config, store, key); err != nil {
+				return err
+			}
+			logger.Global().Infof("removed %s", key)
+
+			warnIfOverridden(cmd, key)
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+// warnIfOverridden warns when a persisted setting has no effect because a higher layer overrides it
+func warnIfOverridden(cmd *cobra.Command, key string) {
+	settings, _, err := loadSettings(cmd, false)
+	if err != nil {
+		return
+	}
+	if v, err := settings.Get(key); err == nil && (v.Source == config.SourceEnv || v.Source == config.SourceFlag) {
+		logger.Global().Warnf("%s is currently overridden by %s", key, v.Origin)
+	}
+}
+
+func configListCommand() *cobra.Command {
+
+	var (
+		reveal *bool = utils.Pointer(false)
+	)
+
+	cmd := &cobra.Command{
+		Use:           "list",
+		Short:         "print every setting with its resolved value and the layer it came from",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			settings, _, err := loadSettings(cmd, true)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			if _, err := fmt.Fprintln(w, "KEY\tVALUE\tSOURCE"); err != nil {
+				return err
+			}
+			for _, v := range settings.All() {
+				if _, err := fmt.Fprintf(w, "%s\t%s\t%s\n", v.Key, displayValue(v, *reveal), displaySource(v)); err != nil {
+					return err
+				}
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(reveal, "reveal", *reveal, "print sensitive values in plaintext")
+
+	return cmd
+}
+
+func configValidateCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:           "validate",
+		Short:         "check the config file, environment and secrets store for invalid settings",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			settings, _, err := loadSettings(cmd, true)
+			if err != nil {
+				return err
+			}
+
+			if err := settings.Validate(); err != nil {
+				return fmt.Errorf("configuration is invalid:\n%w", err)
+			}
+			logger.Global().Infof("configuration is valid")
+			return nil
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,81 @@
+package subcommands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	projdocserrors "github.com/projdocs/projdocs/apps/cli/errors"
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/images"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+	"path/filepath"
+)
+
+// connectDocker connects to the container runtime (Docker, or Podman; see docker.FindRuntime) and checks that it
+// is reachable; tests replace it with a client of a fake engine
+var connectDocker = func(ctx context.Context) (*docker.Docker, error) {
+
+	rt := docker.FindRuntime()
+	dkr, err := docker.Connect(ctx, rt)
+	if err != nil {
+		switch {
+		case errors.Is(err, docker.ErrUnavailable) && rt.Backend == docker.BackendPodman:
+			return nil, fmt.Errorf("Podman is not running (could not reach it at %s); start its API service (e.g. `systemctl --user start podman.socket`) and try again: %w", rt.Host, err)
+		case errors.Is(err, docker.ErrUnavailable):
+			return nil, fmt.Errorf("Docker is not running (could not reach it at %s); start Docker and try again: %w", rt.Host, err)
+		case errors.Is(err, docker.ErrUnauthorized) && rt.Backend == docker.BackendPodman:
+			return nil, fmt.Errorf("not allowed to use Podman at %s; use the socket of your own user's Podman service: %w", rt.Host, err)
+		case errors.Is(err, docker.ErrUnauthorized):
+			return nil, fmt.Errorf("not allowed to use Docker at %s; run as root or as a member of the docker group: %w", rt.Host, err)
+		default:
+			return nil, fmt.Errorf("could not connect to %s: %w", rt, err)
+		}
+	}
+
+	// pinned images loaded from bundles are verified by the IDs recorded when they were loaded
+	if dirs, err := utils.GetDirs(); err == nil {
+		trusted, err := docker.ReadTrustedImages(filepath.Join(dirs.Data, docker.TrustedImagesFileName))
+		if err != nil {
+			return nil, err
+		}
+		dkr.SetTrustedImages(trusted)
+	}
+
+	return dkr, nil
+}
+
+// serviceContainers returns the container of every service: the supabase services and ProjDocs
+func serviceContainers(inst *instance) ([]*docker.Container, error) {
+	containers, err := supabase.All(inst.supabase, docker.ProjDocs)
+	if errors.Is(err, projdocserrors.NotImplemented) {
+		logger.Global().Warnf("the ProjDocs container is not available in this build; only the supabase services are included")
+		containers, err = supabase.All(inst.supabase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not instantiate supabase containers: %w", err)
+	}
+	return containers, nil
+}
+
+// serviceImages returns the image of every supabase service (see the images.* settings), as a container that is
+// only named after its service, for pulling and saving the images; it needs no secrets store, so it works before
+// the first serve
+func serviceImages(cmd *cobra.Command) ([]*docker.Container, error) {
+	settings, _, err := loadSettings(cmd, false)
+	if err != nil {
+		return nil, err
+	}
+	imgs, err := config.NewImages(settings)
+	if err != nil {
+		return nil, err
+	}
+	var containers []*docker.Container
+	for _, service := range images.Services() {
+		containers = append(containers, &docker.Container{Name: service, Image: imgs[service].Image, Digest: imgs[service].Digest})
+	}
+	return containers, nil
+}
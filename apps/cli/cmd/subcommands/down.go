@@ -0,0 +1,35 @@
+package subcommands
+
+import (
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+func DownCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "down",
+		Short: "stop and remove every service of the instance",
+		Long: `Stop and remove every container of the instance, dependents before their
+dependencies, whether it was started by ` + "`projdocs up -d`" + `, a serve in another shell or
+an earlier run. The data of the services (in the data dir) is kept.`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			dkr, err := connectDocker(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if err := dkr.Down(cmd.Context()); err != nil {
+				return fmt.Errorf("could not stop every service:\n%w", err)
+			}
+			logger.Global().Info("every service is stopped")
+			return nil
+		},
+	}
+
+	return cmd
+}
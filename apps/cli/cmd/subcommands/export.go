@@ -0,0 +1,198 @@
+package subcommands
+
+import (
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/compose"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/kube"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+func ExportCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "export the stack to run it with other tools",
+		RunE:  utils.HelpFuncRunE,
+	}
+
+	cmd.AddCommand(
+		exportComposeCommand(),
+		exportKubernetesCommand(),
+	)
+
+	return cmd
+}
+
+// loadExportedInstance loads the instance to export, which must have been initialised: exports contain its secrets
+func loadExportedInstance(cmd *cobra.Command) (*instance, error) {
+	inst, err := loadInstance(cmd)
+	if errors.Is(err, secrets.ErrNoStore) {
+		return nil, errors.New("nothing to export: exports contain the keys and credentials of an initialised instance, and this one has none yet; start it once with `projdocs serve` first")
+	}
+	return inst, err
+}
+
+func exportComposeCommand() *cobra.Command {
+
+	var (
+		output *string = utils.Pointer("")
+		embeds *string = utils.Pointer(string(compose.EmbedConfigs))
+	)
+
+	cmd := &cobra.Command{
+		Use:   "compose",
+		Short: "export the stack as a docker-compose.yml",
+		Long: `Write a Compose file that runs the services as serve would: the same images
+(pinned by digest), environment, ports, mounts, health checks and dependencies.
+
+The embedded configuration files of the services (e.g. kong.yml) are exported as
+Compose configs with their content inline, or with --embeds files, as files in
+the ` + compose.FilesDir + ` dir next to the Compose file (this requires --output).
+
+The Compose file contains the secrets of the instance in plain text, so it is
+written with mode 0600. Hooks that serve runs once a service is ready, such as
+creating the storage buckets, are not exported.`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			mode := compose.EmbedMode(*embeds)
+			switch mode {
+			case compose.EmbedConfigs:
+			case compose.EmbedFiles:
+				if *output == "" {
+					return fmt.Errorf("--embeds %s requires --output", mode)
+				}
+			default:
+				return fmt.Errorf("invalid --embeds %q: expected %s or %s", *embeds, compose.EmbedConfigs, compose.EmbedFiles)
+			}
+
+			inst, err := loadExportedInstance(cmd)
+			if err != nil {
+				return err
+			}
+			containers, err := serviceContainers(inst)
+			if err != nil {
+				return err
+			}
+
+			export, err := compose.Exporter{Embeds: mode}.Export(containers)
+			if err != nil {
+				return fmt.Errorf("could not export the stack: %w", err)
+			}
+			for _, warning := range export.Warnings {
+				logger.Global().Warnf("%s", warning)
+			}
+			data, err := export.YAML()
+			if err != nil {
+				return err
+			}
+
+			if *output == "" {
+				_, err := cmd.OutOrStdout().Write(data)
+				return err
+			}
+			dir := filepath.Dir(*output)
+			for rel, content := range export.Files {
+				file := filepath.Join(dir, filepath.FromSlash(rel))
+				if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+					return fmt.Errorf("could not create dir for %s: %w", file, err)
+				}
+				if err := utils.WriteFileAtomic(file, content, 0600); err != nil {
+					return fmt.Errorf("could not write %s: %w", file, err)
+				}
+			}
+			if err := utils.WriteFileAtomic(*output, data, 0600); err != nil {
+				return fmt.Errorf("could not write %s: %w", *output, err)
+			}
+			logger.Global().Infof("exported %d services to %s", len(export.File.Services), *output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(output, "output", "o", *output, "the file to write the Compose file to (default: stdout)")
+	cmd.Flags().StringVar(embeds, "embeds", *embeds, "how to export embedded files: configs or files")
+
+	return cmd
+}
+
+func exportKubernetesCommand() *cobra.Command {
+
+	var (
+		output      *string = utils.Pointer("")
+		storageSize *string = utils.Pointer(kube.DefaultStorageSize)
+	)
+
+	cmd := &cobra.Command{
+		Use:     "kubernetes",
+		Aliases: []string{"k8s"},
+		Short:   "export the stack as Kubernetes manifests",
+		Long: `Write Kubernetes manifests that run the services as serve would, to apply with
+` + "`kubectl apply -f`" + ` (in the namespace of your choice):
+
+  - a Deployment for each service, or a StatefulSet for a service with data dirs,
+    which get a PersistentVolumeClaim each (see --storage-size)
+  - Services for the ports other services connect to, by the service's name and
+    its container's name
+  - a ConfigMap with the embedded configuration files of a service, or a Secret
+    for sensitive files such as keys
+  - a Secret with the environment variables that look sensitive (passwords, keys,
+    secrets, tokens and URLs with a password)
+  - readiness probes from the health checks, and a Job for each after-start hook
+
+Kubernetes has no startup order, so services restart until their dependencies
+are reachable. Published ports are only exported as cluster services; expose
+them with an ingress. What cannot be exported is logged as a warning.
+
+The manifests contain the secrets of the instance, so the file is written with
+mode 0600.`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			inst, err := loadExportedInstance(cmd)
+			if err != nil {
+				return err
+			}
+			containers, err := serviceContainers(inst)
+			if err != nil {
+				return err
+			}
+
+			export, err := kube.Exporter{StorageSize: *storageSize}.Export(containers)
+			if err != nil {
+				return fmt.Errorf("could not export the stack: %w", err)
+			}
+			for _, warning := range export.Warnings {
+				logger.Global().Warnf("%s", warning)
+			}
+			data, err := export.YAML()
+			if err != nil {
+				return err
+			}
+
+			if *output == "" {
+				_, err := cmd.OutOrStdout().Write(data)
+				return err
+			}
+			if err := utils.WriteFileAtomic(*output, data, 0600); err != nil {
+				return fmt.Errorf("could not write %s: %w", *output, err)
+			}
+			logger.Global().Infof("exported %d services to %s", len(containers), *output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(output, "output", "o", *output, "the file to write the manifests to (default: stdout)")
+	cmd.Flags().StringVar(storageSize, "storage-size", *storageSize, "the size each persistent volume claim requests")
+
+	return cmd
+}
@@ -0,0 +1,139 @@
+package subcommands
+
+import (
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+func HomeCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "home",
+		Short: "print where the instance keeps its config, data and logs",
+		Long: fmt.Sprintf(`Print where this ProjDocs instance keeps its files.
+
+With --home (or %s), every file is kept in that dir. Otherwise, on Linux:
+
+  - as root: %s (config), /var/lib/projdocs (data) and /var/log/projdocs (logs)
+  - as any other user: projdocs in $XDG_CONFIG_HOME (~/.config), $XDG_DATA_HOME
+    (~/.local/share) and $XDG_STATE_HOME (~/.local/state)
+
+Instances that kept everything in %s keep using their data there until it is
+moved with `+"`projdocs home migrate`"+`.`, utils.HomeEnv, utils.LegacyHomeDir, utils.LegacyHomeDir),
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			dirs, err := utils.GetDirs() // error is checked in persistent prerun
+			if err != nil {
+				return fmt.Errorf("could not get dirs: %w", err)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			for _, line := range [][2]string{
+				{"config", dirs.Config},
+				{"data", dirs.Data},
+				{"log", dirs.Log},
+			} {
+				if _, err := fmt.Fprintf(w, "%s\t%s\n", line[0], line[1]); err != nil {
+					return err
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if dirs.Legacy {
+				logger.Global().Warnf("data is still kept in %s; run `projdocs home migrate` to move it to its own dir", dirs.Data)
+			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(
+		homeMigrateCommand(),
+	)
+
+	return cmd
+}
+
+func homeMigrateCommand() *cobra.Command {
+
+	var (
+		from   *string = utils.Pointer(utils.LegacyHomeDir)
+		dryRun *bool   = utils.Pointer(false)
+	)
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "move the files of a single-dir home (such as /etc/projdocs) into the config and data dirs",
+		Long: `Move the files of an instance that keeps everything in one dir (by default the
+legacy /etc/projdocs) into the dirs this instance uses (see ` + "`projdocs home`" + `):
+the config file, secrets store, master key and instance state go to the config
+dir; the database, uploaded files and backups go to the data dir.
+
+Stop ProjDocs first. Files are renamed, so ownership and permissions are kept;
+moving across filesystems is not supported and must be done by hand. Run it as
+the user that will operate the instance, with write access to --from.`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			source, err := filepath.Abs(*from)
+			if err != nil {
+				return fmt.Errorf("invalid --from: %w", err)
+			}
+			dirs, err := utils.GetTargetDirs()
+			if err != nil {
+				return fmt.Errorf("could not get dirs: %w", err)
+			}
+
+			moves, err := config.PlanHomeMigration(source, dirs)
+			if err != nil {
+				return err
+			}
+			if len(moves) == 0 {
+				logger.Global().Infof("nothing to migrate from %s", source)
+				return nil
+			}
+
+			if *dryRun {
+				for _, move := range moves {
+					if _, err := fmt.Fprintf(cmd.OutOrStdout(), "%s -> %s\n", move.From, move.To); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			if err := config.MigrateHome(moves, dirs); err != nil {
+				return err
+			}
+
+			// explicitly configured data dirs are not rewritten
+			if settings, err := config.LoadSettings(dirs, nil, nil); err == nil {
+				for _, key := range []string{"database.data_dir", "storage.data_dir"} {
+					if v, _ := settings.Get(key); v.Source != config.SourceDefault && strings.HasPrefix(v.Raw, source+string(filepath.Separator)) {
+						logger.Global().Warnf("%s is still set to %s (from %s); update it with `projdocs config set %s`", key, v.Raw, displaySource(v), key)
+					}
+				}
+			}
+
+			logger.Global().Infof("migrated %d files and dirs from %s", len(moves), source)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(from, "from", *from, "the single-dir home to migrate")
+	cmd.Flags().BoolVar(dryRun, "dry-run", *dryRun, "only print the moves")
+
+	return cmd
+}
@@ -0,0 +1,127 @@
+package subcommands
+
+import (
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+func ImagesCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "images",
+		Short: "move the docker images of every service to hosts without registry access",
+		RunE:  utils.HelpFuncRunE,
+	}
+
+	cmd.AddCommand(
+		imagesSaveCommand(),
+		imagesLoadCommand(),
+	)
+
+	return cmd
+}
+
+func imagesSaveCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "save <file>",
+		Short: "write the image of every service into a bundle",
+		Long: `Write the docker image of every service (with the images.* settings of this
+instance, see ` + "`projdocs config list`" + `) into one tar file, with a manifest that lists
+the checksum, ID and digests of each image. Missing images are pulled first.
+A pinned image is only saved if its archive contains the manifest of its digest
+(docker keeps it with the containerd image store). Images that are not pinned are
+saved with a warning, but ` + "`projdocs images load`" + ` refuses them.
+
+Copy the bundle to a host without registry access and load it there with
+` + "`projdocs images load`" + `.`,
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			containers, err := serviceImages(cmd)
+			if err != nil {
+				return err
+			}
+			dkr, err := connectDocker(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			// write next to the target, so a failed save does not leave a partial bundle behind
+			file := args[0]
+			tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+			if err != nil {
+				return fmt.Errorf("could not create bundle: %w", err)
+			}
+			defer os.Remove(tmp.Name())
+			defer tmp.Close()
+
+			bundle, err := dkr.SaveImages(cmd.Context(), containers, tmp)
+			if err != nil {
+				return err
+			}
+			if err := tmp.Close(); err != nil {
+				return fmt.Errorf("could not write bundle: %w", err)
+			}
+			if err := os.Rename(tmp.Name(), file); err != nil {
+				return fmt.Errorf("could not write bundle: %w", err)
+			}
+
+			logger.Global().Infof("saved %d images to %s", len(bundle.Images), file)
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func imagesLoadCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "load <file>",
+		Short: "load the images of a bundle written by `images save`",
+		Long: `Verify the checksum of every image in a bundle written by ` + "`projdocs images save`" + `,
+load the images into docker and verify each loaded image: it must be the image of
+its pinned digest, as derived from the manifests in its archive (not from the
+bundle's own records). A bundle with an image that is not pinned to a digest
+cannot be verified, so it is refused.
+
+Since docker does not keep the digests of loaded images, the images of the bundle
+are recorded in the data dir: serve accepts a loaded image as matching its pinned
+digest only if it still has the recorded ID.`,
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			dirs, err := utils.GetDirs() // error is checked in persistent prerun
+			if err != nil {
+				return fmt.Errorf("could not get dirs: %w", err)
+			}
+			dkr, err := connectDocker(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			bundle, err := dkr.LoadImages(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			if err := docker.WriteTrustedImages(filepath.Join(dirs.Data, docker.TrustedImagesFileName), bundle); err != nil {
+				return err
+			}
+
+			logger.Global().Infof("loaded %d images from %s (saved %s)", len(bundle.Images), args[0], bundle.Created.Local().Format("2006-01-02 15:04"))
+			return nil
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,83 @@
+package subcommands
+
+import (
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+// instance is the unlocked state of the local ProjDocs instance
+type instance struct {
+	dirs     utils.Dirs
+	store    *secrets.Store
+	settings *config.Settings
+	state    *config.Instance
+	supabase *config.Supabase
+}
+
+// loadInstance unlocks the secrets store, resolves the settings (with cmd's flags), loads (or creates)
+// the instance state and builds the supabase config; it fails if there is no secrets store yet (see createInstance)
+func loadInstance(cmd *cobra.Command) (*instance, error) {
+	return openInstance(cmd, secrets.Open)
+}
+
+// createInstance is loadInstance, but creates the secrets store on first use; only serve creates it
+func createInstance(cmd *cobra.Command) (*instance, error) {
+	return openInstance(cmd, secrets.Unlock)
+}
+
+func openInstance(cmd *cobra.Command, unlock func(configDir string) (*secrets.Store, error)) (*instance, error) {
+
+	dirs, err := utils.GetDirs() // error is checked in persistent prerun
+	if err != nil {
+		return nil, fmt.Errorf("could not get dirs: %w", err)
+	}
+
+	if dirs.Legacy {
+		logger.Global().Warnf("data is still kept in %s; run `projdocs home migrate` to move it to its own dir", dirs.Data)
+	}
+
+	// unlock the secrets store
+	store, err := unlock(dirs.Config)
+	if errors.Is(err, secrets.ErrNoStore) {
+		return nil, fmt.Errorf("%w; start the instance with `projdocs serve` first", err)
+	} else if err != nil {
+		return nil, fmt.Errorf("could not unlock secrets store: %w", err)
+	}
+	logger.Global().Debugf("unlocked secrets store (%s)", store.Path())
+
+	// resolve settings
+	settings, err := config.LoadSettings(dirs, store, cmd.Flags())
+	if err != nil {
+		return nil, err
+	}
+	if err := settings.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration (see `projdocs config validate`):\n%w", err)
+	}
+
+	// load (or create) persisted instance state
+	state, err := config.LoadInstance(settings, store)
+	if err != nil {
+		return nil, fmt.Errorf("could not load instance state: %w", err)
+	}
+	logger.Global().Debugf("loaded instance %s (created %s)", state.ID, state.CreatedAt.Format(time.RFC3339))
+
+	// get supabase config
+	sbCfg, err := config.NewSupabase(store, settings)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create supabase config: %w", err)
+	}
+
+	return &instance{
+		dirs:     dirs,
+		store:    store,
+		settings: settings,
+		state:    state,
+		supabase: sbCfg,
+	}, nil
+}
@@ -0,0 +1,197 @@
+package subcommands
+
+import (
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+	"strings"
+	"time"
+)
+
+func KeysCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "keys",
+		Short: "manage the instance's cryptographic keys",
+		Long: `Manage the instance's cryptographic keys: the jwt signing keys and the keys
+signed with them (see rotate), and the pgsodium/vault root key (see vault).
+
+With an asymmetric algorithm (ES256 or RS256), only the CLI and Auth hold private
+keys; every other service can only verify tokens. This does not protect against
+Auth itself: PostgREST, Realtime and Storage cannot limit a key to some roles, so
+they accept tokens for any role, service_role included, that Auth's key signs.`,
+		RunE: utils.HelpFuncRunE,
+	}
+
+	cmd.AddCommand(
+		keysRotateCommand(),
+		keysVaultCommand(),
+	)
+
+	return cmd
+}
+
+func keysRotateCommand() *cobra.Command {
+
+	var (
+		grace     *time.Duration = utils.Pointer(time.Duration(0))
+		endGrace  *bool          = utils.Pointer(false)
+		algorithm *string        = utils.Pointer("")
+	)
+
+	cmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "rotate the jwt signing keys and re-sign the anon and service_role keys",
+		Long: `Generate new jwt signing keys, re-sign the anon and service_role keys with them,
+and restart every running service that uses them (Auth, PostgREST, Realtime,
+Storage, then Kong).
+
+By default the previous keys are revoked immediately. With --grace, they are still
+accepted until the grace window ends, so clients can be updated first; use
+--end-grace to revoke them early. A foreground ` + "`projdocs serve`" + ` restarts the services
+when the window ends; services started with --detach keep accepting the previous
+keys until they are recreated (e.g. with ` + "`projdocs up -d`" + `) or --end-grace is used.
+
+With --algorithm ES256 or RS256, tokens are signed with a private key instead of
+the shared secret: the anon and service_role keys are signed by the CLI, and Auth
+signs user sessions with its own key. Other services only receive the public keys,
+which are also published at /.well-known/jwks.json on the internal server.
+
+This keeps the signing keys out of every service except Auth. PostgREST, Realtime
+and Storage must accept user sessions, so they trust Auth's public key, and they
+cannot limit a key to some roles: a compromised Auth container can still mint
+service_role tokens that they accept.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			inst, err := loadInstance(cmd)
+			if err != nil {
+				return err
+			}
+
+			if *endGrace {
+				config.EndJwtGrace(inst.store)
+				if err := inst.store.Save(); err != nil {
+					return fmt.Errorf("could not save secrets store: %w", err)
+				}
+				logger.Global().Infof("ended grace window: previous keys are no longer accepted")
+			} else {
+				if err := config.RotateSigningKeys(inst.store, strings.ToUpper(*algorithm), *grace); err != nil {
+					return fmt.Errorf("could not rotate jwt signing keys: %w", err)
+				}
+				if *grace > 0 {
+					logger.Global().Infof("rotated jwt signing keys; previous keys are accepted until %s", time.Now().Add(*grace).Format(time.RFC3339))
+				} else {
+					logger.Global().Infof("rotated jwt signing keys; previous keys are revoked")
+				}
+			}
+
+			// rebuild the config from the updated store
+			sbCfg, err := config.NewSupabase(inst.store, inst.settings)
+			if err != nil {
+				return fmt.Errorf("unable to create supabase config: %w", err)
+			}
+			if sbCfg.Keys.Asymmetric() {
+				logger.Global().Warnf("Auth's signing key is trusted by every service for any role: a compromised Auth container can still mint service_role tokens")
+			}
+
+			dkr, err := connectDocker(cmd.Context())
+			if err != nil {
+				logger.Global().Warnf("keys were saved, but running services could not be restarted (%v); they will use the new keys when next started", err)
+				return nil
+			}
+			if err := supabase.ApplyKeys(cmd.Context(), dkr, sbCfg); err != nil {
+				return fmt.Errorf("keys were saved, but services could not be restarted: %w", err)
+			}
+
+			logger.Global().Info("services restarted with the new keys")
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(grace, "grace", *grace, "keep accepting the previous keys for this long (e.g. 24h)")
+	cmd.Flags().BoolVar(endGrace, "end-grace", *endGrace, "stop accepting the previous keys now, without rotating")
+	cmd.Flags().StringVar(algorithm, "algorithm", *algorithm, "jwt signing algorithm: HS256, or ES256 or RS256 to keep the signing keys out of every service except Auth (default: keep the current one)")
+	cmd.MarkFlagsMutuallyExclusive("grace", "end-grace")
+	cmd.MarkFlagsMutuallyExclusive("algorithm", "end-grace")
+
+	return cmd
+}
+
+func keysVaultCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "vault",
+		Short: "manage the pgsodium/vault root key",
+		RunE:  utils.HelpFuncRunE,
+	}
+
+	cmd.AddCommand(
+		keysVaultRotateCommand(),
+	)
+
+	return cmd
+}
+
+func keysVaultRotateCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "generate a new vault root key and re-encrypt every vault secret with it",
+		Long: `Generate a new pgsodium/vault root key and re-encrypt every vault secret with it.
+
+The instance must be running (see serve). The database is restarted with the new
+key; the new key is backed up before it is used, and the previous key is kept in
+the secrets store.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			inst, err := loadInstance(cmd)
+			if err != nil {
+				return err
+			}
+
+			dkr, err := connectDocker(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			previous := inst.supabase.Keys.PgSodiumEncryption
+			next, err := config.GenerateVaultRootKey()
+			if err != nil {
+				return err
+			}
+
+			// back up the new key before the database starts using it
+			if _, err := config.BackupVaultRootKey(inst.dirs.Data, next); err != nil {
+				return err
+			}
+
+			if err := supabase.RotateVaultRootKey(cmd.Context(), dkr, inst.supabase, next); err != nil {
+				return fmt.Errorf("could not rotate vault root key: %w", err)
+			}
+
+			// persist the new key
+			inst.supabase.Keys.PgSodiumEncryption = next
+			inst.store.Set(secrets.VaultPreviousRootKey, previous)
+			inst.store.Set(secrets.VaultRootKey, next)
+			if err := inst.store.Save(); err != nil {
+				return fmt.Errorf("vault was re-encrypted, but the new root key could not be saved (restore it from the backup): %w", err)
+			}
+			if err := inst.supabase.RecordVaultKey(); err != nil {
+				return err
+			}
+
+			logger.Global().Infof("rotated vault root key (fingerprint %s)", config.VaultKeyFingerprint(next)[:12])
+			return nil
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,80 @@
+package subcommands
+
+import (
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase/kong"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func KongCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "kong",
+		Short: "inspect the API gateway",
+		RunE:  utils.HelpFuncRunE,
+	}
+
+	cmd.AddCommand(
+		kongConfigCommand(),
+	)
+
+	return cmd
+}
+
+func kongConfigCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "print the declarative config of kong",
+		Long: `Render the declarative config (kong.yml) that serve writes into the kong
+container from the settings and keys of this instance, validate it, and print it
+with its keys and dashboard credentials redacted.
+
+The routes, consumers and plugins are the same for every instance; the keys,
+dashboard credentials and CORS origins (urls.cors_origins) are the instance's.
+Before the first serve, the instance has no keys yet, so placeholders are shown.`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			var config *kong.Config
+			inst, err := loadInstance(cmd)
+			switch {
+			case errors.Is(err, secrets.ErrNoStore):
+				// before the first serve there are no keys yet, and they are redacted anyway
+				settings, _, err := loadSettings(cmd, false)
+				if err != nil {
+					return err
+				}
+				logger.Global().Infof("the instance has no keys yet (it has not been served); showing the config with placeholders")
+				config = kong.New(kong.Credentials{
+					AnonKeys:          []string{"anon"},
+					ServiceKeys:       []string{"service_role"},
+					DashboardUsername: "dashboard",
+					DashboardPassword: "dashboard",
+				}, settings.List("urls.cors_origins"))
+			case err != nil:
+				return err
+			default:
+				config = supabase.KongConfig(inst.supabase)
+			}
+			if err := config.Validate(); err != nil {
+				return fmt.Errorf("invalid kong config:\n%w", err)
+			}
+			data, err := config.Redacted().YAML()
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,113 @@
+package subcommands
+
+import (
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger/encoders"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+	"io"
+	"strconv"
+	"time"
+)
+
+func LogsCommand() *cobra.Command {
+
+	var (
+		follow *bool   = utils.Pointer(false)
+		since  *string = utils.Pointer("")
+		tail   *string = utils.Pointer("all")
+	)
+
+	cmd := &cobra.Command{
+		Use:   "logs [service...]",
+		Short: "show the logs of services",
+		Long: `Show the logs of the given services (every service if none is given), interleaved
+by time and prefixed with the name of their service. Services are named by their
+short names (e.g. db, auth, rest, realtime, storage, kong), their aliases (e.g.
+postgres, gotrue, postgrest, gateway) or their container names.
+
+Lines the services wrote to stderr are shown in red.`,
+		Example: `  projdocs logs auth rest --since 10m
+  projdocs logs --follow --tail 50`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			options := docker.LogOptions{Follow: *follow}
+			if *since != "" {
+				t, err := parseSince(*since, time.Now())
+				if err != nil {
+					return err
+				}
+				options.Since = t
+			}
+			if *tail != "all" {
+				n, err := strconv.Atoi(*tail)
+				if err != nil || n < 0 {
+					return fmt.Errorf("invalid --tail %q: expected a number of lines or all", *tail)
+				}
+				// docker shows every line for a tail of 0, but no line is what was asked for
+				if n == 0 && !*follow {
+					return nil
+				}
+				options.Tail = n
+			}
+
+			// the services are found by their labels, so no secrets are needed
+			dkr, err := connectDocker(cmd.Context())
+			if err != nil {
+				return err
+			}
+			all, err := dkr.InstanceServices(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if len(all) == 0 {
+				return errors.New("no service has been created; start them with `projdocs serve` or `projdocs up -d`")
+			}
+			containers, err := docker.FindContainers(all, args...)
+			if err != nil {
+				return err
+			}
+
+			var names []string
+			for _, c := range containers {
+				names = append(names, c.ServiceName())
+			}
+			encoder := encoders.NewPrefixEncoder(names...)
+			out := cmd.OutOrStdout()
+			err = dkr.Logs(cmd.Context(), containers, options, func(line docker.LogLine) {
+				_, _ = io.WriteString(out, encoder.EncodeLine(line.Container.ServiceName(), line.Stderr, line.Text))
+			})
+			if err != nil {
+				return fmt.Errorf("could not get the logs of every service (is the instance running?):\n%w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(follow, "follow", "f", *follow, "keep showing new lines until interrupted")
+	cmd.Flags().StringVar(since, "since", *since, "only show lines logged since a time (e.g. 2025-01-02T15:04:05Z) or for a duration (e.g. 10m)")
+	cmd.Flags().StringVarP(tail, "tail", "n", *tail, "only show this many of the last lines of each service (or all)")
+
+	return cmd
+}
+
+// parseSince parses a time as RFC 3339 (optionally without the zone, or just a date, in local time) or as
+// a duration before now
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: expected a time (e.g. 2025-01-02T15:04:05Z) or a duration (e.g. 10m)", s)
+}
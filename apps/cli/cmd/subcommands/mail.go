@@ -0,0 +1,71 @@
+package subcommands
+
+import (
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/mail"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func MailCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "mail",
+		Short: "manage the SMTP relay used for auth emails",
+		RunE:  utils.HelpFuncRunE,
+	}
+
+	cmd.AddCommand(
+		mailTestCommand(),
+	)
+
+	return cmd
+}
+
+func mailTestCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "test <recipient>",
+		Short: "send a test email through the configured SMTP relay",
+		Long: `Send a test email through the SMTP relay configured with the smtp.* settings
+(see config list), as Auth would for invites and password resets: over implicit TLS
+on port 465, and with STARTTLS on other ports if the relay offers it (credentials are
+only sent over TLS).
+
+If sending fails, the SMTP conversation is printed (with credentials redacted).`,
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			inst, err := loadInstance(cmd)
+			if err != nil {
+				return err
+			}
+
+			smtp := inst.supabase.Kong.SMTP
+			logger.Global().Infof("sending test email to %s via %s:%d", args[0], smtp.Host, smtp.Port)
+			transcript, err := mail.Send(cmd.Context(), smtp, mail.Message{
+				To:      args[0],
+				Subject: "ProjDocs test email",
+				Body: fmt.Sprintf(
+					"This is a test email from ProjDocs instance %s.\n\nIf you received it, invites and password resets can be delivered.\n",
+					inst.state.ID,
+				),
+			})
+			if err != nil {
+				if len(transcript.Lines) > 0 {
+					_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "SMTP conversation:\n%s\n", transcript)
+				}
+				return fmt.Errorf("could not send test email: %w", err)
+			}
+
+			logger.Global().Debugf("SMTP conversation:\n%s", transcript)
+			logger.Global().Infof("sent test email to %s", args[0])
+			return nil
+		},
+	}
+
+	return cmd
+}
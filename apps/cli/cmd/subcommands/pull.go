@@ -0,0 +1,63 @@
+package subcommands
+
+import (
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap/zapcore"
+	"time"
+)
+
+func PullCommand() *cobra.Command {
+
+	var (
+		jsonOutput *bool = utils.Pointer(false)
+	)
+
+	cmd := &cobra.Command{
+		Use:   "pull",
+		Short: "pull the images of every service, so the first serve does not have to",
+		Long: `Pull the docker image of every service that serve runs, in parallel, and verify
+their pinned digests (see the images.* settings in ` + "`projdocs config list`" + `).
+Images that are already available locally are not pulled again.
+
+With --json, nothing is logged: every step of every pull is written to stdout as
+a line of JSON, with the fields image, layer, status, current, total, done and error.`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			if *jsonOutput {
+				logger.SetLevel(zapcore.ErrorLevel)
+			}
+
+			// only the images are needed, so this works before the first serve
+			containers, err := serviceImages(cmd)
+			if err != nil {
+				return err
+			}
+
+			dkr, err := connectDocker(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if *jsonOutput {
+				dkr.SetPullReporter(docker.JSONPullProgress(cmd.OutOrStdout()))
+			}
+
+			start := time.Now()
+			if err := dkr.EnsureImages(cmd.Context(), containers); err != nil {
+				return fmt.Errorf("could not pull images:\n%w", err)
+			}
+			logger.Global().Infof("the images of all %d services are available (%s)", len(containers), time.Since(start).Round(time.Second))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(jsonOutput, "json", *jsonOutput, "write the progress to stdout as lines of JSON instead of logging it")
+
+	return cmd
+}
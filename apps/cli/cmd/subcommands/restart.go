@@ -0,0 +1,66 @@
+package subcommands
+
+import (
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+func RestartCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "restart [service...]",
+		Short: "restart running services",
+		Long: `Restart the given services (every running service if none is given), dependencies
+first, with their current configuration files, and wait for each to become ready.
+Services are named as for ` + "`projdocs logs`" + `.
+
+Settings that change a service's environment or image only apply once it is
+recreated: restart serve, or run ` + "`projdocs up -d`" + ` again.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			inst, err := loadInstance(cmd)
+			if err != nil {
+				return err
+			}
+			all, err := serviceContainers(inst)
+			if err != nil {
+				return err
+			}
+			containers, err := docker.FindContainers(all, args...)
+			if err != nil {
+				return err
+			}
+
+			dkr, err := connectDocker(cmd.Context())
+			if err != nil {
+				return err
+			}
+			stopped, err := dkr.RestartServices(cmd.Context(), containers)
+			if err != nil {
+				return err
+			}
+
+			var names []string
+			for _, c := range stopped {
+				names = append(names, c.ServiceName())
+			}
+			switch {
+			case len(stopped) == len(containers):
+				return fmt.Errorf("no service to restart is running; start them with `projdocs serve` or `projdocs up -d`")
+			case len(args) > 0 && len(stopped) > 0:
+				return fmt.Errorf("not running, so not restarted: %s", strings.Join(names, ", "))
+			case len(stopped) > 0:
+				logger.Global().Debugf("skipped services that are not running: %s", strings.Join(names, ", "))
+			}
+			logger.Global().Infof("restarted %d services", len(containers)-len(stopped))
+			return nil
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,152 @@
+package subcommands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+func SecretsCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "break-glass access to the encrypted secrets store",
+		Long: fmt.Sprintf(`Inspect the encrypted secrets store of this ProjDocs instance.
+
+The store is unlocked with the master key file in the config dir, or with the
+passphrase in %s if it is set.`, secrets.PassphraseEnv),
+		RunE: utils.HelpFuncRunE,
+	}
+
+	cmd.AddCommand(
+		secretsShowCommand(),
+		secretsExportCommand(),
+	)
+
+	return cmd
+}
+
+// unlockSecrets opens the existing secrets store in the config dir
+func unlockSecrets() (*secrets.Store, error) {
+	dirs, err := utils.GetDirs() // error is checked in persistent prerun
+	if err != nil {
+		return nil, fmt.Errorf("could not get dirs: %w", err)
+	}
+	store, err := secrets.Open(dirs.Config)
+	if errors.Is(err, secrets.ErrNoStore) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("could not unlock secrets store: %w", err)
+	}
+	return store, nil
+}
+
+func secretsShowCommand() *cobra.Command {
+
+	var (
+		reveal *bool = utils.Pointer(false)
+	)
+
+	cmd := &cobra.Command{
+		Use:           "show [name...]",
+		Short:         "show stored secrets (masked unless --reveal is set)",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			store, err := unlockSecrets()
+			if err != nil {
+				return err
+			}
+
+			names := args
+			if len(names) == 0 {
+				names = store.Names()
+			}
+
+			if *reveal {
+				logger.Global().Warnf("revealing %d secret(s) in plaintext", len(names))
+			}
+
+			for _, name := range names {
+				value, ok := store.Get(name)
+				if !ok {
+					return fmt.Errorf("secret %s does not exist", name)
+				}
+				if !*reveal {
+					value = maskSecret(value)
+				}
+				if _, err := fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\n", name, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(reveal, "reveal", *reveal, "print secret values in plaintext")
+
+	return cmd
+}
+
+func secretsExportCommand() *cobra.Command {
+
+	var (
+		format *string = utils.Pointer("json")
+	)
+
+	cmd := &cobra.Command{
+		Use:           "export",
+		Short:         "export every stored secret in plaintext to stdout",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			store, err := unlockSecrets()
+			if err != nil {
+				return err
+			}
+
+			values := map[string]string{}
+			for _, name := range store.Names() {
+				values[name], _ = store.Get(name)
+			}
+			logger.Global().Debugf("exporting %d secret(s) in plaintext", len(values))
+
+			switch *format {
+			case "json":
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(values)
+			case "env":
+				for _, name := range store.Names() {
+					key := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+					if _, err := fmt.Fprintf(cmd.OutOrStdout(), "%s=%q\n", key, values[name]); err != nil {
+						return err
+					}
+				}
+				return nil
+			default:
+				return fmt.Errorf("unsupported format %q (expected json or env)", *format)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(format, "format", "f", *format, "output format (json or env)")
+
+	return cmd
+}
+
+// maskSecret hides all but the first and last characters of long values
+func maskSecret(value string) string {
+	value = strings.TrimSpace(value) // e.g. PEM-encoded keys end with a newline
+	if len(value) <= 8 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:2] + strings.Repeat("*", len(value)-4) + value[len(value)-2:]
+}
@@ -4,15 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/moby/moby/client"
 	"github.com/projdocs/projdocs/apps/cli/internal/config"
 	"github.com/projdocs/projdocs/apps/cli/internal/docker"
 	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase"
 	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
 	"github.com/projdocs/projdocs/apps/cli/internal/server"
 	"github.com/projdocs/projdocs/apps/cli/internal/utils"
 	"github.com/spf13/cobra"
 	"net/http"
+	"path/filepath"
 	"time"
 )
 
@@ -22,62 +23,75 @@ func ServeCommand() *cobra.Command {
 		host      *string = utils.Pointer(server.DefaultHost)
 		port      *uint16 = utils.Pointer(server.DefaultPort)
 		keepAlive *bool   = utils.Pointer(false)
+		detach    *bool   = utils.Pointer(false)
 	)
 
 	cmd := &cobra.Command{
-		Use:           "serve",
-		Short:         "serve projdocs",
-		Long:          `NewServer a ProjDocs instance and all of its required microservices`,
+		Use:     "serve",
+		Aliases: []string{"up"},
+		Short:   "serve projdocs",
+		Long: `NewServer a ProjDocs instance and all of its required microservices.
+
+With --detach, the services are started in the background and keep running after
+serve exits; the internal server is not started, and crashed services are not
+restarted. Manage them with ` + "`projdocs status`, `projdocs restart` and `projdocs down`" + `.
+A later serve reuses the services that are still running, and leaves them running
+when it exits; it only stops and removes the services it started itself.`,
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 
-			// get supabase config
-			home, err := utils.GetHomeDir() // error is checked in persistent prerun
+			// load the local instance, creating it on first use
+			inst, err := createInstance(cmd)
 			if err != nil {
-				return fmt.Errorf("could not get home dir: %w", err)
+				return err
+			}
+
+			// keep a log of the instance in the log dir
+			logFile := filepath.Join(inst.dirs.Log, "serve.log")
+			if closeLog, err := logger.LogToFile(logFile); err != nil {
+				logger.Global().Warnf("logging to the console only: %v", err)
+			} else {
+				defer closeLog()
+				logger.Global().Debugf("logging to %s", logFile)
+			}
+
+			if inst.supabase.Kong.SMTP.Host == "" {
+				logger.Global().Warnf("no SMTP relay is configured: auth emails (invites, password resets) will not be sent; see `projdocs config set smtp.host`")
 			}
 
 			// create docker client
-			api, err := client.New()
+			dkr, err := connectDocker(cmd.Context())
 			if err != nil {
-				return fmt.Errorf("could not initialize docker client: %w", err)
+				return err
 			}
-			dkr := docker.NewClient(api)
 
-			// ping docker
-			ping, err := api.Ping(cmd.Context(), client.PingOptions{})
-			if err != nil {
-				return fmt.Errorf("could not ping docker client: %w", err)
+			// the database must be started with the vault root key it was initialised with
+			if err := inst.supabase.PrepareDataDirs(); err != nil {
+				return err
 			}
-			logger.Global().Debugf("connected timeout: Docker v%s for %s (API v%s)", ping.BuilderVersion, ping.OSType, ping.APIVersion)
 
 			// construct supabase services
-			var containers []*docker.Container
-			if sbCfg, err := config.NewSupabase(
-				// TODO: load vault encryption key dynamically
-				// TODO: NRB 12.24.2025: right now, not using vault, so not a problem
-				"d9bf2393c65c006cc83625f85a27cc50882a391b1e0ab4fd4c2535dbe1f8a283",
-				home,
-			); err != nil {
-				return fmt.Errorf("unable timeout create supabase config: %w", err)
-			} else {
-				if sb, err := supabase.All(
-					sbCfg,
-					docker.ProjDocs,
-				); err != nil {
-					return fmt.Errorf("could not instantiate supabase containers: %w", err)
-				} else {
-					containers = append(containers, sb...)
-				}
+			containers, err := serviceContainers(inst)
+			if err != nil {
+				return err
 			}
 
+			if *detach {
+				return serveDetached(cmd.Context(), dkr, containers)
+			}
+
+			// restart crashed services once they are up
+			supervisor := docker.NewSupervisor(dkr, containers, docker.DefaultSupervisorPolicy)
+
 			// create web server
 			var serveErr chan error
 			var httpServer *server.Server
 			if srv, err := server.NewServer(server.RunConfig{
-				Host: host,
-				Port: port,
+				Host:     utils.Pointer(inst.settings.String("server.host")),
+				Port:     utils.Pointer(inst.settings.Port("server.port")),
+				JWKS:     inst.supabase.Keys.PublicJWKS,
+				Services: supervisor.Status,
 			}); err != nil {
 				return fmt.Errorf("unable timeout create new server: %w", err)
 			} else {
@@ -91,16 +105,26 @@ func ServeCommand() *cobra.Command {
 			logger.Global().Info("starting docker services")
 			dockerRun, cancelDocker := dkr.Run(cmd.Context(), containers)
 			defer cancelDocker(nil)
+			supervised := make(chan struct{})
+			superviseCtx, stopSupervising := context.WithCancel(cmd.Context())
+			defer stopSupervising()
 			select {
 			case <-dockerRun.Done():
 				logger.Global().Warnf("docker failed to start: %s (%s)", dockerRun.Err().Error(), context.Cause(dockerRun))
-				break
+				close(supervised)
 			default:
 				logger.Global().Info("docker services up")
+				go func() {
+					defer close(supervised)
+					supervisor.Run(superviseCtx)
+				}()
+				if previous := inst.supabase.Keys.Previous; previous != nil {
+					go endJwtGraceAt(superviseCtx, dkr, inst, previous.ExpiresAt)
+				}
 			}
 
 			// wait for stop
-			if *keepAlive {
+			if inst.settings.Bool("server.keep_alive") {
 				select {
 				case <-cmd.Context().Done():
 					logger.Global().Debugf("detected command context done (cause=%v)", cmd.Context().Err())
@@ -115,6 +139,8 @@ func ServeCommand() *cobra.Command {
 				select {
 				case <-dockerRun.Done():
 					logger.Global().Debugf("detected docker-run context done (cause=%v)", dockerRun.Err())
+				case status := <-supervisor.Failed():
+					logger.Global().Errorf("service %s keeps crashing (%s); shutting down", status.Name, status.LastError)
 				case <-cmd.Context().Done():
 					logger.Global().Debugf("detected command context done (cause=%v)", cmd.Context().Err())
 				case err = <-serveErr:
@@ -132,6 +158,10 @@ func ServeCommand() *cobra.Command {
 			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
 			defer cancel()
 
+			// stop supervising before the containers are stopped
+			stopSupervising()
+			<-supervised
+
 			// stop docker containers
 			logger.Global().Debugf("shutting down docker")
 			dkr.Stop(ctx, containers)
@@ -153,6 +183,64 @@ func ServeCommand() *cobra.Command {
 	cmd.Flags().StringVarP(host, "host", "H", *host, "host to serve on")
 	cmd.Flags().Uint16VarP(port, "port", "P", *port, "port to serve on")
 	cmd.Flags().BoolVarP(keepAlive, "keep-alive", "k", *keepAlive, "keep serve alive even if docker fails to start")
+	cmd.Flags().BoolVarP(detach, "detach", "d", *detach, "start the services in the background and exit once they are ready")
 
 	return cmd
 }
+
+// serveDetached runs the containers and leaves them running; if they fail to start, the ones that started are
+// stopped again
+func serveDetached(ctx context.Context, dkr *docker.Docker, containers []*docker.Container) error {
+
+	logger.Global().Info("starting docker services in the background")
+	dockerRun, cancelDocker := dkr.Run(ctx, containers)
+	defer cancelDocker(nil)
+
+	select {
+	case <-dockerRun.Done():
+		logger.Global().Warnf("docker failed to start; stopping the services that started")
+		stopCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		dkr.Stop(stopCtx, containers)
+		return fmt.Errorf("could not start the services: %w", context.Cause(dockerRun))
+	default:
+	}
+
+	logger.Global().Info("docker services up; see `projdocs status`, and stop them with `projdocs down`")
+	return nil
+}
+
+// endJwtGraceAt recreates the running services that use the jwt keys once the grace window of the previous keys
+// ends, so they stop accepting them without a restart of serve
+func endJwtGraceAt(ctx context.Context, dkr *docker.Docker, inst *instance, expiresAt time.Time) {
+	for {
+		logger.Global().Debugf("previous jwt keys are accepted until %s", expiresAt.Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(expiresAt)):
+		}
+
+		// the keys may have been rotated again since serve started
+		store, err := secrets.Open(inst.dirs.Config)
+		if err != nil {
+			logger.Global().Errorf("the grace window of the previous jwt keys ended, but the secrets store could not be read (%v); restart serve to revoke them", err)
+			return
+		}
+		cfg, err := config.NewSupabase(store, inst.settings)
+		if err != nil {
+			logger.Global().Errorf("the grace window of the previous jwt keys ended, but the keys could not be loaded (%v); restart serve to revoke them", err)
+			return
+		}
+		if previous := cfg.Keys.Previous; previous != nil && previous.ExpiresAt.After(expiresAt) {
+			expiresAt = previous.ExpiresAt // a later rotation restarted the services, and started a new window
+			continue
+		}
+
+		logger.Global().Infof("the grace window of the previous jwt keys ended; restarting the services that use them")
+		if err := supabase.ApplyKeys(ctx, dkr, cfg); err != nil {
+			logger.Global().Errorf("could not restart the services without the previous jwt keys: %v", err)
+		}
+		return
+	}
+}
@@ -0,0 +1,170 @@
+package subcommands
+
+import (
+	"context"
+	"errors"
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/fake"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/images"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase/kong"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useFakeEngine makes the commands connect to a fake engine with the image of every service
+func useFakeEngine(t *testing.T) *fake.Engine {
+	t.Helper()
+	engine := fake.New()
+	for _, service := range images.Services() {
+		image, err := images.Parse(images.Get(service).String()) // as the settings normalize it
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs, repoDigests := []string{image.Image}, []string(nil)
+		if image.Pinned() {
+			refs, repoDigests = append(refs, image.String()), []string{image.String()}
+		}
+		for _, ref := range refs {
+			engine.AddImage(ref, repoDigests...)
+			// the users that embedded files are owned by
+			engine.AddImageFile(ref, "/etc/passwd", []byte("root:x:0:0:root:/root:/bin/sh\npostgres:x:101:102::/var/lib/postgresql:/bin/sh\nkong:x:1000:1000::/home/kong:/bin/sh\n"))
+		}
+	}
+	connect := connectDocker
+	connectDocker = func(context.Context) (*docker.Docker, error) { return docker.NewClient(engine), nil }
+	t.Cleanup(func() { connectDocker = connect })
+	return engine
+}
+
+func TestServeDetachedRunsServices(t *testing.T) {
+	t.Setenv(utils.HomeEnv, t.TempDir())
+	t.Setenv(secrets.PassphraseEnv, "test")
+	engine := useFakeEngine(t)
+
+	cmd := ServeCommand()
+	cmd.SetArgs([]string{"--detach"})
+	if err := cmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatalf("serve --detach failed: %v", err)
+	}
+
+	calls := engine.Calls()
+	for _, name := range []string{"projdocs-supabase-db", "projdocs-supabase-kong", "projdocs-supabase-auth"} {
+		if !slices.Contains(calls, "ContainerStart "+name) {
+			t.Errorf("expected %s to be started, got %v", name, calls)
+		}
+	}
+	// the services keep running once serve exits
+	if slices.Contains(calls, "ContainerStop projdocs-supabase-db") {
+		t.Errorf("expected the services to keep running, got %v", calls)
+	}
+}
+
+func TestServeEndsJwtGrace(t *testing.T) {
+	t.Setenv(utils.HomeEnv, t.TempDir())
+	t.Setenv(secrets.PassphraseEnv, "test")
+	engine := useFakeEngine(t)
+
+	cmd := ServeCommand()
+	cmd.SetArgs([]string{"--detach"})
+	if err := cmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatalf("serve --detach failed: %v", err)
+	}
+
+	inst, err := loadInstance(ServeCommand())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousAnonKey := inst.supabase.Keys.PublicJwt
+	// the end of the grace window is stored with a precision of a second
+	if err := config.RotateSigningKeys(inst.store, "", 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if inst, err = loadInstance(ServeCommand()); err != nil {
+		t.Fatal(err)
+	}
+	previous := inst.supabase.Keys.Previous
+	if previous == nil {
+		t.Fatal("expected the previous keys to be accepted")
+	}
+
+	dkr, err := connectDocker(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := supabase.ApplyKeys(context.Background(), dkr, inst.supabase); err != nil {
+		t.Fatal(err)
+	}
+	kongConfig := func() string {
+		c, _ := engine.Container(kong.ContainerName)
+		return string(c.Files[kong.ConfigPath].Data)
+	}
+	if !strings.Contains(kongConfig(), previousAnonKey) {
+		t.Fatal("expected kong to accept the previous anon key during the grace window")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	endJwtGraceAt(ctx, dkr, inst, previous.ExpiresAt)
+	if strings.Contains(kongConfig(), previousAnonKey) {
+		t.Errorf("expected kong to stop accepting the previous anon key once the grace window ended")
+	}
+}
+
+func TestCommandsBeforeFirstServe(t *testing.T) {
+	t.Setenv(utils.HomeEnv, t.TempDir())
+	t.Setenv(secrets.PassphraseEnv, "")
+	os.Unsetenv(secrets.PassphraseEnv) // restored by t.Setenv
+	useFakeEngine(t)
+	// the fake engine cannot save the manifests of pinned images, so bundle them unpinned
+	for _, service := range images.Services() {
+		setting, err := config.LookupSetting("images." + service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv(setting.Env(), images.Get(service).Image)
+	}
+
+	execute := func(cmd *cobra.Command, args ...string) error {
+		cmd.SetArgs(args)
+		cmd.SetOut(io.Discard)
+		return cmd.ExecuteContext(context.Background())
+	}
+
+	// these need no secrets
+	if err := execute(PullCommand()); err != nil {
+		t.Errorf("pull failed: %v", err)
+	}
+	if err := execute(ImagesCommand(), "save", filepath.Join(t.TempDir(), "images.tar")); err != nil {
+		t.Errorf("images save failed: %v", err)
+	}
+	if err := execute(KongCommand(), "config"); err != nil {
+		t.Errorf("kong config failed: %v", err)
+	}
+	if err := execute(LogsCommand()); err == nil || !strings.Contains(err.Error(), "no service has been created") {
+		t.Errorf("expected logs to report that no service was created, got %v", err)
+	}
+
+	// exports contain the secrets of an initialised instance
+	if err := execute(ExportCommand(), "compose"); err == nil || !strings.Contains(err.Error(), "initialised instance") {
+		t.Errorf("expected export to require an initialised instance, got %v", err)
+	}
+
+	dirs, err := utils.GetDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dirs.Config, secrets.FileName)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected no secrets store to be created, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package subcommands
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap/zapcore"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func StatusCommand() *cobra.Command {
+
+	var (
+		jsonOutput *bool = utils.Pointer(false)
+	)
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "show the state of every service of the instance",
+		Long: `Show the state, health, uptime, image and published ports of every container of
+the instance, whether it was started by serve, ` + "`projdocs up -d`" + ` or an earlier run.
+
+With --json, the services are written to stdout as a JSON array of objects with
+the fields service, container, image, state, health, started_at, uptime_seconds,
+ports and depends_on.`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			if *jsonOutput {
+				logger.SetLevel(zapcore.ErrorLevel)
+			}
+
+			dkr, err := connectDocker(cmd.Context())
+			if err != nil {
+				return err
+			}
+			statuses, err := dkr.InstanceContainers(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			if *jsonOutput {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(statuses)
+			}
+
+			if len(statuses) == 0 {
+				logger.Global().Infof("no service is running; start them with `projdocs serve` or `projdocs up -d`")
+				return nil
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			if _, err := fmt.Fprintln(w, "SERVICE\tSTATE\tHEALTH\tUPTIME\tIMAGE\tPORTS"); err != nil {
+				return err
+			}
+			for _, s := range statuses {
+				health, uptime := s.Health, "-"
+				if health == "" {
+					health = "-"
+				}
+				if s.StartedAt != nil {
+					uptime = (time.Duration(s.Uptime) * time.Second).String()
+				}
+				if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Service, s.State, health, uptime, s.Image, strings.Join(s.Ports, ", ")); err != nil {
+					return err
+				}
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVar(jsonOutput, "json", *jsonOutput, "write the services to stdout as JSON")
+
+	return cmd
+}
@@ -11,13 +11,16 @@ var (
 
 type Global struct {
 	Verbose *bool
+	Home    *string
 }
 
 func GetGlobal() *Global {
 	initGlobalOnce.Do(func() {
 		var defaultVerbose bool = false
+		var defaultHome string = ""
 		global = &Global{
 			Verbose: &defaultVerbose,
+			Home:    &defaultHome,
 		}
 	})
 	return global
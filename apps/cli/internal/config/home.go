@@ -0,0 +1,83 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"os"
+	"path/filepath"
+)
+
+// HomeMove is a file or dir moved out of a single-dir home
+type HomeMove struct {
+	From string
+	To   string
+}
+
+// legacyConfigFiles are the files of a single-dir home that belong in the config dir
+var legacyConfigFiles = []string{SettingsFileName, InstanceFileName, secrets.FileName, secrets.KeyFileName}
+
+// PlanHomeMigration returns the moves that split a single-dir home (such as the legacy /etc/projdocs) into
+// the given dirs. Files that are already in place are skipped; a move onto an existing file is an error.
+func PlanHomeMigration(from string, to utils.Dirs) ([]HomeMove, error) {
+
+	if stat, err := os.Stat(from); err != nil {
+		return nil, fmt.Errorf("could not read home dir (%s): %w", from, err)
+	} else if !stat.IsDir() {
+		return nil, fmt.Errorf("home dir (%s) is not a directory", from)
+	}
+
+	if _, err := os.Stat(filepath.Join(from, "postgres", "data", "postmaster.pid")); err == nil {
+		return nil, fmt.Errorf("the database in %s appears to be running (postgres/data/postmaster.pid exists); stop ProjDocs first", from)
+	}
+
+	var moves []HomeMove
+	plan := func(names []string, dir string) error {
+		for _, name := range names {
+			move := HomeMove{From: filepath.Join(from, name), To: filepath.Join(dir, name)}
+			if move.From == move.To {
+				continue
+			}
+			if _, err := os.Lstat(move.From); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return fmt.Errorf("could not stat %s: %w", move.From, err)
+			}
+			if _, err := os.Lstat(move.To); err == nil {
+				return fmt.Errorf("cannot move %s: %s already exists", move.From, move.To)
+			}
+			moves = append(moves, move)
+		}
+		return nil
+	}
+	if err := plan(legacyConfigFiles, to.Config); err != nil {
+		return nil, err
+	}
+	if err := plan(utils.LegacyDataDirs, to.Data); err != nil {
+		return nil, err
+	}
+	return moves, nil
+}
+
+// MigrateHome performs the moves of a home migration (see PlanHomeMigration), creating the target dirs.
+// Moves are renames, so ownership and permissions (e.g. of the database files) are kept; a move across
+// filesystems fails and must be done by hand.
+func MigrateHome(moves []HomeMove, to utils.Dirs) error {
+
+	for _, dir := range to.All() {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("could not create %s: %w", dir, err)
+		}
+	}
+
+	for _, move := range moves {
+		if err := os.Rename(move.From, move.To); err != nil {
+			return fmt.Errorf("could not move %s to %s (move it by hand, keeping ownership, then re-run the migration): %w", move.From, move.To, err)
+		}
+		logger.Global().Infof("moved %s to %s", move.From, move.To)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// legacyHome returns a single-dir home holding the given files and dirs
+func legacyHome(t *testing.T, files []string, dirs []string) string {
+	t.Helper()
+	home := t.TempDir()
+	for _, name := range dirs {
+		if err := os.MkdirAll(filepath.Join(home, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(home, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return home
+}
+
+func TestPlanHomeMigration(t *testing.T) {
+	home := legacyHome(t, []string{SettingsFileName, secrets.FileName, "unrelated.txt"}, []string{"postgres/data", "backups"})
+	target := t.TempDir()
+	to := utils.Dirs{Config: home, Data: filepath.Join(target, "data"), Log: filepath.Join(target, "log")}
+
+	moves, err := PlanHomeMigration(home, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the config files stay, as the config dir is the home itself
+	want := []HomeMove{
+		{From: filepath.Join(home, "postgres"), To: filepath.Join(to.Data, "postgres")},
+		{From: filepath.Join(home, "backups"), To: filepath.Join(to.Data, "backups")},
+	}
+	if len(moves) != len(want) || moves[0] != want[0] || moves[1] != want[1] {
+		t.Fatalf("expected %+v, got %+v", want, moves)
+	}
+
+	if err := MigrateHome(moves, to); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{filepath.Join(to.Data, "postgres", "data"), to.Log} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("expected %s to exist: %v", dir, err)
+		}
+	}
+	if utils.HasLegacyData(home) {
+		t.Errorf("expected no data to be left in the home")
+	}
+
+	// a migrated home has nothing left to move
+	if moves, err = PlanHomeMigration(home, to); err != nil || len(moves) != 0 {
+		t.Errorf("expected nothing to move, got %+v (%v)", moves, err)
+	}
+}
+
+func TestPlanHomeMigrationRefuses(t *testing.T) {
+	home := legacyHome(t, []string{SettingsFileName, "postgres/data/postmaster.pid"}, []string{"postgres/data"})
+	to := utils.Dirs{Config: t.TempDir(), Data: t.TempDir(), Log: t.TempDir()}
+	if _, err := PlanHomeMigration(home, to); err == nil || !strings.Contains(err.Error(), "appears to be running") {
+		t.Errorf("expected a running database to be refused, got %v", err)
+	}
+
+	home = legacyHome(t, []string{SettingsFileName}, nil)
+	if err := os.WriteFile(filepath.Join(to.Config, SettingsFileName), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PlanHomeMigration(home, to); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected a move onto an existing file to be refused, got %v", err)
+	}
+
+	if _, err := PlanHomeMigration(filepath.Join(home, "missing"), to); err == nil {
+		t.Errorf("expected a missing home to be refused")
+	}
+}
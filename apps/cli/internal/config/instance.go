@@ -0,0 +1,188 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// InstanceFileName is the name of the instance state file within the config dir
+const InstanceFileName = "instance.json"
+
+// InstanceVersion is the current schema version of the instance state file
+//
+//   - v1: secrets were stored in plaintext in the instance file
+//   - v2: secrets moved to the encrypted secrets store
+const InstanceVersion = 2
+
+// Instance holds the state generated once for an instance and re-used on every subsequent run.
+// Generated secrets are kept in the encrypted secrets store, not in the instance file.
+type Instance struct {
+	Version   int       `json:"version"`
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// instanceV1 is the legacy instance file, which held secrets in plaintext
+type instanceV1 struct {
+	Instance
+	JwtSecret string `json:"jwt_secret"`
+	Database  struct {
+		Password string `json:"password"`
+	} `json:"database"`
+	Dashboard struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"dashboard"`
+	Realtime struct {
+		SecretKeyBase string `json:"secret_key_base"`
+	} `json:"realtime"`
+}
+
+// migrate moves the plaintext secrets of a v1 instance file into the store
+func (v1 *instanceV1) migrate(store *secrets.Store) *Instance {
+	for name, value := range map[string]string{
+		secrets.JwtSecret:             v1.JwtSecret,
+		secrets.DatabasePassword:      v1.Database.Password,
+		secrets.DashboardUsername:     v1.Dashboard.Username,
+		secrets.DashboardPassword:     v1.Dashboard.Password,
+		secrets.RealtimeSecretKeyBase: v1.Realtime.SecretKeyBase,
+	} {
+		if value != "" {
+			store.Set(name, value)
+		}
+	}
+	return &Instance{
+		Version:   InstanceVersion,
+		ID:        v1.ID,
+		CreatedAt: v1.CreatedAt,
+	}
+}
+
+// LoadInstance loads the instance state from the config dir, creating (and persisting) it on first run.
+// Any secrets missing from the store are generated and saved.
+func LoadInstance(settings *Settings, store *secrets.Store) (*Instance, error) {
+
+	dirs := settings.Dirs()
+
+	file := filepath.Join(dirs.Config, InstanceFileName)
+
+	var instance *Instance
+	var dirty bool
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("could not read instance file (%s): %w", file, err)
+		}
+		instance = &Instance{
+			Version:   InstanceVersion,
+			ID:        uuid.New().String(),
+			CreatedAt: time.Now().UTC(),
+		}
+		dirty = true
+	} else {
+		var v1 instanceV1
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return nil, fmt.Errorf("could not parse instance file (%s): %w", file, err)
+		}
+		switch {
+		case v1.Version > InstanceVersion:
+			return nil, fmt.Errorf("instance file (%s) has version %d, but this build only supports up to version %d", file, v1.Version, InstanceVersion)
+		case v1.Version < 1:
+			return nil, fmt.Errorf("instance file (%s) has invalid version %d", file, v1.Version)
+		case v1.Version == 1:
+			logger.Global().Infof("migrating instance file (%s) from v1: moving secrets to the encrypted store", file)
+			instance = v1.migrate(store)
+			dirty = true
+			if err := store.Save(); err != nil {
+				return nil, fmt.Errorf("could not save migrated secrets: %w", err)
+			}
+		default:
+			instance = &v1.Instance
+		}
+		if instance.ID == "" {
+			return nil, fmt.Errorf("instance file (%s) is invalid: id is empty", file)
+		}
+	}
+
+	if err := ensureSecrets(dirs.Data, settings.String("database.data_dir"), store); err != nil {
+		return nil, err
+	}
+
+	// (re-)write the instance file if it is new or was migrated
+	if dirty {
+		if err := instance.Save(dirs.Config); err != nil {
+			return nil, err
+		}
+	}
+
+	return instance, nil
+}
+
+// ensureSecrets generates every instance secret that is not yet in the store
+func ensureSecrets(dataDir string, databaseDir string, store *secrets.Store) error {
+
+	random := func(n int) func() (string, error) {
+		return func() (string, error) {
+			return utils.RandomString(n), nil
+		}
+	}
+
+	generated := false
+	_, created, err := store.GetOrCreate(secrets.JwtSecret, random(32))
+	if err != nil {
+		return err
+	}
+	generated = generated || created
+
+	keys, err := loadSigningKeys(store)
+	if err != nil {
+		return err
+	}
+
+	for name, generate := range map[string]func() (string, error){
+		secrets.AnonKey:               func() (string, error) { return keys.signRoleToken("anon") },
+		secrets.ServiceKey:            func() (string, error) { return keys.signRoleToken("service_role") },
+		secrets.DatabasePassword:      random(32),
+		secrets.DashboardUsername:     random(32),
+		secrets.DashboardPassword:     random(32),
+		secrets.RealtimeSecretKeyBase: random(64),
+		secrets.VaultRootKey:          initialVaultRootKey(dataDir, databaseDir),
+	} {
+		_, created, err := store.GetOrCreate(name, generate)
+		if err != nil {
+			return err
+		}
+		generated = generated || created
+	}
+
+	if generated {
+		logger.Global().Debugf("generated missing instance secrets")
+		if err := store.Save(); err != nil {
+			return fmt.Errorf("could not save generated secrets: %w", err)
+		}
+	}
+	return nil
+}
+
+// Save atomically writes the instance state to the config dir
+func (i *Instance) Save(configDir string) error {
+
+	i.Version = InstanceVersion
+	data, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode instance file: %w", err)
+	}
+
+	file := filepath.Join(configDir, InstanceFileName)
+	if err := utils.WriteFileAtomic(file, data, 0600); err != nil {
+		return fmt.Errorf("could not write instance file (%s): %w", file, err)
+	}
+	return nil
+}
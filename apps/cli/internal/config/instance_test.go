@@ -0,0 +1,75 @@
+package config
+
+import (
+	"encoding/json"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadInstanceMigratesV1(t *testing.T) {
+	dirs := utils.Dirs{Config: t.TempDir(), Data: t.TempDir()}
+	store := newTestStore(t)
+
+	var v1 instanceV1
+	v1.Version = 1
+	v1.ID = "6f1c2a9e-8d0b-4e53-9a57-2f4b8c1d7e30"
+	v1.CreatedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	v1.JwtSecret = "v1-jwt-secret-that-signed-the-existing-keys"
+	v1.Database.Password = "v1-database-password"
+	v1.Dashboard.Username = "v1-dashboard-username"
+	v1.Dashboard.Password = "v1-dashboard-password"
+	v1.Realtime.SecretKeyBase = "v1-realtime-secret-key-base"
+	data, err := json.Marshal(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dirs.Config, InstanceFileName)
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := LoadSettings(dirs, store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, err := LoadInstance(settings, store)
+	if err != nil {
+		t.Fatalf("could not load v1 instance: %v", err)
+	}
+	if instance.Version != InstanceVersion || instance.ID != v1.ID || !instance.CreatedAt.Equal(v1.CreatedAt) {
+		t.Errorf("expected the identity of the v1 instance, got %+v", instance)
+	}
+
+	// the secrets the database and clients already use must survive the migration, as saved to the store
+	saved, err := secrets.Open(filepath.Dir(store.Path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		secrets.JwtSecret:             v1.JwtSecret,
+		secrets.DatabasePassword:      v1.Database.Password,
+		secrets.DashboardUsername:     v1.Dashboard.Username,
+		secrets.DashboardPassword:     v1.Dashboard.Password,
+		secrets.RealtimeSecretKeyBase: v1.Realtime.SecretKeyBase,
+	} {
+		if actual, _ := saved.Get(name); actual != expected {
+			t.Errorf("expected %s to be migrated unchanged, got %q", name, actual)
+		}
+	}
+
+	// the instance file no longer holds them
+	data, err = os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{v1.JwtSecret, v1.Database.Password, v1.Dashboard.Password} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("expected the migrated instance file to hold no secrets, got %s", data)
+		}
+	}
+}
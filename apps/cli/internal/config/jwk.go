@@ -0,0 +1,196 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+)
+
+// supported jwt signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmRS256 = "RS256"
+)
+
+// JWK is a JSON Web Key (RFC 7517); only the members used by the supabase services are modelled
+type JWK struct {
+	Kty    string   `json:"kty"`
+	Alg    string   `json:"alg"`
+	Kid    string   `json:"kid,omitempty"`
+	Use    string   `json:"use,omitempty"`
+	KeyOps []string `json:"key_ops,omitempty"`
+
+	// symmetric
+	K string `json:"k,omitempty"`
+
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+
+	// RSA
+	N  string `json:"n,omitempty"`
+	E  string `json:"e,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	DP string `json:"dp,omitempty"`
+	DQ string `json:"dq,omitempty"`
+	QI string `json:"qi,omitempty"`
+
+	// private (EC and RSA)
+	D string `json:"d,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// String returns the set as compact JSON
+func (s JWKS) String() string {
+	data, err := json.Marshal(s)
+	if err != nil {
+		panic(err) // marshaling plain structs should never fail
+	}
+	return string(data)
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// octJWK returns a symmetric JWK for an HS256 secret; its kid is derived from (but does not reveal) the secret
+func octJWK(secret string, ops ...string) JWK {
+	sum := sha256.Sum256([]byte("projdocs-jwt-secret:" + secret))
+	return JWK{
+		Kty:    "oct",
+		Alg:    AlgorithmHS256,
+		K:      b64([]byte(secret)),
+		Kid:    b64(sum[:12]),
+		KeyOps: ops,
+	}
+}
+
+// publicJWK returns the public JWK of an asymmetric signing key; its kid is the RFC 7638 thumbprint
+func publicJWK(key crypto.Signer) (JWK, error) {
+	var jwk JWK
+	switch pub := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk = JWK{Kty: "EC", Alg: AlgorithmES256, Crv: "P-256", X: b64(pub.X.FillBytes(make([]byte, size))), Y: b64(pub.Y.FillBytes(make([]byte, size)))}
+	case *rsa.PublicKey:
+		jwk = JWK{Kty: "RSA", Alg: AlgorithmRS256, N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())}
+	default:
+		return JWK{}, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	jwk.Kid = thumbprint(jwk)
+	jwk.Use = "sig"
+	jwk.KeyOps = []string{"verify"}
+	return jwk, nil
+}
+
+// privateJWK returns the private JWK of an asymmetric signing key
+func privateJWK(key crypto.Signer) (JWK, error) {
+	jwk, err := publicJWK(key)
+	if err != nil {
+		return JWK{}, err
+	}
+	switch priv := key.(type) {
+	case *ecdsa.PrivateKey:
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		raw, err := priv.Bytes()
+		if err != nil {
+			return JWK{}, err
+		}
+		jwk.D = b64(new(big.Int).SetBytes(raw).FillBytes(make([]byte, size)))
+	case *rsa.PrivateKey:
+		priv.Precompute()
+		jwk.D = b64(priv.D.Bytes())
+		jwk.P = b64(priv.Primes[0].Bytes())
+		jwk.Q = b64(priv.Primes[1].Bytes())
+		jwk.DP = b64(priv.Precomputed.Dp.Bytes())
+		jwk.DQ = b64(priv.Precomputed.Dq.Bytes())
+		jwk.QI = b64(priv.Precomputed.Qinv.Bytes())
+	default:
+		return JWK{}, fmt.Errorf("unsupported private key type %T", key)
+	}
+	jwk.KeyOps = []string{"sign", "verify"}
+	return jwk, nil
+}
+
+// thumbprint returns the RFC 7638 JWK thumbprint of a public key
+func thumbprint(jwk JWK) string {
+	var canonical string
+	switch jwk.Kty {
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Crv, jwk.X, jwk.Y)
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return b64(sum[:])
+}
+
+// generateSigningKey returns a new private key for an asymmetric algorithm
+func generateSigningKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case AlgorithmES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, fmt.Errorf("algorithm %s does not use a signing key", algorithm)
+	}
+}
+
+// encodeSigningKey returns a private key as a PKCS#8 PEM block
+func encodeSigningKey(key crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("could not encode signing key: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// decodeSigningKey parses a PKCS#8 PEM private key
+func decodeSigningKey(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("signing key is not PEM-encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signing key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+	return signer, nil
+}
+
+// signingMethod returns the jwt signing method for an algorithm
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case AlgorithmHS256:
+		return jwt.SigningMethodHS256, nil
+	case AlgorithmES256:
+		return jwt.SigningMethodES256, nil
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q (expected %s, %s or %s)", algorithm, AlgorithmHS256, AlgorithmES256, AlgorithmRS256)
+	}
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestThumbprint(t *testing.T) {
+	// the example of RFC 7638, section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		Alg: AlgorithmRS256,
+		Kid: "2011-04-29",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+	if got, want := thumbprint(jwk), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("expected thumbprint %s, got %s", want, got)
+	}
+}
+
+func TestPrivateJWKMatchesPublicJWK(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmES256, AlgorithmRS256} {
+		key, err := generateSigningKey(algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public, err := publicJWK(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private, err := privateJWK(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if public.Alg != algorithm || public.Kid != thumbprint(public) || public.D != "" {
+			t.Errorf("%s: unexpected public JWK %+v", algorithm, public)
+		}
+		if private.Kid != public.Kid || private.D == "" {
+			t.Errorf("%s: expected the private JWK to share the public kid and hold the private key, got %+v", algorithm, private)
+		}
+	}
+}
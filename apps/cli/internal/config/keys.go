@@ -0,0 +1,343 @@
+package config
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"time"
+)
+
+// signRoleToken returns a JWT pre-configured for Supabase with the given role, signed with the instance's signing key
+func (k KeysConfig) signRoleToken(role string) (string, error) {
+
+	method, err := signingMethod(k.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	issuedAt := time.Now().UTC()
+
+	// Expiration: 20 years later (tokens are revoked by rotating the keys)
+	expiresAt := issuedAt.AddDate(20, 0, 0)
+
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"role": role,
+		"iss":  "supabase",
+		"iat":  issuedAt.Unix(),
+		"exp":  expiresAt.Unix(),
+	})
+
+	var key any = []byte(k.JwtSecret)
+	if k.Asymmetric() {
+		jwk, err := publicJWK(k.SigningKey)
+		if err != nil {
+			return "", err
+		}
+		token.Header["kid"] = jwk.Kid
+		key = k.SigningKey
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign %s JWT: %v", role, err)
+	}
+	return signed, nil
+}
+
+// loadSigningKeys returns the algorithm, jwt secret and (for asymmetric algorithms) signing keys from the store
+func loadSigningKeys(store *secrets.Store) (KeysConfig, error) {
+
+	keys := KeysConfig{Algorithm: AlgorithmHS256}
+	if algorithm, ok := store.Get(secrets.JwtAlgorithm); ok {
+		keys.Algorithm = algorithm
+	}
+	if _, err := signingMethod(keys.Algorithm); err != nil {
+		return keys, err
+	}
+
+	var ok bool
+	if keys.JwtSecret, ok = store.Get(secrets.JwtSecret); !ok || keys.JwtSecret == "" {
+		return keys, fmt.Errorf("secret %s is missing from the secrets store", secrets.JwtSecret)
+	}
+
+	if keys.Asymmetric() {
+		for _, s := range []struct {
+			name string
+			dst  *crypto.Signer
+		}{
+			{secrets.SigningKey, &keys.SigningKey},
+			{secrets.AuthSigningKey, &keys.AuthSigningKey},
+		} {
+			data, ok := store.Get(s.name)
+			if !ok {
+				return keys, fmt.Errorf("secret %s is missing from the secrets store", s.name)
+			}
+			key, err := decodeSigningKey(data)
+			if err != nil {
+				return keys, fmt.Errorf("secret %s: %w", s.name, err)
+			}
+			*s.dst = key
+		}
+	}
+
+	return keys, nil
+}
+
+// loadKeys returns the complete keys config from the store, including the anon and service keys
+// and, during a grace window, the keys replaced by the last rotation
+func loadKeys(store *secrets.Store) (KeysConfig, error) {
+
+	keys, err := loadSigningKeys(store)
+	if err != nil {
+		return keys, err
+	}
+
+	var ok bool
+	if keys.PublicJwt, ok = store.Get(secrets.AnonKey); !ok {
+		return keys, fmt.Errorf("secret %s is missing from the secrets store", secrets.AnonKey)
+	}
+	if keys.PrivateJwt, ok = store.Get(secrets.ServiceKey); !ok {
+		return keys, fmt.Errorf("secret %s is missing from the secrets store", secrets.ServiceKey)
+	}
+
+	keys.Previous, err = previousKeys(store)
+	return keys, err
+}
+
+// previousKeys returns the keys replaced by the last rotation, if their grace window has not ended
+func previousKeys(store *secrets.Store) (*PreviousKeysConfig, error) {
+
+	raw, ok := store.Get(secrets.PreviousKeysExpireAt)
+	if !ok {
+		return nil, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s is not a valid time: %w", secrets.PreviousKeysExpireAt, err)
+	}
+	if !time.Now().Before(expiresAt) {
+		logger.Global().Debugf("grace window for previous keys ended at %s", raw)
+		return nil, nil
+	}
+
+	previous := &PreviousKeysConfig{ExpiresAt: expiresAt}
+	previous.PublicJwt, _ = store.Get(secrets.PreviousAnonKey)
+	previous.PrivateJwt, _ = store.Get(secrets.PreviousServiceKey)
+	jwks, _ := store.Get(secrets.PreviousJWKs)
+	if err := json.Unmarshal([]byte(jwks), &previous.VerificationKeys); err != nil {
+		return nil, fmt.Errorf("secret %s is not a valid JWK array: %w", secrets.PreviousJWKs, err)
+	}
+	if previous.PublicJwt == "" || previous.PrivateJwt == "" || len(previous.VerificationKeys) == 0 {
+		return nil, fmt.Errorf("previous keys are incomplete in the secrets store")
+	}
+	return previous, nil
+}
+
+// RotateSigningKeys generates a new jwt secret (and, for asymmetric algorithms, new signing keys) and re-signs the
+// anon and service_role keys. An empty algorithm keeps the current one.
+// If grace is positive, the replaced keys remain valid until the grace window ends; otherwise they are revoked immediately.
+// The store is saved on success.
+func RotateSigningKeys(store *secrets.Store, algorithm string, grace time.Duration) error {
+
+	current, err := loadSigningKeys(store)
+	if err != nil {
+		return err
+	}
+
+	next := KeysConfig{
+		Algorithm: current.Algorithm,
+		JwtSecret: utils.RandomString(32),
+	}
+	if algorithm != "" {
+		next.Algorithm = algorithm
+	}
+	if _, err := signingMethod(next.Algorithm); err != nil {
+		return err
+	}
+	if next.Asymmetric() {
+		if next.SigningKey, err = generateSigningKey(next.Algorithm); err != nil {
+			return fmt.Errorf("could not generate signing key: %w", err)
+		}
+		if next.AuthSigningKey, err = generateSigningKey(next.Algorithm); err != nil {
+			return fmt.Errorf("could not generate auth signing key: %w", err)
+		}
+	}
+
+	anonKey, err := next.signRoleToken("anon")
+	if err != nil {
+		return err
+	}
+	serviceKey, err := next.signRoleToken("service_role")
+	if err != nil {
+		return err
+	}
+
+	EndJwtGrace(store)
+	if grace > 0 {
+		verificationKeys, err := current.currentVerificationKeys()
+		if err != nil {
+			return err
+		}
+		jwks, err := json.Marshal(verificationKeys)
+		if err != nil {
+			return fmt.Errorf("could not encode previous keys: %w", err)
+		}
+		for name, value := range map[string]string{
+			secrets.PreviousJWKs:         string(jwks),
+			secrets.PreviousKeysExpireAt: time.Now().Add(grace).UTC().Format(time.RFC3339),
+		} {
+			store.Set(name, value)
+		}
+		for previous, current := range map[string]string{
+			secrets.PreviousAnonKey:    secrets.AnonKey,
+			secrets.PreviousServiceKey: secrets.ServiceKey,
+		} {
+			value, _ := store.Get(current)
+			store.Set(previous, value)
+		}
+	}
+
+	store.Set(secrets.JwtAlgorithm, next.Algorithm)
+	store.Set(secrets.JwtSecret, next.JwtSecret)
+	store.Set(secrets.AnonKey, anonKey)
+	store.Set(secrets.ServiceKey, serviceKey)
+	if next.Asymmetric() {
+		for name, key := range map[string]crypto.Signer{
+			secrets.SigningKey:     next.SigningKey,
+			secrets.AuthSigningKey: next.AuthSigningKey,
+		} {
+			encoded, err := encodeSigningKey(key)
+			if err != nil {
+				return err
+			}
+			store.Set(name, encoded)
+		}
+	} else {
+		store.Delete(secrets.SigningKey)
+		store.Delete(secrets.AuthSigningKey)
+	}
+
+	if err := store.Save(); err != nil {
+		return fmt.Errorf("could not save rotated keys: %w", err)
+	}
+	return nil
+}
+
+// EndJwtGrace forgets the keys replaced by the last rotation, so they are no longer accepted; call Save to persist
+func EndJwtGrace(store *secrets.Store) {
+	for _, name := range []string{
+		secrets.PreviousJWKs,
+		secrets.PreviousAnonKey,
+		secrets.PreviousServiceKey,
+		secrets.PreviousKeysExpireAt,
+	} {
+		store.Delete(name)
+	}
+}
+
+// Asymmetric reports whether tokens are signed with a private key rather than the shared jwt secret
+func (k KeysConfig) Asymmetric() bool {
+	return k.Algorithm == AlgorithmES256 || k.Algorithm == AlgorithmRS256
+}
+
+// ServiceSecret returns the shared secret to configure a service with.
+// With a symmetric algorithm this is the jwt secret. With an asymmetric one, each service gets its own secret
+// derived from it (for settings that cannot be left empty), so no service can mint tokens accepted by another.
+func (k KeysConfig) ServiceSecret(service string) string {
+	if !k.Asymmetric() {
+		return k.JwtSecret
+	}
+	mac := hmac.New(sha256.New, []byte(k.JwtSecret))
+	mac.Write([]byte("projdocs-service-secret:" + service))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// currentVerificationKeys returns the keys that verify tokens signed with the current keys
+func (k KeysConfig) currentVerificationKeys() ([]JWK, error) {
+	if !k.Asymmetric() {
+		return []JWK{octJWK(k.JwtSecret, "verify")}, nil
+	}
+	var keys []JWK
+	for _, key := range []crypto.Signer{k.SigningKey, k.AuthSigningKey} {
+		jwk, err := publicJWK(key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, jwk)
+	}
+	return keys, nil
+}
+
+// UsesJWKS reports whether services must be configured with a key set rather than a single shared secret
+func (k KeysConfig) UsesJWKS() bool {
+	return k.Asymmetric() || k.Previous != nil
+}
+
+// VerificationJWKS returns every key services should accept tokens from: the current keys and,
+// until the grace window ends, the previous ones. With an asymmetric algorithm, it contains public keys only.
+func (k KeysConfig) VerificationJWKS() JWKS {
+	keys, err := k.currentVerificationKeys()
+	if err != nil {
+		panic(err) // signing keys are validated when loaded
+	}
+	if k.Previous != nil && time.Now().Before(k.Previous.ExpiresAt) {
+		keys = append(keys, k.Previous.VerificationKeys...)
+	}
+	return JWKS{Keys: keys}
+}
+
+// VerificationSecret returns the current secret, or a JWKS when services must accept more than one key
+func (k KeysConfig) VerificationSecret() string {
+	if !k.UsesJWKS() {
+		return k.JwtSecret
+	}
+	return k.VerificationJWKS().String()
+}
+
+// PublicJWKS returns the asymmetric public keys services accept; it is safe to publish
+func (k KeysConfig) PublicJWKS() JWKS {
+	public := JWKS{Keys: []JWK{}}
+	for _, key := range k.VerificationJWKS().Keys {
+		if key.Kty != "oct" {
+			public.Keys = append(public.Keys, key)
+		}
+	}
+	return public
+}
+
+// AuthJWKs returns the keys for GoTrue (GOTRUE_JWT_KEYS) as a JSON array: the key it signs sessions with, followed by
+// every key it should accept. With an asymmetric algorithm, GoTrue only holds its own private key; its public key is in
+// VerificationJWKS, which the services trust for any role, since they cannot bind a key to some roles. GoTrue can
+// therefore still sign service_role tokens: asymmetric signing does not isolate the keys from GoTrue.
+func (k KeysConfig) AuthJWKs() string {
+	var signing JWK
+	if k.Asymmetric() {
+		jwk, err := privateJWK(k.AuthSigningKey)
+		if err != nil {
+			panic(err) // signing keys are validated when loaded
+		}
+		signing = jwk
+	} else {
+		signing = octJWK(k.JwtSecret, "sign", "verify")
+	}
+
+	keys := []JWK{signing}
+	for _, key := range k.VerificationJWKS().Keys {
+		if key.Kid != signing.Kid {
+			keys = append(keys, key)
+		}
+	}
+
+	data, err := json.Marshal(keys)
+	if err != nil {
+		panic(err) // marshaling plain structs should never fail
+	}
+	return string(data)
+}
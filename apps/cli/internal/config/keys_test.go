@@ -0,0 +1,138 @@
+package config
+
+import (
+	"crypto"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestStore returns a secrets store holding a jwt secret, encrypted with a key file
+func newTestStore(t *testing.T) *secrets.Store {
+	t.Helper()
+	t.Setenv(secrets.KeyFileEnv, "")
+	t.Setenv(secrets.PassphraseEnv, "")
+	os.Unsetenv(secrets.PassphraseEnv) // restored by t.Setenv
+	store, err := secrets.Unlock(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set(secrets.JwtSecret, "initial-secret")
+	return store
+}
+
+// kids returns the kid of every key of a set
+func kids(jwks JWKS) []string {
+	var kids []string
+	for _, key := range jwks.Keys {
+		kids = append(kids, key.Kid)
+	}
+	return kids
+}
+
+func TestRotateSigningKeysSignsWithAlgorithm(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmHS256, AlgorithmES256, AlgorithmRS256} {
+		store := newTestStore(t)
+		if err := RotateSigningKeys(store, algorithm, 0); err != nil {
+			t.Fatalf("%s: rotation failed: %v", algorithm, err)
+		}
+		keys, err := loadKeys(store)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+
+		var verify any = []byte(keys.JwtSecret)
+		if keys.Asymmetric() {
+			verify = keys.SigningKey.Public()
+		}
+		claims := jwt.MapClaims{}
+		token, err := jwt.ParseWithClaims(keys.PublicJwt, claims, func(*jwt.Token) (any, error) { return verify, nil }, jwt.WithValidMethods([]string{algorithm}))
+		if err != nil {
+			t.Fatalf("%s: anon key does not verify: %v", algorithm, err)
+		}
+		if claims["role"] != "anon" {
+			t.Errorf("%s: expected the anon role, got %v", algorithm, claims["role"])
+		}
+
+		jwks := keys.VerificationJWKS()
+		if !keys.Asymmetric() {
+			if keys.UsesJWKS() || keys.VerificationSecret() != keys.JwtSecret {
+				t.Errorf("%s: expected services to verify with the jwt secret", algorithm)
+			}
+			continue
+		}
+		if kid, _ := token.Header["kid"].(string); !slices.Contains(kids(jwks), kid) {
+			t.Errorf("%s: kid %q is not in the verification keys %v", algorithm, kid, kids(jwks))
+		}
+		for _, key := range jwks.Keys {
+			if key.D != "" || key.K != "" {
+				t.Errorf("%s: verification keys must be public, got %+v", algorithm, key)
+			}
+		}
+		if len(keys.PublicJWKS().Keys) != 2 {
+			t.Errorf("%s: expected the public keys of the CLI and Auth, got %v", algorithm, kids(keys.PublicJWKS()))
+		}
+		for _, key := range []crypto.Signer{keys.SigningKey, keys.AuthSigningKey} {
+			if jwk, _ := publicJWK(key); jwk.Alg != algorithm {
+				t.Errorf("%s: expected a signing key for the algorithm, got %s", algorithm, jwk.Alg)
+			}
+		}
+	}
+}
+
+func TestRotateSigningKeysGrace(t *testing.T) {
+	store := newTestStore(t)
+	if err := RotateSigningKeys(store, AlgorithmHS256, 0); err != nil {
+		t.Fatal(err)
+	}
+	before, err := loadKeys(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Previous != nil {
+		t.Fatalf("expected no previous keys without a grace window")
+	}
+
+	if err := RotateSigningKeys(store, AlgorithmES256, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := loadKeys(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Previous == nil || keys.Previous.PublicJwt != before.PublicJwt || keys.Previous.PrivateJwt != before.PrivateJwt {
+		t.Fatalf("expected the replaced keys to be accepted during the grace window, got %+v", keys.Previous)
+	}
+	previousKid := octJWK(before.JwtSecret).Kid
+	if !keys.UsesJWKS() || !slices.Contains(kids(keys.VerificationJWKS()), previousKid) {
+		t.Errorf("expected the previous secret in the verification keys, got %v", kids(keys.VerificationJWKS()))
+	}
+	if slices.Contains(kids(keys.PublicJWKS()), previousKid) {
+		t.Errorf("the previous secret must not be published")
+	}
+
+	// the previous keys are ignored once the window ends, even by a config loaded before
+	keys.Previous.ExpiresAt = time.Now().Add(-time.Second)
+	if slices.Contains(kids(keys.VerificationJWKS()), previousKid) {
+		t.Errorf("expected the previous secret to be dropped once the grace window ended")
+	}
+	store.Set(secrets.PreviousKeysExpireAt, time.Now().Add(-time.Second).UTC().Format(time.RFC3339))
+	if previous, err := previousKeys(store); err != nil || previous != nil {
+		t.Errorf("expected no previous keys once the grace window ended, got %+v (%v)", previous, err)
+	}
+
+	// another rotation without grace revokes them immediately
+	store.Set(secrets.PreviousKeysExpireAt, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	if err := RotateSigningKeys(store, "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err = loadKeys(store); err != nil {
+		t.Fatal(err)
+	}
+	if keys.Algorithm != AlgorithmES256 || keys.Previous != nil {
+		t.Errorf("expected the algorithm to be kept and the previous keys revoked, got %s and %+v", keys.Algorithm, keys.Previous)
+	}
+}
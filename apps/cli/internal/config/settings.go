@@ -0,0 +1,519 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/images"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SettingsFileName is the name of the configuration file within the config dir
+const SettingsFileName = "config.yaml"
+
+// SettingsVersion is the current schema version of the configuration file
+//
+//   - v1: initial schema
+const SettingsVersion = 1
+
+// SettingsEnvPrefix prefixes the environment variable that overrides each setting, e.g. PROJDOCS_SERVER_PORT
+const SettingsEnvPrefix = "PROJDOCS_"
+
+// Source is the configuration layer a setting's value came from
+type Source string
+
+// configuration layers, from lowest to highest precedence
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceSecrets Source = "secrets"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// Kind is the type of a setting's value
+type Kind string
+
+const (
+	KindString Kind = "string"
+	KindBool   Kind = "bool"
+	KindPort   Kind = "port"
+	KindURL    Kind = "url"
+	KindPath   Kind = "path"
+	KindList   Kind = "list" // comma-separated in env vars and flags, a sequence in the config file
+	KindImage  Kind = "image"
+)
+
+// parse validates a raw value, returning it in the type it should be written to the config file with
+func (k Kind) parse(raw string) (any, error) {
+	switch k {
+	case KindBool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", raw)
+		}
+		return b, nil
+	case KindPort:
+		port, err := strconv.ParseUint(raw, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("%q is not a port number (1-65535)", raw)
+		}
+		return int(port), nil
+	case KindURL:
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a URL: %w", raw, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%q is not an absolute http(s) URL", raw)
+		}
+		return raw, nil
+	case KindPath:
+		if !filepath.IsAbs(raw) {
+			return nil, fmt.Errorf("%q is not an absolute path", raw)
+		}
+		return raw, nil
+	case KindList:
+		return splitList(raw), nil
+	case KindImage:
+		if _, err := images.Parse(raw); err != nil {
+			return nil, err
+		}
+		return raw, nil
+	default:
+		return raw, nil
+	}
+}
+
+// Setting describes a configuration key
+type Setting struct {
+	Key         string
+	Kind        Kind
+	Description string
+	Choices     []string                 // allowed values, if restricted
+	Flag        string                   // name of the command-line flag that overrides the setting, if any
+	Secret      string                   // name in the secrets store, for sensitive settings that must not be written to the config file
+	Default     func(s *Settings) string // default value; may be derived from the settings before it
+}
+
+// parse validates a raw value for the setting, returning it in the type it should be written to the config file with
+func (s Setting) parse(raw string) (any, error) {
+	value, err := s.Kind.parse(raw)
+	if err != nil || len(s.Choices) == 0 {
+		return value, err
+	}
+	if !slices.Contains(s.Choices, raw) {
+		return nil, fmt.Errorf("%q is not one of %s", raw, strings.Join(s.Choices, ", "))
+	}
+	return value, nil
+}
+
+// Env returns the name of the environment variable that overrides the setting
+func (s Setting) Env() string {
+	return SettingsEnvPrefix + strings.ToUpper(strings.ReplaceAll(s.Key, ".", "_"))
+}
+
+// Sensitive reports whether the setting is kept in the secrets store
+func (s Setting) Sensitive() bool {
+	return s.Secret != ""
+}
+
+func fixed(value string) func(*Settings) string {
+	return func(*Settings) string {
+		return value
+	}
+}
+
+// splitList returns the items of a comma-separated list
+func splitList(raw string) []string {
+	items := []string{}
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// settings is the schema of the configuration: every supported key, in display order
+var settings = []Setting{
+	{Key: "server.host", Kind: KindString, Flag: "host", Default: fixed("127.0.0.1"), Description: "address the internal server listens on"},
+	{Key: "server.port", Kind: KindPort, Flag: "port", Default: fixed("8080"), Description: "port the internal server listens on"},
+	{Key: "server.keep_alive", Kind: KindBool, Flag: "keep-alive", Default: fixed("false"), Description: "keep serving even if the docker services fail to start"},
+	{Key: "log.verbose", Kind: KindBool, Flag: "verbose", Default: fixed("false"), Description: "log debug output"},
+	{Key: "urls.site", Kind: KindURL, Default: fixed("http://127.0.0.1:3000"), Description: "public base URL of the web app, as users reach it; auth emails and redirects lead here"},
+	{Key: "urls.api", Kind: KindURL, Default: defaultAPIURL, Description: "public URL of the API gateway (Kong), as browsers reach it (default: urls.site's host on port 8000)"},
+	{Key: "urls.redirects", Kind: KindList, Default: defaultRedirects, Description: "URLs auth may redirect to after sign-in, wildcards allowed (default: anywhere on urls.site)"},
+	{Key: "urls.cors_origins", Kind: KindList, Default: defaultCORSOrigins, Description: "origins allowed to call the API from a browser (default: urls.site's origin)"},
+	{Key: "gateway.listen", Kind: KindString, Default: fixed("127.0.0.1:8000"), Description: "host address and port the API gateway (Kong) is published on"},
+	{Key: "smtp.host", Kind: KindString, Default: fixed(""), Description: "SMTP relay host for auth emails (invites, password resets); empty disables them"},
+	{Key: "smtp.port", Kind: KindPort, Default: fixed("587"), Description: "SMTP relay port; Auth uses implicit TLS on 465, and STARTTLS on other ports if the relay offers it"},
+	{Key: "smtp.user", Kind: KindString, Default: fixed(""), Description: "SMTP username; empty disables authentication"},
+	{Key: "smtp.pass", Kind: KindString, Secret: secrets.SmtpPassword, Default: fixed(""), Description: "SMTP password"},
+	{Key: "smtp.sender_email", Kind: KindString, Default: fixed(""), Description: "address auth emails are sent from"},
+	{Key: "smtp.sender_name", Kind: KindString, Default: fixed("ProjDocs"), Description: "name auth emails are sent from"},
+	{Key: "database.data_dir", Kind: KindPath, Default: func(s *Settings) string { return databaseDataDir(s.Dirs().Data) }, Description: "where the database stores its data"},
+	{Key: "storage.data_dir", Kind: KindPath, Default: func(s *Settings) string { return filepath.Join(s.Dirs().Data, "storage", "data") }, Description: "where uploaded files are stored"},
+}
+
+func init() {
+	// one override per service in the image manifest
+	for _, service := range images.Services() {
+		settings = append(settings, Setting{
+			Key:         "images." + service,
+			Kind:        KindImage,
+			Default:     fixed(images.Get(service).String()),
+			Description: fmt.Sprintf("image of the %s service (default: from the image manifest); append @sha256:<digest> to pin it", service),
+		})
+	}
+}
+
+// checks validate settings that depend on each other
+var checks = []func(s *Settings) error{
+	checkURLs,
+	checkSMTP,
+}
+
+// AllSettings returns the schema of every supported configuration key
+func AllSettings() []Setting {
+	return settings
+}
+
+// LookupSetting returns the schema of a configuration key
+func LookupSetting(key string) (Setting, error) {
+	for _, s := range settings {
+		if s.Key == key {
+			return s, nil
+		}
+	}
+	return Setting{}, fmt.Errorf("unknown setting %q (see `projdocs config list`)", key)
+}
+
+// Value is the resolved value of a setting
+type Value struct {
+	Setting
+	Raw    string
+	Source Source
+	Origin string // where the value was read from: the config file, env var or flag
+}
+
+// Settings is the configuration merged from every layer: defaults, the config file (or, for sensitive
+// settings, the secrets store), PROJDOCS_* env vars and command-line flags, in increasing order of precedence
+type Settings struct {
+	dirs     utils.Dirs
+	file     string
+	values   map[string]Value
+	problems []error // file-level problems, e.g. unknown keys
+}
+
+// LoadSettings resolves the configuration for an instance. The store may be nil, in which case sensitive
+// settings are only read from env vars and flags; flags may be nil too.
+// Invalid values do not fail loading; see Validate.
+func LoadSettings(dirs utils.Dirs, store *secrets.Store, flags *pflag.FlagSet) (*Settings, error) {
+
+	s := &Settings{
+		dirs:   dirs,
+		file:   filepath.Join(dirs.Config, SettingsFileName),
+		values: map[string]Value{},
+	}
+
+	doc, err := readSettingsFile(s.file)
+	if err != nil {
+		return nil, err
+	}
+	fromFile := map[string]string{}
+	leaves := flatten("", doc)
+	for _, key := range sortedKeys(leaves) {
+		value := leaves[key]
+		if key == "version" {
+			continue
+		}
+		setting, err := LookupSetting(key)
+		if err != nil {
+			s.problems = append(s.problems, fmt.Errorf("%s: %w", s.file, err))
+			continue
+		}
+		if setting.Sensitive() {
+			s.problems = append(s.problems, fmt.Errorf("%s: %s is sensitive and is ignored in the config file; use `projdocs config set %s` to keep it in the secrets store", s.file, key, key))
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		if list, ok := value.([]any); ok {
+			if setting.Kind != KindList {
+				s.problems = append(s.problems, fmt.Errorf("%s: %s must be a single value", s.file, key))
+				continue
+			}
+			items := make([]string, len(list))
+			for i, item := range list {
+				items[i] = fmt.Sprint(item)
+			}
+			fromFile[key] = strings.Join(items, ",")
+			continue
+		}
+		fromFile[key] = fmt.Sprint(value)
+	}
+
+	for _, setting := range settings {
+
+		v := Value{Setting: setting, Raw: setting.Default(s), Source: SourceDefault}
+
+		if setting.Sensitive() {
+			if store != nil {
+				if raw, ok := store.Get(setting.Secret); ok {
+					v.Raw, v.Source, v.Origin = raw, SourceSecrets, setting.Secret
+				}
+			}
+		} else if raw, ok := fromFile[setting.Key]; ok {
+			v.Raw, v.Source, v.Origin = raw, SourceFile, s.file
+		}
+
+		if raw, ok := os.LookupEnv(setting.Env()); ok {
+			v.Raw, v.Source, v.Origin = raw, SourceEnv, setting.Env()
+		}
+
+		if flags != nil && setting.Flag != "" {
+			if flag := flags.Lookup(setting.Flag); flag != nil && flag.Changed {
+				v.Raw, v.Source, v.Origin = flag.Value.String(), SourceFlag, "--"+setting.Flag
+			}
+		}
+
+		s.values[setting.Key] = v
+	}
+
+	return s, nil
+}
+
+// raw returns the value of a setting that has already been resolved, for deriving defaults
+func (s *Settings) raw(key string) string {
+	return s.values[key].Raw
+}
+
+// Dirs returns the dirs the settings were loaded for
+func (s *Settings) Dirs() utils.Dirs {
+	return s.dirs
+}
+
+// File returns the path of the config file
+func (s *Settings) File() string {
+	return s.file
+}
+
+// All returns every resolved setting, in schema order
+func (s *Settings) All() []Value {
+	all := make([]Value, 0, len(settings))
+	for _, setting := range settings {
+		all = append(all, s.values[setting.Key])
+	}
+	return all
+}
+
+// Get returns the resolved value of a setting
+func (s *Settings) Get(key string) (Value, error) {
+	if _, err := LookupSetting(key); err != nil {
+		return Value{}, err
+	}
+	return s.values[key], nil
+}
+
+// String returns the raw value of a setting; it panics on unknown keys
+func (s *Settings) String(key string) string {
+	v, err := s.Get(key)
+	if err != nil {
+		panic(err)
+	}
+	return v.Raw
+}
+
+// Bool returns the value of a boolean setting; invalid values (see Validate) are false
+func (s *Settings) Bool(key string) bool {
+	b, _ := strconv.ParseBool(s.String(key))
+	return b
+}
+
+// List returns the items of a list setting
+func (s *Settings) List(key string) []string {
+	return splitList(s.String(key))
+}
+
+// Port returns the value of a port setting; invalid values (see Validate) are 0
+func (s *Settings) Port(key string) uint16 {
+	port, _ := strconv.ParseUint(s.String(key), 10, 16)
+	return uint16(port)
+}
+
+// Validate returns every problem with the configuration, joined
+func (s *Settings) Validate() error {
+	problems := append([]error{}, s.problems...)
+	for _, v := range s.All() {
+		if _, err := v.parse(v.Raw); err != nil {
+			problems = append(problems, fmt.Errorf("%s (from %s): %w", v.Key, v.Source, err))
+		}
+	}
+	for _, check := range checks {
+		if err := check(s); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// SetSetting validates and persists a value for a setting: sensitive settings in the (unlocked) store,
+// others in the config file. Env vars and flags still take precedence over it.
+func SetSetting(configDir string, store *secrets.Store, key string, raw string) error {
+
+	setting, err := LookupSetting(key)
+	if err != nil {
+		return err
+	}
+	value, err := setting.parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+
+	if setting.Sensitive() {
+		store.Set(setting.Secret, raw)
+		return store.Save()
+	}
+	return updateSettingsFile(filepath.Join(configDir, SettingsFileName), key, value)
+}
+
+// UnsetSetting removes a persisted value for a setting, so it falls back to its default.
+// Unknown keys are removed from the config file too, so that stale entries can be cleaned up.
+func UnsetSetting(configDir string, store *secrets.Store, key string) error {
+
+	if setting, err := LookupSetting(key); err == nil && setting.Sensitive() {
+		store.Delete(setting.Secret)
+		return store.Save()
+	}
+	return updateSettingsFile(filepath.Join(configDir, SettingsFileName), key, nil)
+}
+
+// readSettingsFile returns the parsed config file, or an empty document if it does not exist
+func readSettingsFile(file string) (map[string]any, error) {
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]any{}, nil
+		}
+		return nil, fmt.Errorf("could not read config file (%s): %w", file, err)
+	}
+
+	doc := map[string]any{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse config file (%s): %w", file, err)
+	}
+	if doc == nil {
+		return map[string]any{}, nil
+	}
+
+	version, ok := doc["version"].(int)
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("config file (%s) has no valid version", file)
+	case version > SettingsVersion:
+		return nil, fmt.Errorf("config file (%s) has version %d, but this build only supports up to version %d", file, version, SettingsVersion)
+	case version < 1:
+		return nil, fmt.Errorf("config file (%s) has invalid version %d", file, version)
+	}
+
+	return doc, nil
+}
+
+// updateSettingsFile sets (or, for a nil value, removes) a key in the config file, creating it if needed
+func updateSettingsFile(file string, key string, value any) error {
+
+	doc, err := readSettingsFile(file)
+	if err != nil {
+		return err
+	}
+
+	// walk down to the parent of the key, creating intermediate sections
+	parts := strings.Split(key, ".")
+	parent := doc
+	for _, part := range parts[:len(parts)-1] {
+		section, ok := parent[part].(map[string]any)
+		if !ok {
+			if value == nil {
+				return nil
+			}
+			section = map[string]any{}
+			parent[part] = section
+		}
+		parent = section
+	}
+	if value == nil {
+		delete(parent, parts[len(parts)-1])
+	} else {
+		parent[parts[len(parts)-1]] = value
+	}
+	prune(doc)
+
+	// keep the version first
+	delete(doc, "version")
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "version: %d\n", SettingsVersion)
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if len(doc) > 0 {
+		if err := enc.Encode(doc); err != nil {
+			return fmt.Errorf("could not encode config file: %w", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("could not encode config file: %w", err)
+	}
+	if err := utils.WriteFileAtomic(file, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("could not write config file (%s): %w", file, err)
+	}
+	return nil
+}
+
+// flatten returns the leaves of a nested document, keyed by their dotted path
+func flatten(prefix string, doc map[string]any) map[string]any {
+	leaves := map[string]any{}
+	for key, value := range doc {
+		if section, ok := value.(map[string]any); ok {
+			for k, v := range flatten(prefix+key+".", section) {
+				leaves[k] = v
+			}
+			continue
+		}
+		leaves[prefix+key] = value
+	}
+	return leaves
+}
+
+// prune removes sections left empty by removing keys
+func prune(doc map[string]any) {
+	for key, value := range doc {
+		if section, ok := value.(map[string]any); ok {
+			prune(section)
+			if len(section) == 0 {
+				delete(doc, key)
+			}
+		}
+	}
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
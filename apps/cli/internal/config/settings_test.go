@@ -0,0 +1,99 @@
+package config
+
+import (
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/spf13/pflag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSettingsPrecedence(t *testing.T) {
+	dirs := utils.Dirs{Config: t.TempDir(), Data: t.TempDir()}
+	setting, err := LookupSetting("server.port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(setting.Env(), "")
+	os.Unsetenv(setting.Env()) // restored by t.Setenv
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String(setting.Flag, "", "")
+
+	expect := func(raw string, source Source) {
+		t.Helper()
+		s, err := LoadSettings(dirs, nil, flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := s.Get("server.port"); v.Raw != raw || v.Source != source {
+			t.Errorf("expected %s from %s, got %s from %s", raw, source, v.Raw, v.Source)
+		}
+		if err := s.Validate(); err != nil {
+			t.Errorf("expected valid settings, got %v", err)
+		}
+	}
+
+	expect("8080", SourceDefault)
+	if err := SetSetting(dirs.Config, nil, "server.port", "8081"); err != nil {
+		t.Fatal(err)
+	}
+	expect("8081", SourceFile)
+	t.Setenv(setting.Env(), "8082")
+	expect("8082", SourceEnv)
+	if err := flags.Set(setting.Flag, "8083"); err != nil {
+		t.Fatal(err)
+	}
+	expect("8083", SourceFlag)
+}
+
+func TestSettingsDeriveDefaults(t *testing.T) {
+	dirs := utils.Dirs{Config: t.TempDir(), Data: t.TempDir()}
+	if err := SetSetting(dirs.Config, nil, "urls.site", "https://docs.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSetting(dirs.Config, nil, "urls.api", "https://api.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSettings(dirs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origins := s.List("urls.cors_origins"); len(origins) != 1 || origins[0] != "https://docs.example.com" {
+		t.Errorf("expected the CORS origins to default to the site's origin, got %v", origins)
+	}
+}
+
+func TestSensitiveSettingsAreNotReadFromFile(t *testing.T) {
+	dirs := utils.Dirs{Config: t.TempDir(), Data: t.TempDir()}
+	t.Setenv(secrets.KeyFileEnv, "")
+	t.Setenv(secrets.PassphraseEnv, "")
+	os.Unsetenv(secrets.PassphraseEnv) // restored by t.Setenv
+	store, err := secrets.Unlock(dirs.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dirs.Config, SettingsFileName)
+	if err := os.WriteFile(file, []byte("version: 1\nsmtp:\n  pass: from-file\n  bogus: x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSetting(dirs.Config, store, "smtp.pass", "from-store"); err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadSettings(dirs, store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Get("smtp.pass"); v.Raw != "from-store" || v.Source != SourceSecrets {
+		t.Errorf("expected the password from the secrets store, got %s from %s", v.Raw, v.Source)
+	}
+	err = s.Validate()
+	if err == nil || !strings.Contains(err.Error(), "smtp.pass is sensitive") || !strings.Contains(err.Error(), `unknown setting "smtp.bogus"`) {
+		t.Errorf("expected the sensitive and unknown keys of the file to be reported, got %v", err)
+	}
+	if data, _ := os.ReadFile(file); strings.Contains(string(data), "from-store") {
+		t.Errorf("sensitive setting was written to the config file")
+	}
+}
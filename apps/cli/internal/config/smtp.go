@@ -0,0 +1,26 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+)
+
+// SMTPImplicitTLSPort is the only port Auth (GoTrue) connects to over implicit TLS; on any other port it
+// upgrades the connection with STARTTLS if the relay offers it, and stays in plaintext otherwise
+const SMTPImplicitTLSPort = 465
+
+// checkSMTP validates the SMTP settings together; they are only required once a relay is configured
+func checkSMTP(s *Settings) error {
+
+	if s.String("smtp.host") == "" {
+		return nil
+	}
+
+	var problems []error
+	if _, err := mail.ParseAddress(s.String("smtp.sender_email")); err != nil {
+		problems = append(problems, fmt.Errorf("smtp.sender_email must be a valid address when smtp.host is set: %w", err))
+	}
+
+	return errors.Join(problems...)
+}
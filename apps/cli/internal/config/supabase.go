@@ -1,20 +1,34 @@
 package config
 
 import (
+	"crypto"
+	"errors"
 	"fmt"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase/kong"
-	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/images"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/secrets"
 	"os"
-	"path/filepath"
 	"time"
 )
 
+type PreviousKeysConfig struct {
+	PublicJwt        string
+	PrivateJwt       string
+	VerificationKeys []JWK     // keys that verified tokens before the rotation
+	ExpiresAt        time.Time // end of the grace window in which the previous keys are still accepted
+}
+
+// KeysConfig holds the keys of the instance. With ES256 or RS256, only the CLI and GoTrue hold private keys, but
+// GoTrue's key is trusted for every role (see AuthJWKs): asymmetric signing protects against the other services only.
 type KeysConfig struct {
-	JwtSecret          string
+	Algorithm          string        // HS256, ES256 or RS256
+	JwtSecret          string        // shared signing secret (HS256), or the root of per-service secrets (ES256/RS256)
+	SigningKey         crypto.Signer // signs the anon and service_role keys; held by the CLI only (ES256/RS256)
+	AuthSigningKey     crypto.Signer // signs user sessions; held by GoTrue only, but accepted for any role (ES256/RS256)
 	PublicJwt          string
 	PrivateJwt         string
 	PgSodiumEncryption string
+	Previous           *PreviousKeysConfig // set during a rotation grace window
 }
 
 type StorageConfig struct {
@@ -27,8 +41,13 @@ type DashboardConfig struct {
 }
 
 type DatabaseConfig struct {
-	DataDirectory string
-	Password      string
+	DataDirectory        string
+	VaultFingerprintFile string // records which vault root key the database was initialised with
+	Password             string
+}
+
+type RealtimeConfig struct {
+	SecretKeyBase string
 }
 
 type KongSMTPFromConfig struct {
@@ -37,7 +56,7 @@ type KongSMTPFromConfig struct {
 }
 
 type KongSMTPConfig struct {
-	Host string
+	Host string // empty if no relay is configured
 	Port uint16
 	User string
 	Pass string
@@ -45,105 +64,161 @@ type KongSMTPConfig struct {
 }
 
 type KongURLsConfig struct {
-	Site string // where the frontend Site is publicly accessible
-	Kong string // where Kong is publicly accessible
+	Site        string   // where the frontend Site is publicly accessible
+	Kong        string   // where Kong is publicly accessible
+	Redirects   []string // where auth may redirect to
+	CORSOrigins []string // origins browsers may call Kong from
+}
+
+type KongListenConfig struct {
+	Host string // host address Kong is published on
+	Port uint16
 }
 
 type KongConfig struct {
-	URLs KongURLsConfig
-	SMTP KongSMTPConfig
+	URLs   KongURLsConfig
+	Listen KongListenConfig
+	SMTP   KongSMTPConfig
 }
 
+// ImagesConfig maps each service to its (possibly overridden) image
+type ImagesConfig map[string]images.Image
+
 type Supabase struct {
+	Images    ImagesConfig
 	Database  DatabaseConfig
 	Storage   StorageConfig
 	Dashboard DashboardConfig
 	Keys      KeysConfig
+	Realtime  RealtimeConfig
 	Kong      KongConfig
 }
 
-func NewSupabase(vaultEncryptionKey string, homeDir string) (*Supabase, error) {
-	jwtSecret := utils.RandomString(32)
+// NewImages resolves the image of every service from the manifest and the images.* settings; unlike NewSupabase,
+// it needs no secrets
+func NewImages(settings *Settings) (ImagesConfig, error) {
+	imgs := ImagesConfig{}
+	for _, service := range images.Services() {
+		image, err := images.Parse(settings.String("images." + service))
+		if err != nil {
+			return nil, fmt.Errorf("invalid images.%s: %w", service, err)
+		}
+		if v, err := settings.Get("images." + service); err == nil && v.Source != SourceDefault && !image.Pinned() {
+			logger.Global().Warnf("%s image is overridden with %s, which is not pinned to a digest", service, image.Image)
+		}
+		imgs[service] = image
+	}
+	return imgs, nil
+}
+
+// NewSupabase builds the Supabase configuration from the settings, sourcing keys and credentials from the unlocked secrets store
+func NewSupabase(store *secrets.Store, settings *Settings) (*Supabase, error) {
 
-	keys, err := getJwtKeysConfig(jwtSecret)
+	get := func(name string) (string, error) {
+		if value, ok := store.Get(name); !ok || value == "" {
+			return "", fmt.Errorf("secret %s is missing from the secrets store", name)
+		} else {
+			return value, nil
+		}
+	}
+
+	keys, err := loadKeys(store)
 	if err != nil {
-		return nil, fmt.Errorf("failed to construct jwt keys config: %v", err)
+		return nil, err
 	}
-	keys.PgSodiumEncryption = vaultEncryptionKey
 
-	if stat, err := os.Stat(homeDir); err != nil || !stat.IsDir() {
-		return nil, fmt.Errorf("home dir '%s' does not exist", homeDir)
+	var database DatabaseConfig
+	var dashboard DashboardConfig
+	var realtime RealtimeConfig
+	for _, s := range []struct {
+		name string
+		dst  *string
+	}{
+		{secrets.DatabasePassword, &database.Password},
+		{secrets.DashboardUsername, &dashboard.Username},
+		{secrets.DashboardPassword, &dashboard.Password},
+		{secrets.RealtimeSecretKeyBase, &realtime.SecretKeyBase},
+		{secrets.VaultRootKey, &keys.PgSodiumEncryption},
+	} {
+		value, err := get(s.name)
+		if err != nil {
+			return nil, err
+		}
+		*s.dst = value
+	}
+	listenHost, listenPort, err := parseListen(settings.String("gateway.listen"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gateway.listen: %w", err)
+	}
+
+	imgs, err := NewImages(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	dataDir := settings.Dirs().Data
+	if stat, err := os.Stat(dataDir); err != nil {
+		return nil, fmt.Errorf("data dir '%s' does not exist", dataDir)
 	} else if !stat.IsDir() {
-		return nil, fmt.Errorf("home dir '%s' is not a directory", homeDir)
+		return nil, fmt.Errorf("data dir '%s' is not a directory", dataDir)
 	}
 
 	return &Supabase{
-		Keys: *keys,
+		Images: imgs,
+		Keys:   keys,
 		Database: DatabaseConfig{
-			DataDirectory: filepath.Join(homeDir, "postgres", "data"),
-			Password:      utils.RandomString(32),
+			DataDirectory:        settings.String("database.data_dir"),
+			VaultFingerprintFile: vaultFingerprintFile(dataDir),
+			Password:             database.Password,
 		},
 		Storage: StorageConfig{
-			DataDirectory: filepath.Join(homeDir, "storage", "data"),
-		},
-		Dashboard: DashboardConfig{
-			Username: utils.RandomString(32),
-			Password: utils.RandomString(32),
+			DataDirectory: settings.String("storage.data_dir"),
 		},
+		Dashboard: dashboard,
+		Realtime:  realtime,
 		Kong: KongConfig{
 			URLs: KongURLsConfig{
-				Site: "http://127.0.0.1:3000",
-				Kong: fmt.Sprintf("http://%s:8000", kong.ContainerName),
+				Site:        settings.String("urls.site"),
+				Kong:        settings.String("urls.api"),
+				Redirects:   settings.List("urls.redirects"),
+				CORSOrigins: settings.List("urls.cors_origins"),
+			},
+			Listen: KongListenConfig{
+				Host: listenHost,
+				Port: listenPort,
 			},
 			SMTP: KongSMTPConfig{
-				Host: "supabase-mail",
-				Port: 2500,
-				User: "fake_mail_user",
-				Pass: "fake_mail_password",
+				Host: settings.String("smtp.host"),
+				Port: settings.Port("smtp.port"),
+				User: settings.String("smtp.user"),
+				Pass: settings.String("smtp.pass"),
 				From: KongSMTPFromConfig{
-					Email: "admin@example.com",
-					Name:  "fake_sender",
+					Email: settings.String("smtp.sender_email"),
+					Name:  settings.String("smtp.sender_name"),
 				},
 			},
 		},
 	}, nil
 }
 
-// getJwtKeysConfig returns deterministic JWTs (as a KeysConfig) pre-configured for Supabase, based on a fixed secret
-func getJwtKeysConfig(jwtSecret string) (*KeysConfig, error) {
-
-	// Fixed issued-at: 01 Jan 2025 00:00:00 UTC
-	issuedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
-
-	// Expiration: 20 years later
-	expiresAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(20, 0, 0).Unix()
-
-	makeToken := func(role string) (*string, error) {
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"role": role,
-			"iss":  "supabase",
-			"iat":  issuedAt,
-			"exp":  expiresAt,
-		})
-		signed, err := token.SignedString([]byte(jwtSecret))
-		if err != nil {
-			return nil, fmt.Errorf("failed to sign %s JWT: %v", role, err)
+// PrepareDataDirs creates the data dirs of the database and storage if they are missing, and refuses a database
+// that was initialised with another vault root key (see VerifyVaultKey); it is called before the services start
+func (cfg *Supabase) PrepareDataDirs() error {
+
+	for _, dir := range []struct{ name, path string }{
+		{"database", cfg.Database.DataDirectory},
+		{"storage", cfg.Storage.DataDirectory},
+	} {
+		if stat, err := os.Stat(dir.path); errors.Is(err, os.ErrNotExist) {
+			if err := os.MkdirAll(dir.path, 0755); err != nil {
+				return fmt.Errorf("could not create %s dir: %w", dir.name, err)
+			}
+		} else if err != nil {
+			return fmt.Errorf("could not get %s dir (%s): %w", dir.name, dir.path, err)
+		} else if !stat.IsDir() {
+			return fmt.Errorf("%s dir (%s) is not a directory", dir.name, dir.path)
 		}
-		return &signed, nil
-	}
-
-	anonKey, err := makeToken("anon")
-	if err != nil {
-		return nil, err
-	}
-	serviceKey, err := makeToken("service_role")
-	if err != nil {
-		return nil, err
 	}
 
-	return &KeysConfig{
-		JwtSecret:  jwtSecret,
-		PublicJwt:  *anonKey,
-		PrivateJwt: *serviceKey,
-	}, nil
+	return cfg.VerifyVaultKey()
 }
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase/kong"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultAPIPort is the port the API gateway (Kong) listens on
+const DefaultAPIPort = 8000
+
+// defaultAPIURL returns urls.site's host on the API gateway's port
+func defaultAPIURL(s *Settings) string {
+	site, err := url.Parse(s.raw("urls.site"))
+	if err != nil || site.Hostname() == "" {
+		return "" // urls.site is reported as invalid
+	}
+	return fmt.Sprintf("%s://%s", site.Scheme, net.JoinHostPort(site.Hostname(), strconv.Itoa(DefaultAPIPort)))
+}
+
+// defaultRedirects allows redirects to anywhere on urls.site
+func defaultRedirects(s *Settings) string {
+	return strings.TrimSuffix(s.raw("urls.site"), "/") + "/**"
+}
+
+// defaultCORSOrigins allows browser calls from urls.site's origin
+func defaultCORSOrigins(s *Settings) string {
+	site, err := url.Parse(s.raw("urls.site"))
+	if err != nil || site.Host == "" {
+		return ""
+	}
+	return site.Scheme + "://" + site.Host
+}
+
+// isLoopback reports whether a host name only resolves to this machine
+func isLoopback(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// effectivePort returns a URL's port, defaulting to its scheme's
+func effectivePort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// parseListen splits a host:port address the gateway is published on
+func parseListen(listen string) (string, uint16, error) {
+	host, rawPort, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", 0, fmt.Errorf("%q is not a host:port address: %w", listen, err)
+	}
+	if net.ParseIP(host) == nil {
+		return "", 0, fmt.Errorf("%q must be an IP address and port, e.g. 0.0.0.0:%d", listen, DefaultAPIPort)
+	}
+	port, err := strconv.ParseUint(rawPort, 10, 16)
+	if err != nil || port == 0 {
+		return "", 0, fmt.Errorf("%q does not have a valid port", listen)
+	}
+	return host, uint16(port), nil
+}
+
+// checkURLs validates that the public URLs are consistent with each other and with how the gateway is published
+func checkURLs(s *Settings) error {
+
+	site, siteErr := url.Parse(s.String("urls.site"))
+	api, apiErr := url.Parse(s.String("urls.api"))
+	if siteErr != nil || apiErr != nil || site.Host == "" || api.Host == "" {
+		return nil // reported as invalid values
+	}
+
+	var problems []error
+	for _, u := range []struct {
+		key string
+		url *url.URL
+	}{
+		{"urls.site", site},
+		{"urls.api", api},
+	} {
+		if u.url.RawQuery != "" || u.url.Fragment != "" {
+			problems = append(problems, fmt.Errorf("%s (%s) must not have a query or fragment", u.key, u.url))
+		}
+	}
+
+	if site.Scheme == "https" && api.Scheme == "http" {
+		problems = append(problems, fmt.Errorf("urls.site is https but urls.api (%s) is http: browsers block calls from the web app to the API (mixed content)", api))
+	}
+	if strings.HasPrefix(api.Hostname(), "projdocs-") || api.Hostname() == kong.ContainerName {
+		problems = append(problems, fmt.Errorf("urls.api (%s) is an internal container name, which browsers cannot resolve", api))
+	}
+	if isLoopback(site.Hostname()) != isLoopback(api.Hostname()) {
+		problems = append(problems, fmt.Errorf("urls.site (%s) and urls.api (%s) must both be loopback addresses, or both be reachable from other machines", site, api))
+	}
+
+	for _, redirect := range s.List("urls.redirects") {
+		// wildcards are not valid in URLs, but are in GoTrue's allow list
+		if u, err := url.Parse(strings.ReplaceAll(redirect, "*", "x")); err != nil || u.Scheme == "" {
+			problems = append(problems, fmt.Errorf("urls.redirects: %q is not an absolute URL", redirect))
+		}
+	}
+
+	origins := s.List("urls.cors_origins")
+	if len(origins) == 0 {
+		problems = append(problems, errors.New("urls.cors_origins must not be empty (use * to allow every origin)"))
+	}
+	for _, origin := range origins {
+		if origin == "*" {
+			if len(origins) > 1 {
+				problems = append(problems, errors.New("urls.cors_origins: * allows every origin and cannot be combined with others"))
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" {
+			problems = append(problems, fmt.Errorf("urls.cors_origins: %q is not an origin (scheme://host[:port])", origin))
+		}
+	}
+
+	host, port, err := parseListen(s.String("gateway.listen"))
+	if err != nil {
+		problems = append(problems, fmt.Errorf("gateway.listen: %w", err))
+	} else if isLoopback(host) && !isLoopback(api.Hostname()) && effectivePort(api) == strconv.Itoa(int(port)) {
+		// with a different port, a reverse proxy on this machine may forward to the gateway
+		problems = append(problems, fmt.Errorf("gateway.listen (%s) only accepts connections from this machine, but urls.api (%s) is not a loopback address; publish the gateway with e.g. `projdocs config set gateway.listen 0.0.0.0:%d`, or put a reverse proxy in front of it", s.String("gateway.listen"), api, port))
+	}
+
+	return errors.Join(problems...)
+}
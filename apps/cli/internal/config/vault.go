@@ -0,0 +1,133 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// legacyVaultRootKey is the pgsodium root key that was hardcoded for every install before per-instance keys.
+// It is only adopted for databases that were initialised with it, so they remain readable until rotated.
+const legacyVaultRootKey = "d9bf2393c65c006cc83625f85a27cc50882a391b1e0ab4fd4c2535dbe1f8a283"
+
+// GenerateVaultRootKey returns a new random 256-bit pgsodium root key, hex-encoded
+func GenerateVaultRootKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("could not generate vault root key: %w", err)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// VaultKeyFingerprint returns a non-secret identifier for a vault root key
+func VaultKeyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte("projdocs-vault-root-key:" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func databaseDataDir(dataDir string) string {
+	return filepath.Join(dataDir, "postgres", "data")
+}
+
+func vaultFingerprintFile(dataDir string) string {
+	return filepath.Join(dataDir, "postgres", "vault-key.sha256")
+}
+
+// isDirEmpty reports whether dir is missing or has no entries
+func isDirEmpty(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+	return len(entries) == 0, nil
+}
+
+// initialVaultRootKey returns the vault root key for an instance that does not have one in its secrets store yet
+func initialVaultRootKey(dataDir string, databaseDir string) func() (string, error) {
+	return func() (string, error) {
+		empty, err := isDirEmpty(databaseDir)
+		if err != nil {
+			return "", fmt.Errorf("could not read database dir: %w", err)
+		}
+		if !empty {
+			logger.Global().Warnf("existing database was initialised with the legacy shared vault key; run `projdocs keys vault rotate` to replace it")
+			return legacyVaultRootKey, nil
+		}
+		key, err := GenerateVaultRootKey()
+		if err != nil {
+			return "", err
+		}
+		if _, err := BackupVaultRootKey(dataDir, key); err != nil {
+			return "", err
+		}
+		return key, nil
+	}
+}
+
+// BackupVaultRootKey writes a copy of a vault root key to the data dir's backups, readable only by the owner.
+// Losing the root key makes every vault secret unrecoverable, so the backup is kept outside the secrets store.
+func BackupVaultRootKey(dataDir string, key string) (string, error) {
+
+	dir := filepath.Join(dataDir, "backups", "vault")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("could not create vault backup dir: %w", err)
+	}
+
+	file := filepath.Join(dir, fmt.Sprintf("%s-%s.key", time.Now().UTC().Format("20060102T150405Z"), VaultKeyFingerprint(key)[:12]))
+	if err := utils.WriteFileAtomic(file, []byte(key+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("could not back up vault root key: %w", err)
+	}
+	logger.Global().Infof("backed up vault root key to %s", file)
+	return file, nil
+}
+
+// VerifyVaultKey checks that the configured vault root key is the one the database was initialised with.
+// The fingerprint is (re-)recorded whenever the database dir is empty, i.e. about to be initialised.
+func (cfg *Supabase) VerifyVaultKey() error {
+
+	expected := VaultKeyFingerprint(cfg.Keys.PgSodiumEncryption)
+	file := cfg.Database.VaultFingerprintFile
+
+	empty, err := isDirEmpty(cfg.Database.DataDirectory)
+	if err != nil {
+		return fmt.Errorf("could not read database dir: %w", err)
+	}
+
+	if data, err := os.ReadFile(file); err == nil {
+		if actual := strings.TrimSpace(string(data)); actual != expected && !empty {
+			return fmt.Errorf("vault root key does not match the key the database was initialised with (expected fingerprint %s, database has %s); restore the key from backups or the secrets store", expected[:12], actual[:min(12, len(actual))])
+		} else if actual == expected {
+			return nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not read vault key fingerprint (%s): %w", file, err)
+	}
+
+	if !empty {
+		logger.Global().Warnf("database dir has no vault key fingerprint; recording the current key's")
+	}
+	return cfg.RecordVaultKey()
+}
+
+// RecordVaultKey stores the fingerprint of the configured vault root key alongside the database dir
+func (cfg *Supabase) RecordVaultKey() error {
+	file := cfg.Database.VaultFingerprintFile
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("could not create database dir: %w", err)
+	}
+	if err := utils.WriteFileAtomic(file, []byte(VaultKeyFingerprint(cfg.Keys.PgSodiumEncryption)+"\n"), 0644); err != nil {
+		return fmt.Errorf("could not write vault key fingerprint (%s): %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInitialVaultRootKey(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		database   []string // files in the database dir; nil if it does not exist
+		wantLegacy bool
+	}{
+		{name: "no database dir"},
+		{name: "empty database dir", database: []string{}},
+		{name: "initialised database", database: []string{"PG_VERSION"}, wantLegacy: true},
+	} {
+		dataDir := t.TempDir()
+		databaseDir := databaseDataDir(dataDir)
+		if test.database != nil {
+			if err := os.MkdirAll(databaseDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, file := range test.database {
+			if err := os.WriteFile(filepath.Join(databaseDir, file), []byte("17\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		key, err := initialVaultRootKey(dataDir, databaseDir)()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		backups, _ := filepath.Glob(filepath.Join(dataDir, "backups", "vault", "*.key"))
+		if test.wantLegacy {
+			// a database initialised with the shared key stays readable
+			if key != legacyVaultRootKey || len(backups) != 0 {
+				t.Errorf("%s: expected the legacy key without a backup, got %s and %v", test.name, key, backups)
+			}
+			continue
+		}
+		if key == legacyVaultRootKey || len(key) != 64 {
+			t.Errorf("%s: expected a new key, got %s", test.name, key)
+		}
+		if len(backups) != 1 {
+			t.Fatalf("%s: expected the new key to be backed up, got %v", test.name, backups)
+		}
+		if data, err := os.ReadFile(backups[0]); err != nil || strings.TrimSpace(string(data)) != key {
+			t.Errorf("%s: expected the backup to hold the new key, got %q (%v)", test.name, data, err)
+		}
+	}
+}
+
+func TestVerifyVaultKey(t *testing.T) {
+	const key = "0000000000000000000000000000000000000000000000000000000000000001"
+	other := VaultKeyFingerprint("another key")
+
+	for _, test := range []struct {
+		name        string
+		initialised bool   // whether the database dir has files
+		fingerprint string // recorded before; empty if none
+		wantErr     string
+	}{
+		{name: "empty dir", fingerprint: ""},
+		{name: "empty dir with the fingerprint of another key", fingerprint: other},
+		{name: "matching key", initialised: true, fingerprint: VaultKeyFingerprint(key)},
+		{name: "mismatch", initialised: true, fingerprint: other, wantErr: "does not match"},
+		{name: "missing fingerprint", initialised: true, fingerprint: ""},
+	} {
+		dataDir := t.TempDir()
+		cfg := &Supabase{
+			Keys:     KeysConfig{PgSodiumEncryption: key},
+			Database: DatabaseConfig{DataDirectory: databaseDataDir(dataDir), VaultFingerprintFile: vaultFingerprintFile(dataDir)},
+		}
+		if err := os.MkdirAll(cfg.Database.DataDirectory, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if test.initialised {
+			if err := os.WriteFile(filepath.Join(cfg.Database.DataDirectory, "PG_VERSION"), []byte("17\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if test.fingerprint != "" {
+			if err := os.WriteFile(cfg.Database.VaultFingerprintFile, []byte(test.fingerprint+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := cfg.VerifyVaultKey()
+		recorded, _ := os.ReadFile(cfg.Database.VaultFingerprintFile)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: expected an error containing %q, got %v", test.name, test.wantErr, err)
+			}
+			// the fingerprint of the database is kept, so the right key can still be found
+			if strings.TrimSpace(string(recorded)) != test.fingerprint {
+				t.Errorf("%s: expected the fingerprint to be kept, got %q", test.name, recorded)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if strings.TrimSpace(string(recorded)) != VaultKeyFingerprint(key) {
+			t.Errorf("%s: expected the fingerprint of the key to be recorded, got %q", test.name, recorded)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+)
+
+// ConfigHashLabel is the label that records the hash of the configuration a container was created with (see
+// ConfigHash)
+const ConfigHashLabel = "com.projdocs.config-hash"
+
+// ConfigHash returns a hash of everything a container is created with, including its embedded files, so that an
+// existing container can be checked for drift from its definition
+func (c *Container) ConfigHash() (string, error) {
+	opts, err := c.GetContainerCreateOptions()
+	if err != nil {
+		return "", fmt.Errorf("unable to create container options: %w", err)
+	}
+	h := sha256.New()
+	if err := json.NewEncoder(h).Encode(opts); err != nil {
+		return "", fmt.Errorf("unable to encode container options: %w", err)
+	}
+	files, err := c.EmbeddedFiles()
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		_, _ = fmt.Fprintf(h, "%s\x00%s\x00%d:%d:%s\x00%d\x00", file.Path, file.Mode, file.Uid, file.Gid, file.User, len(file.Data))
+		_, _ = h.Write(file.Data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// adoptContainer reuses an existing container (e.g. left running by an earlier serve) that was created with the
+// same configuration and image, and is running and not unhealthy; any other existing container is removed, so
+// that it is recreated. It reports whether the container was adopted. Stop leaves adopted containers running, as
+// they were found.
+func (this *Docker) adoptContainer(ctx context.Context, c *Container, hash string) (bool, error) {
+
+	inspect, err := this.api.ContainerInspect(ctx, c.Name, client.ContainerInspectOptions{})
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("could not inspect existing container: %w", err)
+	}
+
+	drift, err := this.drift(ctx, c, hash, inspect.Container)
+	if err != nil {
+		return false, err
+	}
+	if drift == "" {
+		logger.Global().Infof("reusing running container %s", c.Name)
+		c.created = &client.ContainerCreateResult{ID: inspect.Container.ID}
+		c.started = &client.ContainerStartResult{}
+		c.adopted = true
+		return true, nil
+	}
+
+	logger.Global().Infof("recreating container %s: %s", c.Name, drift)
+	if _, err := this.api.ContainerRemove(ctx, c.Name, client.ContainerRemoveOptions{
+		RemoveVolumes: true,
+		Force:         true,
+	}); err != nil && !errors.Is(err, ErrNotFound) {
+		return false, fmt.Errorf("container name ('%v') conflicts and could not be removed: %w", c.Name, err)
+	}
+	return false, nil
+}
+
+// drift returns why an existing container cannot be adopted, or "" if it can
+func (this *Docker) drift(ctx context.Context, c *Container, hash string, existing container.InspectResponse) (string, error) {
+
+	switch {
+	case existing.Config == nil || existing.Config.Labels[ConfigHashLabel] == "":
+		return "it has no configuration hash", nil
+	case existing.Config.Labels[ConfigHashLabel] != hash:
+		return "its configuration changed", nil
+	case existing.State == nil || !existing.State.Running:
+		return "it is not running", nil
+	case existing.State.Health != nil && existing.State.Health.Status == container.Unhealthy:
+		return "it is unhealthy", nil
+	}
+
+	image, err := this.api.ImageInspect(ctx, c.Image)
+	if err != nil {
+		return "", fmt.Errorf("unable to inspect image: %w", err)
+	}
+	if image.ID != existing.Image {
+		return fmt.Sprintf("its image %s was updated", c.Image), nil
+	}
+	return "", nil
+}
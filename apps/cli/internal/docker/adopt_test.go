@@ -0,0 +1,158 @@
+package docker
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// countCalls returns how many times a call was made
+func countCalls(calls []string, call string) int {
+	n := 0
+	for _, c := range calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+// redefine returns a new definition of a container, as the next serve would build it
+func redefine(c *Container) *Container {
+	copied := *c
+	copied.created, copied.started, copied.adopted = nil, nil, false
+	return &copied
+}
+
+func TestRunAdoptsUnchangedRunningContainers(t *testing.T) {
+	dkr, engine := newTestDocker()
+	db, auth := testContainer(engine, "db"), testContainer(engine, "auth", "db")
+	if err := run(t, dkr, db, auth); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	// e.g. the next serve, with the same configuration
+	hooked := false
+	db = redefine(db)
+	db.AfterStart = func(ctx context.Context, docker *Docker, container *Container) (string, error) {
+		hooked = true
+		return "", nil
+	}
+	if err := run(t, dkr, db, redefine(auth)); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+
+	calls := engine.Calls()
+	for _, name := range []string{"db", "auth"} {
+		if countCalls(calls, "ContainerCreate "+name) != 1 || slices.Contains(calls, "ContainerRemove "+name) {
+			t.Errorf("container %s was recreated: %v", name, calls)
+		}
+		if c, _ := engine.Container(name); c.Started != 1 {
+			t.Errorf("container %s was started %d times", name, c.Started)
+		}
+	}
+	if !hooked {
+		t.Errorf("the after-start hook of the adopted container was not run")
+	}
+	if db.GetID() == db.Name {
+		t.Errorf("the adopted container's ID was not recorded")
+	}
+}
+
+func TestStopLeavesAdoptedContainersRunning(t *testing.T) {
+	dkr, engine := newTestDocker()
+	db, auth := testContainer(engine, "db"), testContainer(engine, "auth", "db")
+	if err := run(t, dkr, db, auth); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	// e.g. a foreground serve after serve --detach, in which auth's configuration changed
+	db, auth = redefine(db), redefine(auth)
+	auth.Env = append(auth.Env, "CHANGED=1")
+	if err := run(t, dkr, db, auth); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	if errs := dkr.Stop(context.Background(), []*Container{db, auth}); len(errs) != 0 {
+		t.Fatalf("stop failed: %v", errs)
+	}
+
+	calls := engine.Calls()
+	if slices.Contains(calls, "ContainerStop db") || slices.Contains(calls, "ContainerRemove db") {
+		t.Errorf("the adopted container was stopped or removed: %v", calls)
+	}
+	if c, ok := engine.Container("db"); !ok || !c.Running {
+		t.Errorf("expected the adopted container to keep running")
+	}
+	if _, ok := engine.Container("auth"); ok {
+		t.Errorf("expected the recreated container to be removed")
+	}
+}
+
+func TestRunRecreatesDriftedContainers(t *testing.T) {
+	dkr, engine := newTestDocker()
+	db, auth, rest, kong := testContainer(engine, "db"), testContainer(engine, "auth"), testContainer(engine, "rest"), testContainer(engine, "kong")
+	if err := run(t, dkr, db, auth, rest, kong); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	auth = redefine(auth)
+	auth.Env = []string{"GOTRUE_SITE_URL=http://localhost:3000"}
+	engine.Crash("rest", 1)
+	kong = redefine(kong)
+	kong.Embeds = []*EmbeddedFile{{Path: "/home/kong/kong.yml", Data: []byte("_format_version: \"2.1\"")}}
+	if err := run(t, dkr, redefine(db), auth, redefine(rest), kong); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+
+	calls := engine.Calls()
+	if slices.Contains(calls, "ContainerRemove db") {
+		t.Errorf("unchanged container was recreated: %v", calls)
+	}
+	for _, name := range []string{"auth", "rest", "kong"} {
+		if countCalls(calls, "ContainerCreate "+name) != 2 || !slices.Contains(calls, "ContainerRemove "+name) {
+			t.Errorf("container %s was not recreated: %v", name, calls)
+		}
+	}
+	if c, _ := engine.Container("auth"); !slices.Contains(c.Options.Config.Env, "GOTRUE_SITE_URL=http://localhost:3000") {
+		t.Errorf("auth was not recreated with its new configuration: %+v", c.Options.Config)
+	}
+}
+
+func TestRunRecreatesContainersOfUpdatedImages(t *testing.T) {
+	dkr, engine := newTestDocker()
+	db := testContainer(engine, "db")
+	if err := run(t, dkr, db); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	// e.g. the tag was pulled again
+	engine.AddImage(db.Image)
+	if err := run(t, dkr, redefine(db)); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	if calls := engine.Calls(); countCalls(calls, "ContainerCreate db") != 2 {
+		t.Errorf("container of the updated image was not recreated: %v", calls)
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	a, b := &Container{Name: "a", Image: "example.com/a:1"}, &Container{Name: "a", Image: "example.com/a:1"}
+	hash := func(c *Container) string {
+		h, err := c.ConfigHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	if hash(a) != hash(b) {
+		t.Fatalf("equal containers have different hashes")
+	}
+	b.Embeds = []*EmbeddedFile{{Path: "/etc/a.conf", Data: []byte("a")}}
+	if hash(a) == hash(b) {
+		t.Errorf("embedded files do not change the hash")
+	}
+	a.Embeds = []*EmbeddedFile{{Path: "/etc/a.conf", Data: []byte("b")}}
+	if hash(a) == hash(b) {
+		t.Errorf("the data of embedded files does not change the hash")
+	}
+}
@@ -0,0 +1,447 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/moby/moby/api/types/jsonstream"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/images"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// BundleVersion is the current schema version of image bundles
+//
+//   - v1: one `docker save` archive per image, listed with its checksum in manifest.json
+const BundleVersion = 1
+
+// bundleManifestName is the name of the manifest in a bundle; it is the last file of the bundle
+const bundleManifestName = "manifest.json"
+
+// TrustedImagesFileName is the name of the file (in the data dir) that lists the pinned images loaded from bundles
+const TrustedImagesFileName = "trusted-images.json"
+
+// Bundle is the manifest of an image bundle: a tar of `docker save` archives, for hosts without registry access
+type Bundle struct {
+	Version int           `json:"version"`
+	Created time.Time     `json:"created"`
+	Images  []BundleImage `json:"images"`
+}
+
+// BundleImage is an image in a bundle
+type BundleImage struct {
+	Services    []string `json:"services"`
+	Image       string   `json:"image"`
+	Digest      string   `json:"digest,omitempty"` // the digest the image is pinned to
+	ID          string   `json:"id"`               // the image's ID when it was saved; once loaded, its ID on this host
+	RepoDigests []string `json:"repo_digests,omitempty"`
+	File        string   `json:"file"`
+	Size        int64    `json:"size"`
+	SHA256      string   `json:"sha256"`
+
+	pinned []string // the IDs a pinned image may be loaded with, as derived from its archive (see pinnedDigests)
+}
+
+// maxManifestSize bounds the blobs of a `docker save` archive that are kept to verify pinned digests: indexes,
+// manifests and configs are small, layers are not
+const maxManifestSize = 4 << 20
+
+// readArchive reads a `docker save` archive (an OCI image layout), verifying that each of its blobs matches its
+// digest, and returns the blobs small enough to be indexes, manifests or configs by digest
+func readArchive(r io.Reader) (map[string][]byte, error) {
+
+	blobs := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return blobs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid image archive: %w", err)
+		}
+		encoded, ok := strings.CutPrefix(path.Clean(hdr.Name), "blobs/sha256/")
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		hash := sha256.New()
+		var data bytes.Buffer
+		w := io.Writer(hash)
+		if hdr.Size <= maxManifestSize {
+			w = io.MultiWriter(hash, &data)
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			return nil, fmt.Errorf("invalid image archive: %w", err)
+		}
+		if hex.EncodeToString(hash.Sum(nil)) != encoded {
+			return nil, fmt.Errorf("invalid image archive: blob %s does not match its digest", hdr.Name)
+		}
+		if hdr.Size <= maxManifestSize {
+			blobs["sha256:"+encoded] = data.Bytes()
+		}
+	}
+}
+
+// pinnedDigests returns the IDs an image pinned to a digest may be loaded with, following the blobs of its archive
+// from the pinned digest: the pinned index (or manifest), which the containerd image store uses as ID, and the
+// configs of its manifests, which the classic image store uses
+func pinnedDigests(blobs map[string][]byte, pinned string) ([]string, error) {
+
+	data, ok := blobs[pinned]
+	if !ok {
+		return nil, fmt.Errorf("the image archive does not contain the pinned manifest %s; save the bundle with a daemon that keeps image manifests, such as one using the containerd image store", pinned)
+	}
+
+	var manifest struct {
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	digests := []string{pinned}
+	manifests := []string{pinned}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", pinned, err)
+	}
+	for _, m := range manifest.Manifests {
+		// an archive only holds the manifests of the platforms it was saved for
+		if _, ok := blobs[m.Digest]; ok {
+			manifests = append(manifests, m.Digest)
+		}
+	}
+	for _, m := range manifests {
+		manifest.Config.Digest = ""
+		if err := json.Unmarshal(blobs[m], &manifest); err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %w", m, err)
+		}
+		if manifest.Config.Digest != "" {
+			digests = append(digests, manifest.Config.Digest)
+		}
+	}
+	return digests, nil
+}
+
+// SaveImages writes the images of the containers into a bundle, pulling any that are missing first
+func (this *Docker) SaveImages(ctx context.Context, containers []*Container, w io.Writer) (*Bundle, error) {
+
+	if err := this.EnsureImages(ctx, containers); err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{Version: BundleVersion, Created: time.Now().UTC()}
+	byImage := make(map[string]int) // index into bundle.Images
+	for _, c := range containers {
+		if i, ok := byImage[c.Image]; ok {
+			bundle.Images[i].Services = append(bundle.Images[i].Services, c.Name)
+			continue
+		}
+		if c.Digest == "" {
+			logger.Global().Warnf("image %s of %s is not pinned to a digest, so `images load` will refuse the bundle", c.Image, c.Name)
+		}
+		byImage[c.Image] = len(bundle.Images)
+		bundle.Images = append(bundle.Images, BundleImage{
+			Services: []string{c.Name},
+			Image:    c.Image,
+			Digest:   c.Digest,
+			File:     path.Join("images", c.Name+".tar"),
+		})
+	}
+
+	tw := tar.NewWriter(w)
+	for i := range bundle.Images {
+		if err := this.saveImage(ctx, tw, &bundle.Images[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("could not encode bundle manifest: %w", err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: bundleManifestName, Mode: 0644, Size: int64(len(data)), ModTime: bundle.Created}); err != nil {
+		return nil, fmt.Errorf("could not write bundle manifest: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return nil, fmt.Errorf("could not write bundle manifest: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("could not write bundle: %w", err)
+	}
+	return bundle, nil
+}
+
+// saveImage writes the `docker save` archive of an image into a bundle; the archive is spooled to a temporary
+// file, since a tar header needs its size
+func (this *Docker) saveImage(ctx context.Context, tw *tar.Writer, img *BundleImage) error {
+
+	inspect, err := this.api.ImageInspect(ctx, img.Image)
+	if err != nil {
+		return fmt.Errorf("unable to inspect image %s: %w", img.Image, err)
+	}
+	img.ID, img.RepoDigests = inspect.ID, inspect.RepoDigests
+
+	logger.Global().Infof("saving docker image '%s'", img.Image)
+	rc, err := this.api.ImageSave(ctx, []string{img.Image})
+	if err != nil {
+		return fmt.Errorf("unable to save image %s: %w", img.Image, err)
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp("", "projdocs-image-*.tar")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	if img.Size, err = io.Copy(io.MultiWriter(tmp, hash), rc); err != nil {
+		return fmt.Errorf("unable to save image %s: %w", img.Image, err)
+	}
+	img.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	// a pinned image that cannot be verified when the bundle is loaded is not worth copying
+	if img.Digest != "" {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("could not read temporary file: %w", err)
+		}
+		blobs, err := readArchive(tmp)
+		if err == nil {
+			_, err = pinnedDigests(blobs, img.Digest)
+		}
+		if err != nil {
+			return fmt.Errorf("saved image %s cannot be verified against its pinned digest: %w", img.Image, err)
+		}
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("could not read temporary file: %w", err)
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: img.File, Mode: 0644, Size: img.Size, ModTime: time.Now()}); err != nil {
+		return fmt.Errorf("could not write %s to bundle: %w", img.File, err)
+	}
+	if _, err := io.Copy(tw, tmp); err != nil {
+		return fmt.Errorf("could not write %s to bundle: %w", img.File, err)
+	}
+	logger.Global().Debugf("saved docker image '%s' (%s, sha256 %s)", img.Image, formatBytes(img.Size), img.SHA256)
+	return nil
+}
+
+// ReadBundle reads the manifest of a bundle and verifies the checksum of every image in it; every image must be
+// pinned, and the IDs it may be loaded with are derived from its pinned digest, rather than trusting the manifest
+func ReadBundle(file string) (*Bundle, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not open bundle: %w", err)
+	}
+	defer f.Close()
+
+	var bundle *Bundle
+	checksums := make(map[string]string)
+	sizes := make(map[string]int64)
+	blobs := make(map[string]map[string][]byte)
+	archiveErrs := make(map[string]error)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %w", err)
+		}
+		if hdr.Name == bundleManifestName {
+			bundle = &Bundle{}
+			if err := json.NewDecoder(tr).Decode(bundle); err != nil {
+				return nil, fmt.Errorf("invalid bundle manifest: %w", err)
+			}
+			continue
+		}
+		hash := sha256.New()
+		counter := &countingWriter{}
+		archive := io.TeeReader(tr, io.MultiWriter(hash, counter))
+		blobs[hdr.Name], archiveErrs[hdr.Name] = readArchive(archive)
+		if _, err := io.Copy(io.Discard, archive); err != nil {
+			return nil, fmt.Errorf("could not read %s from bundle: %w", hdr.Name, err)
+		}
+		checksums[hdr.Name], sizes[hdr.Name] = hex.EncodeToString(hash.Sum(nil)), counter.n
+	}
+
+	if bundle == nil {
+		return nil, fmt.Errorf("invalid bundle: %s is missing", bundleManifestName)
+	}
+	if bundle.Version != BundleVersion {
+		return nil, fmt.Errorf("bundle has version %d, expected %d", bundle.Version, BundleVersion)
+	}
+	var unpinned []string
+	for i, img := range bundle.Images {
+		if checksum, ok := checksums[img.File]; !ok {
+			return nil, fmt.Errorf("invalid bundle: %s (%s) is missing", img.File, img.Image)
+		} else if checksum != img.SHA256 || sizes[img.File] != img.Size {
+			return nil, fmt.Errorf("invalid bundle: %s (%s) does not match its checksum; the bundle is corrupt", img.File, img.Image)
+		}
+		if img.Digest == "" {
+			unpinned = append(unpinned, fmt.Sprintf("%s (%s)", img.Image, strings.Join(img.Services, ", ")))
+			continue
+		}
+		if err := archiveErrs[img.File]; err != nil {
+			return nil, fmt.Errorf("invalid bundle: %s (%s): %w", img.File, img.Image, err)
+		}
+		pinned, err := pinnedDigests(blobs[img.File], img.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %s cannot be verified against its pinned digest: %w", img.Image, err)
+		}
+		bundle.Images[i].pinned = pinned
+	}
+	if len(unpinned) > 0 {
+		return nil, fmt.Errorf("bundle has images that are not pinned to a digest, so they cannot be verified: %s; pin them (see the images.* settings) and save the bundle again", strings.Join(unpinned, ", "))
+	}
+	return bundle, nil
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// LoadImages verifies a bundle (see ReadBundle), loads its images and verifies that each loaded image has the
+// ID derived from its pinned digest
+func (this *Docker) LoadImages(ctx context.Context, file string) (*Bundle, error) {
+
+	bundle, err := ReadBundle(file)
+	if err != nil {
+		return nil, err
+	}
+	byFile := make(map[string]BundleImage)
+	for _, img := range bundle.Images {
+		byFile[img.File] = img
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not open bundle: %w", err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %w", err)
+		}
+		img, ok := byFile[hdr.Name]
+		if !ok {
+			continue
+		}
+		logger.Global().Infof("loading docker image '%s'", img.Image)
+		if err := this.loadImage(ctx, tr); err != nil {
+			return nil, fmt.Errorf("unable to load image %s: %w", img.Image, err)
+		}
+	}
+
+	for i, img := range bundle.Images {
+		inspect, err := this.api.ImageInspect(ctx, img.Image)
+		if err != nil {
+			return nil, fmt.Errorf("image %s was not loaded: %w", img.Image, err)
+		}
+		if !slices.Contains(img.pinned, inspect.ID) {
+			return nil, fmt.Errorf("loaded image %s has ID %s, which is not the image of its pinned digest %s", img.Image, inspect.ID, img.Digest)
+		}
+		bundle.Images[i].ID = inspect.ID
+		logger.Global().Debugf("loaded docker image '%s' (%s)", img.Image, inspect.ID)
+	}
+	return bundle, nil
+}
+
+// loadImage loads a `docker save` archive, returning the first error the daemon reports
+func (this *Docker) loadImage(ctx context.Context, archive io.Reader) error {
+
+	rc, err := this.api.ImageLoad(ctx, archive)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	decoder := json.NewDecoder(rc)
+	for {
+		var msg jsonstream.Message
+		if err := decoder.Decode(&msg); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("invalid response: %w", err)
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if msg.Stream != "" {
+			logger.Global().Debugf("%s", msg.Stream)
+		}
+	}
+}
+
+// ReadTrustedImages returns the pinned images loaded from bundles, as IDs by pinned reference (image@digest);
+// a missing file lists none
+func ReadTrustedImages(file string) (map[string]string, error) {
+
+	trusted := make(map[string]string)
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return trusted, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read trusted images (%s): %w", file, err)
+	}
+	if err := json.Unmarshal(data, &trusted); err != nil {
+		return nil, fmt.Errorf("invalid trusted images (%s): %w", file, err)
+	}
+	return trusted, nil
+}
+
+// WriteTrustedImages adds the images of a bundle loaded by LoadImages to the trusted images
+func WriteTrustedImages(file string, bundle *Bundle) error {
+
+	trusted, err := ReadTrustedImages(file)
+	if err != nil {
+		return err
+	}
+	for _, img := range bundle.Images {
+		trusted[images.Image{Image: img.Image, Digest: img.Digest}.String()] = img.ID
+	}
+
+	data, err := json.MarshalIndent(trusted, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode trusted images: %w", err)
+	}
+	if err := utils.WriteFileAtomic(file, data, 0600); err != nil {
+		return fmt.Errorf("could not write trusted images (%s): %w", file, err)
+	}
+	return nil
+}
+
+// SetTrustedImages sets the pinned images loaded from bundles (see ReadTrustedImages): since `docker load` does
+// not keep repo digests, such an image is verified by the ID recorded when it was loaded
+func (this *Docker) SetTrustedImages(trusted map[string]string) {
+	this.trustedImages = trusted
+}
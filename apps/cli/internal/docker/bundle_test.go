@@ -0,0 +1,228 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/moby/moby/client"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/fake"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// saveBundle saves the images of the containers from a new engine into a bundle file; containers pinned to
+// fake.Digest are pinned to the actual digest of their image
+func saveBundle(t *testing.T, containers ...*Container) string {
+	t.Helper()
+	dkr, engine := newTestDocker()
+	dkr.SetPullReporter(nil)
+	for _, c := range containers {
+		if !engine.HasRemoteImage(c.Image) {
+			engine.AddRemoteImage(c.Image)
+		}
+		if c.Digest == fake.Digest {
+			c.Digest = engine.ManifestDigest(c.Image)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "bundle.tar")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := dkr.SaveImages(context.Background(), containers, f); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	return file
+}
+
+// rewriteBundleManifest rewrites the manifest of a bundle file
+func rewriteBundleManifest(t *testing.T, file string, rewrite func(bundle *Bundle)) {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	tw := tar.NewWriter(&out)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == bundleManifestName {
+			var bundle Bundle
+			if err := json.Unmarshal(content, &bundle); err != nil {
+				t.Fatal(err)
+			}
+			rewrite(&bundle)
+			if content, err = json.Marshal(bundle); err != nil {
+				t.Fatal(err)
+			}
+			hdr.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	a := &Container{Name: "a", Image: "example.com/a:1", Digest: fake.Digest}
+	b := &Container{Name: "b", Image: "example.com/b:1", Digest: fake.Digest}
+	file := saveBundle(t, a, b, &Container{Name: "a2", Image: "example.com/a:1", Digest: fake.Digest})
+
+	dkr, engine := newTestDocker()
+	bundle, err := dkr.LoadImages(context.Background(), file)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if len(bundle.Images) != 2 || strings.Join(bundle.Images[0].Services, ",") != "a,a2" {
+		t.Fatalf("expected 2 images, the first for a and a2, got %+v", bundle.Images)
+	}
+	if !engine.HasImage(a.Image) || !engine.HasImage(b.Image) {
+		t.Fatalf("images were not loaded")
+	}
+
+	// the loaded image has lost its repo digests, so its pinned digest is only accepted once it is trusted
+	if err := dkr.verifyDigest(context.Background(), a); err == nil {
+		t.Fatalf("expected the untrusted loaded image to fail verification")
+	}
+	trusted := filepath.Join(t.TempDir(), TrustedImagesFileName)
+	if err := WriteTrustedImages(trusted, bundle); err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := os.Stat(trusted); err != nil || stat.Mode().Perm() != 0600 {
+		t.Errorf("expected the trusted images to be readable by the owner only, got %v (%v)", stat.Mode(), err)
+	}
+	ids, err := ReadTrustedImages(trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dkr.SetTrustedImages(ids)
+	if err := dkr.verifyDigest(context.Background(), a); err != nil {
+		t.Fatalf("trusted loaded image failed verification: %v", err)
+	}
+	if calls := engine.Calls(); strings.Contains(strings.Join(calls, ","), "ImagePull") {
+		t.Errorf("images were pulled: %v", calls)
+	}
+}
+
+func TestBundleRejectsCorruptImages(t *testing.T) {
+	file := saveBundle(t, &Container{Name: "a", Image: "example.com/a:1"})
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip a byte of the image archive, which is the first file of the bundle
+	i := strings.Index(string(data), "oci-layout")
+	if i < 0 {
+		t.Fatal("image archive not found in bundle")
+	}
+	data[i] ^= 0xff
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dkr, engine := newTestDocker()
+	_, err = dkr.LoadImages(context.Background(), file)
+	if err == nil || !strings.Contains(err.Error(), "does not match its checksum") {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	if engine.HasImage("example.com/a:1") {
+		t.Errorf("image of a corrupt bundle was loaded")
+	}
+}
+
+func TestBundleRejectsUnpinnedImages(t *testing.T) {
+	file := saveBundle(t, &Container{Name: "a", Image: "example.com/a:1", Digest: fake.Digest}, &Container{Name: "b", Image: "example.com/b:1"})
+
+	dkr, engine := newTestDocker()
+	_, err := dkr.LoadImages(context.Background(), file)
+	if err == nil || !strings.Contains(err.Error(), "example.com/b:1 (b)") {
+		t.Fatalf("expected the unpinned image to be refused, got %v", err)
+	}
+	if engine.HasImage("example.com/a:1") || engine.HasImage("example.com/b:1") {
+		t.Errorf("images of a bundle with an unpinned image were loaded")
+	}
+}
+
+func TestReadTrustedImagesWithoutFile(t *testing.T) {
+	trusted, err := ReadTrustedImages(filepath.Join(t.TempDir(), TrustedImagesFileName))
+	if err != nil || len(trusted) != 0 {
+		t.Fatalf("expected no trusted images, got %v (%v)", trusted, err)
+	}
+}
+
+func TestBundleVerifiesPinnedDigestsFromArchive(t *testing.T) {
+	a := &Container{Name: "a", Image: "example.com/a:1", Digest: fake.Digest}
+	file := saveBundle(t, a)
+
+	// the bundle claims another digest for the image, which its archive does not contain
+	const claimed = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	rewriteBundleManifest(t, file, func(bundle *Bundle) {
+		bundle.Images[0].Digest = claimed
+		bundle.Images[0].RepoDigests = []string{"example.com/a@" + claimed}
+	})
+
+	dkr, engine := newTestDocker()
+	_, err := dkr.LoadImages(context.Background(), file)
+	if err == nil || !strings.Contains(err.Error(), "does not contain the pinned manifest "+claimed) {
+		t.Fatalf("expected the claimed digest to be rejected, got %v", err)
+	}
+	if engine.HasImage(a.Image) {
+		t.Errorf("image of an unverified bundle was loaded")
+	}
+}
+
+func TestBundleRejectsLoadedImageOfAnotherDigest(t *testing.T) {
+	a := &Container{Name: "a", Image: "example.com/a:1", Digest: fake.Digest}
+	file := saveBundle(t, a)
+
+	// the daemon ends up with another image for the tag
+	dkr, engine := newTestDocker()
+	engine.AddImage("example.com/a:2")
+	engine.OnCall("ImageLoad", func(string) {
+		engine.ImageTag(context.Background(), client.ImageTagOptions{Source: "example.com/a:2", Target: a.Image})
+	})
+	_, err := dkr.LoadImages(context.Background(), file)
+	if err == nil || !strings.Contains(err.Error(), "which is not the image of its pinned digest") {
+		t.Fatalf("expected the loaded image to be rejected, got %v", err)
+	}
+}
+
+func TestSaveRejectsPinnedImageWithoutManifest(t *testing.T) {
+	dkr, engine := newTestDocker()
+	dkr.SetPullReporter(nil)
+	// the image claims the digest, but its archive does not hold that manifest (e.g. the classic image store)
+	engine.AddImage("example.com/a:1", "example.com/a@"+fake.Digest)
+
+	_, err := dkr.SaveImages(context.Background(), []*Container{{Name: "a", Image: "example.com/a:1", Digest: fake.Digest}}, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "cannot be verified against its pinned digest") {
+		t.Fatalf("expected the unverifiable image to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,307 @@
+// Package compose exports the containers of an instance as a Compose file, so that the stack can be run with
+// `docker compose` instead of serve.
+package compose
+
+import (
+	"fmt"
+	"github.com/moby/moby/api/types/mount"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/images"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/network"
+	"gopkg.in/yaml.v3"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// EmbedMode is how the embedded files of the containers are exported
+type EmbedMode string
+
+const (
+	EmbedConfigs EmbedMode = "configs" // as configs, with their content inline
+	EmbedFiles   EmbedMode = "files"   // as files next to the Compose file, bind-mounted read-only
+)
+
+// File is a Compose file (see https://docs.docker.com/reference/compose-file/)
+type File struct {
+	Name     string             `yaml:"name"`
+	Services map[string]Service `yaml:"services"`
+	Networks map[string]Network `yaml:"networks"`
+	Configs  map[string]Config  `yaml:"configs,omitempty"`
+}
+
+type Service struct {
+	Image         string               `yaml:"image"`
+	ContainerName string               `yaml:"container_name"`
+	Entrypoint    []string             `yaml:"entrypoint,omitempty"`
+	Command       []string             `yaml:"command,omitempty"`
+	Environment   []string             `yaml:"environment,omitempty"`
+	Ports         []Port               `yaml:"ports,omitempty"`
+	Volumes       []Volume             `yaml:"volumes,omitempty"`
+	Configs       []ServiceConfig      `yaml:"configs,omitempty"`
+	Healthcheck   *Healthcheck         `yaml:"healthcheck,omitempty"`
+	DependsOn     map[string]Condition `yaml:"depends_on,omitempty"`
+	Labels        map[string]string    `yaml:"labels,omitempty"`
+	Networks      []string             `yaml:"networks"`
+	Restart       string               `yaml:"restart"`
+}
+
+type Port struct {
+	Target    uint16 `yaml:"target"`
+	Published string `yaml:"published"`
+	HostIP    string `yaml:"host_ip,omitempty"`
+	Protocol  string `yaml:"protocol"`
+}
+
+type Volume struct {
+	Type     string `yaml:"type"`
+	Source   string `yaml:"source"`
+	Target   string `yaml:"target"`
+	ReadOnly bool   `yaml:"read_only,omitempty"`
+}
+
+type ServiceConfig struct {
+	Source string `yaml:"source"`
+	Target string `yaml:"target"`
+	UID    string `yaml:"uid,omitempty"`
+	GID    string `yaml:"gid,omitempty"`
+	Mode   string `yaml:"mode"`
+}
+
+type Healthcheck struct {
+	Test          []string `yaml:"test"`
+	Interval      string   `yaml:"interval,omitempty"`
+	Timeout       string   `yaml:"timeout,omitempty"`
+	Retries       int      `yaml:"retries,omitempty"`
+	StartPeriod   string   `yaml:"start_period,omitempty"`
+	StartInterval string   `yaml:"start_interval,omitempty"`
+}
+
+// Condition is when a dependency is considered started
+type Condition struct {
+	Condition string `yaml:"condition"`
+}
+
+type Network struct {
+	Name       string `yaml:"name"`
+	Driver     string `yaml:"driver"`
+	EnableIPv6 bool   `yaml:"enable_ipv6"`
+}
+
+type Config struct {
+	Content string `yaml:"content"`
+}
+
+// Export is a Compose file with the files it bind-mounts (by path relative to the Compose file) and what it
+// could not export
+type Export struct {
+	File     File
+	Files    map[string][]byte
+	Warnings []string
+}
+
+// YAML returns the Compose file as YAML
+func (this *Export) YAML() ([]byte, error) {
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(this.File); err != nil {
+		return nil, fmt.Errorf("could not encode compose file: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("could not encode compose file: %w", err)
+	}
+	return []byte(b.String()), nil
+}
+
+// FilesDir is the dir (relative to the Compose file) that embedded files are exported to with EmbedFiles
+const FilesDir = "projdocs-files"
+
+// Exporter exports containers as a Compose file
+type Exporter struct {
+	Embeds EmbedMode
+}
+
+// Export returns the Compose file that runs the containers as serve would: each service is created with the
+// options of GetContainerCreateOptions, and depends on its dependencies being healthy (or started, without a
+// health check). After-start hooks and readiness probes cannot be exported, and are listed in the warnings.
+func (this Exporter) Export(containers []*docker.Container) (*Export, error) {
+
+	export := &Export{
+		File: File{
+			Name:     docker.ProjectName,
+			Services: map[string]Service{},
+			Networks: map[string]Network{
+				network.Name: {Name: network.Name, Driver: "bridge", EnableIPv6: true},
+			},
+		},
+		Files: map[string][]byte{},
+	}
+
+	byName := map[string]*docker.Container{}
+	for _, c := range containers {
+		byName[c.Name] = c
+	}
+
+	for _, c := range containers {
+		name := c.ServiceName()
+		if _, ok := export.File.Services[name]; ok {
+			return nil, fmt.Errorf("service %s is defined more than once", name)
+		}
+		svc, err := this.service(c, byName, export)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		export.File.Services[name] = svc
+
+		if c.AfterStart != nil {
+			export.Warnings = append(export.Warnings, fmt.Sprintf("service %s has an after-start hook that serve runs once it is ready; it is not exported", name))
+		}
+		if c.Readiness != nil && c.Readiness.Probe != nil {
+			export.Warnings = append(export.Warnings, fmt.Sprintf("service %s has a readiness probe that serve checks; it is not exported, so its dependents do not wait for it", name))
+		}
+	}
+	return export, nil
+}
+
+// service returns the Compose service of a container, adding its embedded files to the export
+func (this Exporter) service(c *docker.Container, byName map[string]*docker.Container, export *Export) (Service, error) {
+
+	opts, err := c.GetContainerCreateOptions()
+	if err != nil {
+		return Service{}, err
+	}
+	cfg, host := opts.Config, opts.HostConfig
+
+	svc := Service{
+		Image:         images.Image{Image: cfg.Image, Digest: c.Digest}.String(),
+		ContainerName: opts.Name,
+		Entrypoint:    escapeAll(cfg.Entrypoint),
+		Command:       escapeAll(cfg.Cmd),
+		Environment:   escapeAll(cfg.Env),
+		Labels:        map[string]string{},
+		Networks:      []string{network.Name},
+		Restart:       string(host.RestartPolicy.Name),
+	}
+
+	// compose sets its own labels
+	for k, v := range cfg.Labels {
+		if !strings.HasPrefix(k, "com.docker.compose.") {
+			svc.Labels[k] = escape(v)
+		}
+	}
+
+	for port, bindings := range host.PortBindings {
+		for _, binding := range bindings {
+			p := Port{Target: port.Num(), Published: binding.HostPort, Protocol: string(port.Proto())}
+			if binding.HostIP.IsValid() {
+				p.HostIP = binding.HostIP.String()
+			}
+			svc.Ports = append(svc.Ports, p)
+		}
+	}
+	slices.SortFunc(svc.Ports, func(a, b Port) int { return int(a.Target) - int(b.Target) })
+
+	for _, m := range host.Mounts {
+		if m.Type != mount.TypeBind {
+			return Service{}, fmt.Errorf("mount of %s has unsupported type %s", m.Target, m.Type)
+		}
+		svc.Volumes = append(svc.Volumes, Volume{Type: string(m.Type), Source: escape(m.Source), Target: m.Target, ReadOnly: m.ReadOnly})
+	}
+
+	if hc := cfg.Healthcheck; hc != nil {
+		svc.Healthcheck = &Healthcheck{
+			Test:          escapeAll(hc.Test),
+			Interval:      duration(hc.Interval),
+			Timeout:       duration(hc.Timeout),
+			Retries:       hc.Retries,
+			StartPeriod:   duration(hc.StartPeriod),
+			StartInterval: duration(hc.StartInterval),
+		}
+	}
+
+	for _, dep := range c.DependsOn {
+		d, ok := byName[dep]
+		if !ok {
+			return Service{}, fmt.Errorf("depends on %s, which is not exported", dep)
+		}
+		if svc.DependsOn == nil {
+			svc.DependsOn = map[string]Condition{}
+		}
+		condition := "service_started"
+		if d.HealthCheck != nil {
+			condition = "service_healthy"
+		}
+		svc.DependsOn[d.ServiceName()] = Condition{Condition: condition}
+	}
+
+	files, err := c.EmbeddedFiles()
+	if err != nil {
+		return Service{}, err
+	}
+	for _, file := range files {
+		if file.Mode.IsDir() {
+			continue // created with the files they contain
+		}
+		switch this.Embeds {
+		case EmbedFiles:
+			rel := path.Join(FilesDir, c.ServiceName(), file.Path)
+			export.Files[rel] = file.Data
+			svc.Volumes = append(svc.Volumes, Volume{Type: string(mount.TypeBind), Source: "./" + rel, Target: file.Path, ReadOnly: true})
+		default:
+			if !utf8.Valid(file.Data) {
+				return Service{}, fmt.Errorf("embedded file %s is binary; export the embedded files as files instead", file.Path)
+			}
+			name := configName(c.ServiceName(), file.Path)
+			if export.File.Configs == nil {
+				export.File.Configs = map[string]Config{}
+			}
+			export.File.Configs[name] = Config{Content: escape(string(file.Data))}
+			config := ServiceConfig{Source: name, Target: file.Path, Mode: fmt.Sprintf("%#o", file.Mode.Perm())}
+			if file.User != "" {
+				// only the engine can resolve the user's name, from the container's /etc/passwd
+				config.Mode = fmt.Sprintf("%#o", file.Mode.Perm()|0o444)
+				export.Warnings = append(export.Warnings, fmt.Sprintf("service %s has the embedded file %s owned by %s, which cannot be exported; it is readable by every user instead (mode %s)", c.ServiceName(), file.Path, file.User, config.Mode))
+			} else if file.Uid != 0 || file.Gid != 0 {
+				config.UID, config.GID = strconv.Itoa(file.Uid), strconv.Itoa(file.Gid)
+			}
+			svc.Configs = append(svc.Configs, config)
+		}
+	}
+	return svc, nil
+}
+
+var configNameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// configName returns the name of the config of a service's embedded file
+func configName(service string, file string) string {
+	return service + "_" + strings.Trim(configNameUnsafe.ReplaceAllString(file, "-"), "-.")
+}
+
+// escape escapes a value so that Compose does not interpolate variables in it
+func escape(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+func escapeAll(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	escaped := make([]string, len(values))
+	for i, v := range values {
+		escaped[i] = escape(v)
+	}
+	return escaped
+}
+
+// duration returns a duration for Compose, or "" if it is unset
+func duration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
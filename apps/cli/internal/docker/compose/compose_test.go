@@ -0,0 +1,208 @@
+package compose
+
+import (
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
+	"github.com/moby/moby/api/types/network"
+	"github.com/moby/moby/client"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/dockertest"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/fake"
+	"gopkg.in/yaml.v3"
+	"net/netip"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createOptions returns the options docker compose creates a service's container with
+func createOptions(t *testing.T, svc Service) client.ContainerCreateOptions {
+	t.Helper()
+	unescape := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		unescaped := make([]string, len(values))
+		for i, v := range values {
+			unescaped[i] = strings.ReplaceAll(v, "$$", "$")
+		}
+		return unescaped
+	}
+
+	image, _, _ := strings.Cut(svc.Image, "@")
+	opts := client.ContainerCreateOptions{
+		Name: svc.ContainerName,
+		Config: &container.Config{
+			Image:        image,
+			Entrypoint:   unescape(svc.Entrypoint),
+			Cmd:          unescape(svc.Command),
+			Env:          unescape(svc.Environment),
+			ExposedPorts: network.PortSet{},
+			Labels:       map[string]string{docker.ProjectLabel: docker.ProjectName}, // set by compose itself
+		},
+		HostConfig: &container.HostConfig{
+			PortBindings:  network.PortMap{},
+			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyMode(svc.Restart)},
+		},
+		NetworkingConfig: &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{}},
+	}
+	for k, v := range svc.Labels {
+		opts.Config.Labels[k] = strings.ReplaceAll(v, "$$", "$")
+	}
+
+	for _, p := range svc.Ports {
+		port, err := network.ParsePort(strconv.Itoa(int(p.Target)) + "/" + p.Protocol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip, err := netip.ParseAddr(p.HostIP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.Config.ExposedPorts[port] = struct{}{}
+		opts.HostConfig.PortBindings[port] = append(opts.HostConfig.PortBindings[port], network.PortBinding{HostIP: ip, HostPort: p.Published})
+	}
+	for _, v := range svc.Volumes {
+		if strings.HasPrefix(v.Source, "./"+FilesDir+"/") {
+			continue // an embedded file
+		}
+		opts.HostConfig.Mounts = append(opts.HostConfig.Mounts, mount.Mount{Type: mount.Type(v.Type), Source: strings.ReplaceAll(v.Source, "$$", "$"), Target: v.Target, ReadOnly: v.ReadOnly})
+	}
+	if hc := svc.Healthcheck; hc != nil {
+		parse := func(s string) time.Duration {
+			if s == "" {
+				return 0
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return d
+		}
+		opts.Config.Healthcheck = &container.HealthConfig{
+			Test:          unescape(hc.Test),
+			Interval:      parse(hc.Interval),
+			Timeout:       parse(hc.Timeout),
+			Retries:       hc.Retries,
+			StartPeriod:   parse(hc.StartPeriod),
+			StartInterval: parse(hc.StartInterval),
+		}
+	}
+	for _, n := range svc.Networks {
+		opts.HostConfig.NetworkMode = container.NetworkMode(n)
+		opts.NetworkingConfig.EndpointsConfig[n] = &network.EndpointSettings{}
+	}
+	return opts
+}
+
+// roundTrip exports containers, and parses the YAML again
+func roundTrip(t *testing.T, mode EmbedMode, containers []*docker.Container) (*Export, File) {
+	t.Helper()
+	export, err := Exporter{Embeds: mode}.Export(containers)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	data, err := export.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file File
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		t.Fatalf("could not parse the compose file: %v\n%s", err, data)
+	}
+	return export, file
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	containers := dockertest.Containers()
+	_, file := roundTrip(t, EmbedConfigs, containers)
+
+	for _, c := range containers {
+		svc, ok := file.Services[c.ServiceName()]
+		if !ok {
+			t.Fatalf("service %s was not exported", c.ServiceName())
+		}
+		expected, err := c.GetContainerCreateOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := createOptions(t, svc); !reflect.DeepEqual(*expected, actual) {
+			t.Errorf("service %s is not created as serve would create it:\nexpected %+v\n%+v\n%+v\ngot      %+v\n%+v\n%+v",
+				c.ServiceName(), expected.Config, expected.HostConfig, expected.NetworkingConfig, actual.Config, actual.HostConfig, actual.NetworkingConfig)
+		}
+
+		if len(svc.Configs) != len(c.Embeds) {
+			t.Fatalf("expected %d configs for service %s, got %+v", len(c.Embeds), c.ServiceName(), svc.Configs)
+		}
+		for i, embed := range c.Embeds {
+			config := svc.Configs[i]
+			if config.Target != embed.Path {
+				t.Errorf("embedded file %s is mounted at %s", embed.Path, config.Target)
+			}
+			if content := strings.ReplaceAll(file.Configs[config.Source].Content, "$$", "$"); content != string(embed.Data) {
+				t.Errorf("embedded file %s has content %q, expected %q", embed.Path, content, embed.Data)
+			}
+		}
+	}
+
+	if dep := file.Services["rest"].DependsOn["db"]; dep.Condition != "service_healthy" {
+		t.Errorf("expected rest to depend on db being healthy, got %+v", file.Services["rest"].DependsOn)
+	}
+	if n := file.Networks["projdocs"]; n.Name != "projdocs" || n.Driver != "bridge" {
+		t.Errorf("unexpected network: %+v", n)
+	}
+	if image := file.Services["db"].Image; image != "example.com/postgres:15@"+fake.Digest {
+		t.Errorf("expected the image to be pinned, got %s", image)
+	}
+}
+
+func TestExportEmbedsAsFiles(t *testing.T) {
+	containers := dockertest.Containers()
+	export, file := roundTrip(t, EmbedFiles, containers)
+
+	if len(file.Configs) != 0 {
+		t.Errorf("expected no configs, got %v", file.Configs)
+	}
+	rel := FilesDir + "/kong/var/tmp/kong.yml"
+	if string(export.Files[rel]) != string(containers[2].Embeds[0].Data) {
+		t.Errorf("expected kong.yml to be exported to %s, got %v", rel, export.Files)
+	}
+	expected := Volume{Type: "bind", Source: "./" + rel, Target: "/var/tmp/kong.yml", ReadOnly: true}
+	if volumes := file.Services["kong"].Volumes; len(volumes) != 1 || volumes[0] != expected {
+		t.Errorf("expected kong.yml to be bind-mounted read-only, got %+v", volumes)
+	}
+}
+
+func TestExportConfigsKeepModesAndOwners(t *testing.T) {
+	containers := dockertest.Containers()
+	containers[0].Embeds = append(containers[0].Embeds,
+		&docker.EmbeddedFile{Path: "/etc/postgresql-custom/server.key", Data: []byte("key"), Mode: 0o600, Uid: 101, Gid: 102},
+	)
+	export, file := roundTrip(t, EmbedConfigs, containers)
+
+	expected := []ServiceConfig{
+		{Source: "db_etc-postgresql-custom-pgsodium_root.key", Target: "/etc/postgresql-custom/pgsodium_root.key", Mode: "0644"},
+		{Source: "db_docker-entrypoint-initdb.d-99-roles.sql", Target: "/docker-entrypoint-initdb.d/99-roles.sql", Mode: "0644"},
+		{Source: "db_etc-postgresql-custom-server.key", Target: "/etc/postgresql-custom/server.key", UID: "101", GID: "102", Mode: "0600"},
+	}
+	if actual := file.Services["db"].Configs; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected configs %+v, got %+v", expected, actual)
+	}
+	warning := "service db has the embedded file /etc/postgresql-custom/pgsodium_root.key owned by postgres, which cannot be exported; it is readable by every user instead (mode 0644)"
+	if !slices.Contains(export.Warnings, warning) {
+		t.Errorf("expected warning %q, got %q", warning, export.Warnings)
+	}
+}
+
+func TestExportWarnsAboutHooks(t *testing.T) {
+	export, _ := roundTrip(t, EmbedConfigs, dockertest.Containers())
+	if !slices.ContainsFunc(export.Warnings, func(w string) bool { return strings.Contains(w, "db has an after-start hook") }) {
+		t.Errorf("expected a warning about the hook of db, got %v", export.Warnings)
+	}
+	if !slices.ContainsFunc(export.Warnings, func(w string) bool { return strings.Contains(w, "kong has a readiness probe") }) {
+		t.Errorf("expected a warning about the readiness probe of kong, got %v", export.Warnings)
+	}
+}
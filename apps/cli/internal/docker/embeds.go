@@ -0,0 +1,192 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/moby/moby/client"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// defaultFileMode and defaultDirMode are the modes of embedded files and the dirs of trees
+const (
+	defaultFileMode fs.FileMode = 0o644
+	defaultDirMode  fs.FileMode = 0o755
+)
+
+// Files returns the files the embedded file writes, with its templates rendered and its mode set: the file itself,
+// or the dirs (with fs.ModeDir set in their mode) and files of its tree, each dir before what it contains
+func (this *EmbeddedFile) Files() ([]*EmbeddedFile, error) {
+
+	if !path.IsAbs(this.Path) || path.Clean(this.Path) != this.Path || this.Path == "/" {
+		return nil, fmt.Errorf("container path must be absolute and clean, got %q", this.Path)
+	}
+	mode := this.Mode.Perm()
+	if mode == 0 {
+		mode = defaultFileMode
+	}
+	file := func(p string, data []byte) (*EmbeddedFile, error) {
+		if this.Template != nil {
+			rendered, err := render(p, data, this.Template)
+			if err != nil {
+				return nil, err
+			}
+			data = rendered
+		}
+		return &EmbeddedFile{Path: p, Data: data, Mode: mode, Uid: this.Uid, Gid: this.Gid, User: this.User, Sensitive: this.Sensitive}, nil
+	}
+
+	if this.Tree == nil {
+		f, err := file(this.Path, this.Data)
+		if err != nil {
+			return nil, err
+		}
+		return []*EmbeddedFile{f}, nil
+	}
+
+	var files []*EmbeddedFile
+	err := fs.WalkDir(this.Tree, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		p := path.Join(this.Path, name)
+		switch {
+		case entry.IsDir():
+			files = append(files, &EmbeddedFile{Path: p, Mode: fs.ModeDir | defaultDirMode, Uid: this.Uid, Gid: this.Gid, User: this.User, Sensitive: this.Sensitive})
+		case entry.Type().IsRegular():
+			data, err := fs.ReadFile(this.Tree, name)
+			if err != nil {
+				return err
+			}
+			f, err := file(p, data)
+			if err != nil {
+				return err
+			}
+			files = append(files, f)
+		default:
+			return fmt.Errorf("%s is not a regular file or dir", p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read tree of %s: %w", this.Path, err)
+	}
+	return files, nil
+}
+
+// render renders a file's template
+func render(name string, data []byte, values any) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template %s: %w", name, err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, values); err != nil {
+		return nil, fmt.Errorf("could not render template %s: %w", name, err)
+	}
+	return b.Bytes(), nil
+}
+
+// EmbeddedFiles returns the files of every embedded file of a container (see EmbeddedFile.Files)
+func (c *Container) EmbeddedFiles() ([]*EmbeddedFile, error) {
+	var files []*EmbeddedFile
+	for _, embed := range c.Embeds {
+		f, err := embed.Files()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f...)
+	}
+	return files, nil
+}
+
+// owners resolves the users and groups of a container from its /etc/passwd and /etc/group, which are read once
+type owners struct {
+	users  map[string][2]int // uid and primary gid, by name
+	groups map[string]int    // gid, by name
+}
+
+// readOwners reads the users and groups of a (created) container
+func (this *Docker) readOwners(ctx context.Context, c *Container) (*owners, error) {
+	o := &owners{users: map[string][2]int{}, groups: map[string]int{}}
+	for _, file := range []string{"/etc/passwd", "/etc/group"} {
+		data, err := this.readFromContainer(ctx, c, file)
+		if errors.Is(err, ErrNotFound) {
+			continue // e.g. a distroless image; only numeric owners can be resolved
+		} else if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			fields := strings.Split(scanner.Text(), ":")
+			if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			id, err := strconv.Atoi(fields[2])
+			if err != nil {
+				continue
+			}
+			if file == "/etc/group" {
+				o.groups[fields[0]] = id
+			} else if len(fields) >= 4 {
+				if gid, err := strconv.Atoi(fields[3]); err == nil {
+					o.users[fields[0]] = [2]int{id, gid}
+				}
+			}
+		}
+	}
+	return o, nil
+}
+
+// resolve returns the uid and gid of "user" or "user:group", by name or id; a user's group defaults to its primary
+// group (or, for an unknown uid, the gid of the same number)
+func (this *owners) resolve(owner string) (int, int, error) {
+	user, group, hasGroup := strings.Cut(owner, ":")
+	var uid, gid int
+	if u, ok := this.users[user]; ok {
+		uid, gid = u[0], u[1]
+	} else if id, err := strconv.Atoi(user); err == nil {
+		uid, gid = id, id
+	} else {
+		return 0, 0, fmt.Errorf("unknown user %q", user)
+	}
+	if hasGroup {
+		if g, ok := this.groups[group]; ok {
+			gid = g
+		} else if id, err := strconv.Atoi(group); err == nil {
+			gid = id
+		} else {
+			return 0, 0, fmt.Errorf("unknown group %q", group)
+		}
+	}
+	return uid, gid, nil
+}
+
+// readFromContainer reads a file of a container
+func (this *Docker) readFromContainer(ctx context.Context, c *Container, file string) ([]byte, error) {
+	res, err := this.api.CopyFromContainer(ctx, c.GetID(), client.CopyFromContainerOptions{SourcePath: file})
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s from container %s: %w", file, c.Name, err)
+	}
+	defer res.Content.Close()
+	tr := tar.NewReader(res.Content)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("could not read %s from container %s: not a regular file", file, c.Name)
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read %s from container %s: %w", file, c.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			return io.ReadAll(tr)
+		}
+	}
+}
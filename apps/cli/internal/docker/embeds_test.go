@@ -0,0 +1,124 @@
+package docker
+
+import (
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/fake"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRunWritesEmbedsInOneUpload(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "db")
+	c.Embeds = []*EmbeddedFile{
+		{Path: "/etc/postgresql-custom/pgsodium_root.key", Data: []byte("0123"), Mode: 0o600, Uid: 101, Gid: 102},
+		{Path: "/docker-entrypoint-initdb.d", Tree: fstest.MapFS{
+			"migrations/99-realtime.sql": {Data: []byte("create schema realtime;")},
+			"init-scripts/99-roles.sql":  {Data: []byte("alter role anon;")},
+		}},
+	}
+
+	if err := run(t, dkr, c); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	calls := engine.Calls()
+	if n := len(slices.DeleteFunc(slices.Clone(calls), func(call string) bool { return call != "CopyToContainer db" })); n != 1 {
+		t.Errorf("expected the files to be written in one upload, got %d: %v", n, calls)
+	}
+
+	created, _ := engine.Container("db")
+	for p, expected := range map[string]fake.File{
+		"/etc/postgresql-custom/pgsodium_root.key":               {Data: []byte("0123"), Mode: 0o600, Uid: 101, Gid: 102},
+		"/etc/postgresql-custom":                                 {Mode: 0o755, Dir: true},
+		"/docker-entrypoint-initdb.d":                            {Mode: 0o755, Dir: true},
+		"/docker-entrypoint-initdb.d/migrations":                 {Mode: 0o755, Dir: true},
+		"/docker-entrypoint-initdb.d/migrations/99-realtime.sql": {Data: []byte("create schema realtime;"), Mode: 0o644},
+		"/docker-entrypoint-initdb.d/init-scripts/99-roles.sql":  {Data: []byte("alter role anon;"), Mode: 0o644},
+	} {
+		actual, ok := created.Files[p]
+		if !ok || string(actual.Data) != string(expected.Data) || actual.Mode != expected.Mode || actual.Uid != expected.Uid || actual.Gid != expected.Gid || actual.Dir != expected.Dir {
+			t.Errorf("expected %s to be %+v, got %+v", p, expected, actual)
+		}
+	}
+}
+
+func TestRunResolvesOwnersFromContainer(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "db")
+	engine.AddImageFile(c.Image, "/etc/passwd", []byte("root:x:0:0:root:/root:/bin/sh\npostgres:x:101:103::/var/lib/postgresql:/bin/bash\n"))
+	engine.AddImageFile(c.Image, "/etc/group", []byte("root:x:0:\npostgres:x:103:\nssl-cert:x:104:postgres\n"))
+	c.Embeds = []*EmbeddedFile{
+		{Path: "/etc/postgresql-custom/pgsodium_root.key", Data: []byte("0123"), Mode: 0o600, User: "postgres"},
+		{Path: "/etc/postgresql-custom/server.key", Data: []byte("key"), Mode: 0o640, User: "postgres:ssl-cert"},
+		{Path: "/etc/postgresql-custom/other.key", Data: []byte("key"), User: "1000:1001"},
+	}
+
+	if err := run(t, dkr, c); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	created, _ := engine.Container("db")
+	for p, owner := range map[string][2]int{
+		"/etc/postgresql-custom/pgsodium_root.key": {101, 103},
+		"/etc/postgresql-custom/server.key":        {101, 104},
+		"/etc/postgresql-custom/other.key":         {1000, 1001},
+	} {
+		if file := created.Files[p]; file.Uid != owner[0] || file.Gid != owner[1] {
+			t.Errorf("expected %s to be owned by %d:%d, got %d:%d", p, owner[0], owner[1], file.Uid, file.Gid)
+		}
+	}
+}
+
+func TestRunFailsOnUnknownOwner(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "db")
+	c.Embeds = []*EmbeddedFile{{Path: "/etc/a.key", Data: []byte("0123"), User: "postgres"}}
+
+	err := run(t, dkr, c)
+	if err == nil || !strings.Contains(err.Error(), `unknown user "postgres"`) {
+		t.Errorf("expected an unknown user to fail the run, got %v", err)
+	}
+	if slices.Contains(engine.Calls(), "ContainerStart db") {
+		t.Errorf("container was started without its files")
+	}
+}
+
+func TestEmbeddedFileTemplates(t *testing.T) {
+	cfg := &config.Supabase{Dashboard: config.DashboardConfig{Username: "supabase", Password: `pa"$word`}}
+
+	files, err := (&EmbeddedFile{Path: "/etc/kong.yml", Data: []byte(`password: {{ printf "%q" .Dashboard.Password }}`), Template: cfg}).Files()
+	if err != nil {
+		t.Fatalf("could not render template: %v", err)
+	}
+	if len(files) != 1 || string(files[0].Data) != `password: "pa\"$word"` {
+		t.Errorf("unexpected rendered files: %+v", files)
+	}
+
+	trees, err := (&EmbeddedFile{Path: "/etc/kong", Tree: fstest.MapFS{"kong.yml": {Data: []byte("{{ .Dashboard.Username }}")}}, Template: cfg}).Files()
+	if err != nil {
+		t.Fatalf("could not render tree: %v", err)
+	}
+	if len(trees) != 2 || trees[0].Mode != fs.ModeDir|0o755 || string(trees[1].Data) != "supabase" {
+		t.Errorf("unexpected rendered tree: %+v", trees)
+	}
+
+	for _, invalid := range []string{"{{ .Dashboard.Username", "{{ .Dashboard.Unknown }}"} {
+		if _, err := (&EmbeddedFile{Path: "/etc/kong.yml", Data: []byte(invalid), Template: cfg}).Files(); err == nil {
+			t.Errorf("expected template %q to fail", invalid)
+		}
+	}
+	// without a template, data is written verbatim
+	if files, err := (&EmbeddedFile{Path: "/etc/kong.yml", Data: []byte("{{ x }}")}).Files(); err != nil || string(files[0].Data) != "{{ x }}" {
+		t.Errorf("expected data to be written verbatim, got %+v (%v)", files, err)
+	}
+}
+
+func TestEmbeddedFileRejectsInvalidPaths(t *testing.T) {
+	for _, p := range []string{"", "etc/a.conf", "/etc/../a.conf", "/etc/", "/"} {
+		if _, err := (&EmbeddedFile{Path: p, Data: []byte("a")}).Files(); err == nil {
+			t.Errorf("expected path %q to be rejected", p)
+		}
+	}
+}
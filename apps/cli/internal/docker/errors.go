@@ -0,0 +1,188 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/client"
+	"io"
+	"os"
+	"strings"
+)
+
+// classes of errors from the Docker engine; test for them with errors.Is
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrUnavailable  = errors.New("docker is unavailable")
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// Error is an error from the Docker engine with its class
+type Error struct {
+	Op    string // the engine call that failed, e.g. "ImageInspect"
+	Class error  // ErrNotFound, ErrConflict, ErrUnavailable or ErrUnauthorized
+	Err   error  // the error as returned by the engine
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap lets errors.Is match both the class and the engine's error
+func (e *Error) Unwrap() []error {
+	return []error{e.Class, e.Err}
+}
+
+// Classify returns err as an *Error if its class is known (from its errdefs type, which the engine client derives
+// from the HTTP status, or from the connection failure), and err unchanged otherwise
+func Classify(op string, err error) error {
+
+	if err == nil {
+		return nil
+	}
+	var classified *Error
+	if errors.As(err, &classified) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	var class error
+	switch {
+	case cerrdefs.IsNotFound(err):
+		class = ErrNotFound
+	case cerrdefs.IsConflict(err), cerrdefs.IsAlreadyExists(err):
+		class = ErrConflict
+	case cerrdefs.IsUnauthorized(err), cerrdefs.IsPermissionDenied(err), errors.Is(err, os.ErrPermission):
+		class = ErrUnauthorized
+	case client.IsErrConnectionFailed(err):
+		// the client only reports a socket's permission error in its message
+		if strings.HasPrefix(err.Error(), "permission denied") {
+			class = ErrUnauthorized
+		} else {
+			class = ErrUnavailable
+		}
+	case cerrdefs.IsUnavailable(err):
+		class = ErrUnavailable
+	default:
+		return err
+	}
+	return &Error{Op: op, Class: class, Err: err}
+}
+
+// classifyingEngine classifies every error of the engine it wraps
+type classifyingEngine struct {
+	api Engine
+}
+
+func (e classifyingEngine) ContainerCreate(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+	res, err := e.api.ContainerCreate(ctx, options)
+	return res, Classify("ContainerCreate", err)
+}
+
+func (e classifyingEngine) ContainerInspect(ctx context.Context, containerID string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+	res, err := e.api.ContainerInspect(ctx, containerID, options)
+	return res, Classify("ContainerInspect", err)
+}
+
+func (e classifyingEngine) ContainerLogs(ctx context.Context, containerID string, options client.ContainerLogsOptions) (client.ContainerLogsResult, error) {
+	res, err := e.api.ContainerLogs(ctx, containerID, options)
+	return res, Classify("ContainerLogs", err)
+}
+
+func (e classifyingEngine) ContainerList(ctx context.Context, options client.ContainerListOptions) (client.ContainerListResult, error) {
+	res, err := e.api.ContainerList(ctx, options)
+	return res, Classify("ContainerList", err)
+}
+
+func (e classifyingEngine) ContainerRemove(ctx context.Context, containerID string, options client.ContainerRemoveOptions) (client.ContainerRemoveResult, error) {
+	res, err := e.api.ContainerRemove(ctx, containerID, options)
+	return res, Classify("ContainerRemove", err)
+}
+
+func (e classifyingEngine) ContainerRestart(ctx context.Context, containerID string, options client.ContainerRestartOptions) (client.ContainerRestartResult, error) {
+	res, err := e.api.ContainerRestart(ctx, containerID, options)
+	return res, Classify("ContainerRestart", err)
+}
+
+func (e classifyingEngine) ContainerStart(ctx context.Context, containerID string, options client.ContainerStartOptions) (client.ContainerStartResult, error) {
+	res, err := e.api.ContainerStart(ctx, containerID, options)
+	return res, Classify("ContainerStart", err)
+}
+
+func (e classifyingEngine) ContainerStop(ctx context.Context, containerID string, options client.ContainerStopOptions) (client.ContainerStopResult, error) {
+	res, err := e.api.ContainerStop(ctx, containerID, options)
+	return res, Classify("ContainerStop", err)
+}
+
+func (e classifyingEngine) CopyFromContainer(ctx context.Context, containerID string, options client.CopyFromContainerOptions) (client.CopyFromContainerResult, error) {
+	res, err := e.api.CopyFromContainer(ctx, containerID, options)
+	return res, Classify("CopyFromContainer", err)
+}
+
+func (e classifyingEngine) CopyToContainer(ctx context.Context, containerID string, options client.CopyToContainerOptions) (client.CopyToContainerResult, error) {
+	res, err := e.api.CopyToContainer(ctx, containerID, options)
+	return res, Classify("CopyToContainer", err)
+}
+
+// Events classifies the error that ends the stream
+func (e classifyingEngine) Events(ctx context.Context, options client.EventsListOptions) client.EventsResult {
+	res := e.api.Events(ctx, options)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		if err, ok := <-res.Err; ok {
+			errs <- Classify("Events", err)
+		}
+	}()
+	return client.EventsResult{Messages: res.Messages, Err: errs}
+}
+
+func (e classifyingEngine) ExecCreate(ctx context.Context, containerID string, options client.ExecCreateOptions) (client.ExecCreateResult, error) {
+	res, err := e.api.ExecCreate(ctx, containerID, options)
+	return res, Classify("ExecCreate", err)
+}
+
+func (e classifyingEngine) ExecAttach(ctx context.Context, execID string, options client.ExecAttachOptions) (client.ExecAttachResult, error) {
+	res, err := e.api.ExecAttach(ctx, execID, options)
+	return res, Classify("ExecAttach", err)
+}
+
+func (e classifyingEngine) ExecInspect(ctx context.Context, execID string, options client.ExecInspectOptions) (client.ExecInspectResult, error) {
+	res, err := e.api.ExecInspect(ctx, execID, options)
+	return res, Classify("ExecInspect", err)
+}
+
+func (e classifyingEngine) ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+	res, err := e.api.ImageInspect(ctx, imageID, inspectOpts...)
+	return res, Classify("ImageInspect", err)
+}
+
+func (e classifyingEngine) ImagePull(ctx context.Context, refStr string, options client.ImagePullOptions) (client.ImagePullResponse, error) {
+	res, err := e.api.ImagePull(ctx, refStr, options)
+	return res, Classify("ImagePull", err)
+}
+
+func (e classifyingEngine) ImageSave(ctx context.Context, imageIDs []string, saveOpts ...client.ImageSaveOption) (client.ImageSaveResult, error) {
+	res, err := e.api.ImageSave(ctx, imageIDs, saveOpts...)
+	return res, Classify("ImageSave", err)
+}
+
+func (e classifyingEngine) ImageLoad(ctx context.Context, input io.Reader, loadOpts ...client.ImageLoadOption) (client.ImageLoadResult, error) {
+	res, err := e.api.ImageLoad(ctx, input, loadOpts...)
+	return res, Classify("ImageLoad", err)
+}
+
+func (e classifyingEngine) ImageTag(ctx context.Context, options client.ImageTagOptions) (client.ImageTagResult, error) {
+	res, err := e.api.ImageTag(ctx, options)
+	return res, Classify("ImageTag", err)
+}
+
+func (e classifyingEngine) NetworkCreate(ctx context.Context, name string, options client.NetworkCreateOptions) (client.NetworkCreateResult, error) {
+	res, err := e.api.NetworkCreate(ctx, name, options)
+	return res, Classify("NetworkCreate", err)
+}
+
+func (e classifyingEngine) NetworkInspect(ctx context.Context, networkID string, options client.NetworkInspectOptions) (client.NetworkInspectResult, error) {
+	res, err := e.api.NetworkInspect(ctx, networkID, options)
+	return res, Classify("NetworkInspect", err)
+}
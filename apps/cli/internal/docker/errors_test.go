@@ -0,0 +1,79 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/client"
+	"path/filepath"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+
+	for _, tc := range []struct {
+		name  string
+		err   error
+		class error
+	}{
+		{"not found", fmt.Errorf("no such image: %w", cerrdefs.ErrNotFound), ErrNotFound},
+		{"conflict", fmt.Errorf("name is already in use: %w", cerrdefs.ErrConflict), ErrConflict},
+		{"already exists", fmt.Errorf("network exists: %w", cerrdefs.ErrAlreadyExists), ErrConflict},
+		{"unauthorized", fmt.Errorf("pull access denied: %w", cerrdefs.ErrUnauthenticated), ErrUnauthorized},
+		{"permission denied", fmt.Errorf("forbidden: %w", cerrdefs.ErrPermissionDenied), ErrUnauthorized},
+		{"unavailable", fmt.Errorf("daemon is shutting down: %w", cerrdefs.ErrUnavailable), ErrUnavailable},
+		{"unknown", errors.New("something else"), nil},
+		{"canceled", fmt.Errorf("inspect: %w", context.Canceled), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Classify("Test", tc.err)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("classified error %v does not wrap %v", err, tc.err)
+			}
+			if err.Error() != tc.err.Error() {
+				t.Fatalf("got message %q, want %q", err.Error(), tc.err.Error())
+			}
+			for _, class := range []error{ErrNotFound, ErrConflict, ErrUnavailable, ErrUnauthorized} {
+				if got, want := errors.Is(err, class), class == tc.class; got != want {
+					t.Fatalf("errors.Is(%v, %v) = %v, want %v", err, class, got, want)
+				}
+			}
+		})
+	}
+
+	if err := Classify("Test", nil); err != nil {
+		t.Fatalf("got %v for a nil error", err)
+	}
+}
+
+func TestClassifyUnreachableDaemon(t *testing.T) {
+
+	api, err := client.New(client.WithHost("unix://" + filepath.Join(t.TempDir(), "docker.sock")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer api.Close()
+
+	_, err = api.Ping(context.Background(), client.PingOptions{})
+	if err = Classify("Ping", err); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("got %v, want it classified as unavailable", err)
+	}
+}
+
+func TestClientClassifiesEngineErrors(t *testing.T) {
+
+	d, engine := newTestDocker()
+	engine.Fail("NetworkInspect", "", errors.New("Error response from daemon: wording that changed"))
+
+	_, err := d.api.NetworkInspect(context.Background(), "missing", client.NetworkInspectOptions{})
+	if errors.Is(err, ErrNotFound) {
+		t.Fatalf("unclassified error %v was classified as not found", err)
+	}
+
+	_, err = d.api.ContainerInspect(context.Background(), "missing", client.ContainerInspectOptions{})
+	var classified *Error
+	if !errors.As(err, &classified) || classified.Class != ErrNotFound || classified.Op != "ContainerInspect" {
+		t.Fatalf("got %#v, want a not found error from ContainerInspect", err)
+	}
+}
@@ -0,0 +1,1214 @@
+// Package fake is an in-memory Docker engine for testing the orchestrator without a daemon.
+// It simulates images (local and pullable), networks, containers with scripted health transitions,
+// files copied into containers, exec results, events, logs and injected errors; error messages mimic the daemon's.
+package fake
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/events"
+	"github.com/moby/moby/api/types/image"
+	"github.com/moby/moby/api/types/jsonstream"
+	"github.com/moby/moby/api/types/network"
+	"github.com/moby/moby/client"
+	"io"
+	"iter"
+	"maps"
+	"net"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Digest is a well-formed sha256 digest for images pinned in tests; no image has it unless it is added with it
+const Digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// File is a file (or dir) copied into a container
+type File struct {
+	Data []byte
+	Mode int64
+	Uid  int
+	Gid  int
+	Dir  bool
+}
+
+// ExecResult is what an exec in a container prints and exits with
+type ExecResult struct {
+	Output   string
+	ExitCode int
+}
+
+// LogEntry is a line a container logged
+type LogEntry struct {
+	Time   time.Time
+	Stderr bool
+	Text   string
+}
+
+// Container is a simulated container
+type Container struct {
+	ID        string
+	Name      string
+	Options   client.ContainerCreateOptions
+	Running   bool
+	Started   int // number of times the container was (re)started
+	Files     map[string]File
+	Execs     [][]string
+	exitCode  int
+	health    int       // index into the container's health script
+	image     string    // ID of the image the container was created from
+	startedAt time.Time // when the container was last started
+}
+
+type execution struct {
+	container *Container
+	result    ExecResult
+}
+
+// Engine is an in-memory implementation of the engine API; it is safe for concurrent use
+type Engine struct {
+	mu           sync.Mutex
+	images       map[string]image.InspectResponse // local images by reference
+	registry     map[string]image.InspectResponse // pullable images by reference
+	imageFiles   map[string]map[string]File       // files of images, by reference and path
+	configs      map[string][]byte                // image configs, by image ID (the digest of the config)
+	networks     map[string]network.Inspect
+	containers   map[string]*Container // by name
+	execs        map[string]*execution
+	health       map[string][]container.HealthStatus // health statuses reported after each start, by container name
+	healthOutput map[string]string                   // health check output reported in the health log, by container name
+	results      map[string]ExecResult               // exec results, by container name
+	failures     map[string]error                    // injected errors, by "Method" or "Method name"
+	hooks        map[string]func(string)             // called after a successful call, by method, with the container name or reference
+	calls        []string
+	nextID       int
+	watchers     []*watcher                 // subscribers to events
+	logs         map[string][]LogEntry      // by container name
+	followers    map[string][]chan LogEntry // followers of the logs of a running container, by container name
+}
+
+// watcher is a subscriber to the engine's events
+type watcher struct {
+	filters  client.Filters
+	messages chan events.Message
+}
+
+// New returns an engine without images, networks or containers
+func New() *Engine {
+	return &Engine{
+		images:       map[string]image.InspectResponse{},
+		registry:     map[string]image.InspectResponse{},
+		imageFiles:   map[string]map[string]File{},
+		configs:      map[string][]byte{},
+		networks:     map[string]network.Inspect{},
+		containers:   map[string]*Container{},
+		execs:        map[string]*execution{},
+		health:       map[string][]container.HealthStatus{},
+		healthOutput: map[string]string{},
+		results:      map[string]ExecResult{},
+		failures:     map[string]error{},
+		hooks:        map[string]func(string){},
+		logs:         map[string][]LogEntry{},
+		followers:    map[string][]chan LogEntry{},
+	}
+}
+
+// daemonError is an error as returned by the daemon: its message is prefixed, and it wraps an errdefs class
+type daemonError struct {
+	msg   string
+	class error
+}
+
+func (e daemonError) Error() string {
+	return "Error response from daemon: " + e.msg
+}
+
+func (e daemonError) Unwrap() error {
+	return e.class
+}
+
+func notFound(format string, args ...any) error {
+	return daemonError{msg: fmt.Sprintf(format, args...), class: cerrdefs.ErrNotFound}
+}
+
+func conflict(format string, args ...any) error {
+	return daemonError{msg: fmt.Sprintf(format, args...), class: cerrdefs.ErrConflict}
+}
+
+// AddImage makes an image available locally
+func (this *Engine) AddImage(ref string, repoDigests ...string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.images[ref] = this.newImage(ref, repoDigests)
+}
+
+// AddRemoteImage makes an image available to pull
+func (this *Engine) AddRemoteImage(ref string, repoDigests ...string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.registry[ref] = this.newImage(ref, repoDigests)
+}
+
+// newImage returns a new image; like the daemon's, its ID is the digest of its config
+func (this *Engine) newImage(ref string, repoDigests []string) image.InspectResponse {
+	this.nextID++
+	config := []byte(fmt.Sprintf(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]},"comment":"%s #%d"}`, ref, this.nextID))
+	id := blobDigest(config)
+	this.configs[id] = config
+	return image.InspectResponse{ID: id, RepoDigests: repoDigests}
+}
+
+func blobDigest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// ociBlobs returns the OCI index, manifest and config of an image, as a registry serves them and `docker save`
+// archives them, with the digest of the index (its repo digest)
+func ociBlobs(config []byte) (string, [][]byte) {
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":%q,"size":%d},"layers":[]}`,
+		blobDigest(config), len(config)))
+	index := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":%q,"size":%d,"platform":{"architecture":"amd64","os":"linux"}}]}`,
+		blobDigest(manifest), len(manifest)))
+	return blobDigest(index), [][]byte{index, manifest, config}
+}
+
+// ManifestDigest returns the digest of the OCI index of an image (local or pullable), which it can be pinned to
+func (this *Engine) ManifestDigest(ref string) string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	img, ok := this.images[ref]
+	if !ok {
+		img = this.registry[ref]
+	}
+	digest, _ := ociBlobs(this.configs[img.ID])
+	return digest
+}
+
+// AddImageFile adds a file to an image (local or pullable), which the image's containers are created with
+func (this *Engine) AddImageFile(ref string, p string, data []byte) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.imageFiles[ref] == nil {
+		this.imageFiles[ref] = map[string]File{}
+	}
+	this.imageFiles[ref][p] = File{Data: data, Mode: 0o644}
+}
+
+// HasRemoteImage reports whether an image is available to pull
+func (this *Engine) HasRemoteImage(ref string) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	_, ok := this.registry[ref]
+	return ok
+}
+
+// RemoveImage removes a local image
+func (this *Engine) RemoveImage(ref string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	delete(this.images, ref)
+}
+
+// Image returns a local image
+func (this *Engine) Image(ref string) (image.InspectResponse, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	img, ok := this.images[ref]
+	return img, ok
+}
+
+// AddContainer adds a container that was not created through the engine, e.g. left over from an earlier run
+func (this *Engine) AddContainer(name string, ref string, running bool) *Container {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	c := this.newContainer(client.ContainerCreateOptions{Name: name, Config: &container.Config{Image: ref}})
+	c.Running = running
+	return c
+}
+
+func (this *Engine) newContainer(options client.ContainerCreateOptions) *Container {
+	this.nextID++
+	c := &Container{
+		ID:      fmt.Sprintf("%064x", this.nextID),
+		Name:    options.Name,
+		Options: options,
+		Files:   map[string]File{},
+	}
+	if img, ok := this.images[options.Config.Image]; ok {
+		c.image = img.ID
+	}
+	for p, f := range this.imageFiles[options.Config.Image] {
+		c.Files[p] = f
+	}
+	this.containers[c.Name] = c
+	return c
+}
+
+// SetHealth scripts the health statuses a container reports on successive inspects after each start; the last
+// status is repeated. Containers with a health check and no script are healthy right away.
+func (this *Engine) SetHealth(name string, statuses ...container.HealthStatus) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.health[name] = statuses
+}
+
+// SetHealthOutput sets the output of a container's health check, which is reported in its health log
+func (this *Engine) SetHealthOutput(name string, output string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.healthOutput[name] = output
+}
+
+// SetExecResult sets the result of every exec in a container
+func (this *Engine) SetExecResult(name string, result ExecResult) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.results[name] = result
+}
+
+// Fail makes a method fail with err: for every call if name is empty, otherwise only for the container,
+// image or network with that name
+func (this *Engine) Fail(method string, name string, err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.failures[strings.TrimSpace(method+" "+name)] = err
+}
+
+// OnCall registers a func that is called (without the engine's lock) after each successful call of a method,
+// with the container name or reference it was called for
+func (this *Engine) OnCall(method string, hook func(name string)) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.hooks[method] = hook
+}
+
+// Calls returns every call made so far, as "Method name"
+func (this *Engine) Calls() []string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return slices.Clone(this.calls)
+}
+
+// Container returns a copy of a container's state
+func (this *Engine) Container(name string) (Container, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	c, ok := this.containers[name]
+	if !ok {
+		return Container{}, false
+	}
+	copied := *c
+	copied.Files = map[string]File{}
+	for p, f := range c.Files {
+		copied.Files[p] = f
+	}
+	copied.Execs = slices.Clone(c.Execs)
+	return copied, true
+}
+
+// HasImage reports whether an image is available locally
+func (this *Engine) HasImage(ref string) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	_, ok := this.images[ref]
+	return ok
+}
+
+// HasNetwork reports whether a network exists
+func (this *Engine) HasNetwork(name string) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	_, ok := this.networks[name]
+	return ok
+}
+
+// call records a call and returns its injected error, if any; the lock must be held
+func (this *Engine) call(method string, name string) error {
+	this.calls = append(this.calls, method+" "+name)
+	if err, ok := this.failures[method+" "+name]; ok {
+		return err
+	}
+	return this.failures[method]
+}
+
+// done runs the hook of a method after a successful call; the lock must not be held
+func (this *Engine) done(method string, name string) {
+	this.mu.Lock()
+	hook := this.hooks[method]
+	this.mu.Unlock()
+	if hook != nil {
+		hook(name)
+	}
+}
+
+// Log appends lines to a container's logs, as if its process wrote them at the entry's time
+func (this *Engine) Log(name string, entries ...LogEntry) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.logs[name] = append(this.logs[name], entries...)
+	for _, follower := range this.followers[name] {
+		for _, entry := range entries {
+			select {
+			case follower <- entry:
+			default:
+			}
+		}
+	}
+}
+
+// emit sends a container event to the watchers whose filters it matches; the lock must be held. Events are
+// dropped for watchers that do not keep up.
+func (this *Engine) emit(action events.Action, c *Container, attributes map[string]string) {
+	msg := events.Message{
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor:  events.Actor{ID: c.ID, Attributes: map[string]string{"name": c.Name, "image": c.Options.Config.Image}},
+		Time:   1,
+	}
+	for k, v := range c.Options.Config.Labels {
+		msg.Actor.Attributes[k] = v
+	}
+	for k, v := range attributes {
+		msg.Actor.Attributes[k] = v
+	}
+
+	// like the daemon, stop following the logs of a container that exits
+	if action == events.ActionDie {
+		for _, follower := range this.followers[c.Name] {
+			close(follower)
+		}
+		delete(this.followers, c.Name)
+	}
+
+	for _, w := range this.watchers {
+		if !w.matches(msg) {
+			continue
+		}
+		select {
+		case w.messages <- msg:
+		default:
+		}
+	}
+}
+
+// matches reports whether an event passes a watcher's type, event and label filters
+func (w *watcher) matches(msg events.Message) bool {
+	for term, values := range w.filters {
+		switch term {
+		case "type":
+			if !values[string(msg.Type)] {
+				return false
+			}
+		case "event":
+			if !values[string(msg.Action)] {
+				return false
+			}
+		case "label":
+			if !matchesLabels(values, msg.Actor.Attributes) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchesLabels reports whether labels pass label filters, given as "key" or "key=value"
+func matchesLabels(filters map[string]bool, labels map[string]string) bool {
+	for label := range filters {
+		k, v, hasValue := strings.Cut(label, "=")
+		actual, ok := labels[k]
+		if !ok || (hasValue && actual != v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Crash makes a running container exit with a code, as if its process died
+func (this *Engine) Crash(name string, exitCode int) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	c, ok := this.containers[name]
+	if !ok || !c.Running {
+		return
+	}
+	c.Running, c.exitCode = false, exitCode
+	this.emit(events.ActionDie, c, map[string]string{"exitCode": fmt.Sprint(exitCode)})
+}
+
+// find returns a container by ID or name; the lock must be held
+func (this *Engine) find(idOrName string) (*Container, error) {
+	if c, ok := this.containers[strings.TrimPrefix(idOrName, "/")]; ok {
+		return c, nil
+	}
+	for _, c := range this.containers {
+		if c.ID == idOrName {
+			return c, nil
+		}
+	}
+	return nil, notFound("No such container: %s", idOrName)
+}
+
+// name returns the name of a container by ID or name, for recording calls; the lock must be held
+func (this *Engine) name(idOrName string) string {
+	if c, err := this.find(idOrName); err == nil {
+		return c.Name
+	}
+	return idOrName
+}
+
+func (this *Engine) ContainerCreate(_ context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.call("ContainerCreate", options.Name); err != nil {
+		return client.ContainerCreateResult{}, err
+	}
+	if existing, ok := this.containers[options.Name]; ok {
+		return client.ContainerCreateResult{}, conflict(`Conflict. The container name "/%s" is already in use by container "%s". You have to remove (or rename) that container to be able to reuse that name.`, options.Name, existing.ID)
+	}
+	if options.Config == nil {
+		return client.ContainerCreateResult{}, errors.New("Error response from daemon: config cannot be empty in order to create a container")
+	}
+	if _, ok := this.images[options.Config.Image]; !ok {
+		return client.ContainerCreateResult{}, notFound("No such image: %s", options.Config.Image)
+	}
+	c := this.newContainer(options)
+	return client.ContainerCreateResult{ID: c.ID}, nil
+}
+
+func (this *Engine) ContainerInspect(_ context.Context, containerID string, _ client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.call("ContainerInspect", this.name(containerID)); err != nil {
+		return client.ContainerInspectResult{}, err
+	}
+	c, err := this.find(containerID)
+	if err != nil {
+		return client.ContainerInspectResult{}, err
+	}
+
+	state := &container.State{Running: c.Running, ExitCode: c.exitCode}
+	if c.Started > 0 {
+		state.StartedAt = c.startedAt.UTC().Format(time.RFC3339Nano)
+	}
+	switch {
+	case c.Running:
+		state.Status = container.StateRunning
+	case c.Started > 0:
+		state.Status = container.StateExited
+	default:
+		state.Status = container.StateCreated
+	}
+	if c.Running && c.Options.Config.Healthcheck != nil {
+		script := this.health[c.Name]
+		if len(script) == 0 {
+			script = []container.HealthStatus{container.Healthy}
+		}
+		state.Health = &container.Health{Status: script[min(c.health, len(script)-1)]}
+		if output, ok := this.healthOutput[c.Name]; ok {
+			result := &container.HealthcheckResult{Output: output}
+			if state.Health.Status != container.Healthy {
+				result.ExitCode = 1
+			}
+			state.Health.Log = []*container.HealthcheckResult{result}
+		}
+		c.health++
+	}
+
+	// like the daemon, report the ID of the image the container was created from
+	imageID := c.image
+	if imageID == "" {
+		imageID = c.Options.Config.Image
+	}
+
+	return client.ContainerInspectResult{Container: container.InspectResponse{
+		ID:         c.ID,
+		Name:       "/" + c.Name,
+		Image:      imageID,
+		State:      state,
+		Config:     c.Options.Config,
+		HostConfig: c.Options.HostConfig,
+	}}, nil
+}
+
+func (this *Engine) ContainerList(_ context.Context, options client.ContainerListOptions) (client.ContainerListResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.call("ContainerList", ""); err != nil {
+		return client.ContainerListResult{}, err
+	}
+
+	var patterns []*regexp.Regexp
+	for pattern := range options.Filters["name"] {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return client.ContainerListResult{}, fmt.Errorf("Error response from daemon: invalid filter 'name=%s'", pattern)
+		}
+		patterns = append(patterns, re)
+	}
+
+	var result client.ContainerListResult
+	for _, c := range this.containers {
+		if !c.Running && !options.All {
+			continue
+		}
+		if len(patterns) > 0 && !slices.ContainsFunc(patterns, func(re *regexp.Regexp) bool { return re.MatchString("/" + c.Name) }) {
+			continue
+		}
+		if !matchesLabels(options.Filters["label"], c.Options.Config.Labels) {
+			continue
+		}
+		state := container.StateCreated
+		if c.Running {
+			state = container.StateRunning
+		} else if c.Started > 0 {
+			state = container.StateExited
+		}
+		result.Items = append(result.Items, container.Summary{
+			ID:     c.ID,
+			Names:  []string{"/" + c.Name},
+			Image:  c.Options.Config.Image,
+			State:  state,
+			Labels: c.Options.Config.Labels,
+		})
+	}
+	slices.SortFunc(result.Items, func(a, b container.Summary) int { return strings.Compare(a.Names[0], b.Names[0]) })
+	return result, nil
+}
+
+// ContainerLogs streams a container's logs, multiplexed as they are without a TTY; since must be RFC 3339, as the
+// client converts other formats before calling the daemon
+func (this *Engine) ContainerLogs(ctx context.Context, containerID string, options client.ContainerLogsOptions) (client.ContainerLogsResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.call("ContainerLogs", this.name(containerID)); err != nil {
+		return nil, err
+	}
+	c, err := this.find(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var since time.Time
+	if options.Since != "" {
+		if since, err = time.Parse(time.RFC3339Nano, options.Since); err != nil {
+			return nil, daemonError{msg: fmt.Sprintf("invalid since %q", options.Since), class: cerrdefs.ErrInvalidArgument}
+		}
+	}
+	entries := slices.DeleteFunc(slices.Clone(this.logs[c.Name]), func(entry LogEntry) bool { return entry.Time.Before(since) })
+	if options.Tail != "" && options.Tail != "all" {
+		tail, err := strconv.Atoi(options.Tail)
+		if err != nil {
+			return nil, daemonError{msg: fmt.Sprintf("invalid tail %q", options.Tail), class: cerrdefs.ErrInvalidArgument}
+		}
+		entries = entries[max(len(entries)-tail, 0):]
+	}
+
+	var follower chan LogEntry
+	if options.Follow && c.Running {
+		follower = make(chan LogEntry, 256)
+		this.followers[c.Name] = append(this.followers[c.Name], follower)
+	}
+
+	reader, writer := io.Pipe()
+	write := func(entry LogEntry) error {
+		if (entry.Stderr && !options.ShowStderr) || (!entry.Stderr && !options.ShowStdout) {
+			return nil
+		}
+		line := entry.Text + "\n"
+		if options.Timestamps {
+			line = entry.Time.UTC().Format(time.RFC3339Nano) + " " + line
+		}
+		frame := make([]byte, 8, 8+len(line))
+		frame[0] = 1 // stdout
+		if entry.Stderr {
+			frame[0] = 2
+		}
+		binary.BigEndian.PutUint32(frame[4:], uint32(len(line)))
+		_, err := writer.Write(append(frame, line...))
+		return err
+	}
+	go func() {
+		defer this.unfollow(c.Name, follower)
+		for _, entry := range entries {
+			if err := write(entry); err != nil {
+				return
+			}
+		}
+		if follower == nil {
+			_ = writer.Close()
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				_ = writer.CloseWithError(ctx.Err())
+				return
+			case entry, ok := <-follower:
+				if !ok {
+					_ = writer.Close()
+					return
+				}
+				if err := write(entry); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return reader, nil
+}
+
+// unfollow stops sending a container's log lines to a follower, unless it has stopped already
+func (this *Engine) unfollow(name string, follower chan LogEntry) {
+	if follower == nil {
+		return
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.followers[name] = slices.DeleteFunc(this.followers[name], func(other chan LogEntry) bool { return other == follower })
+}
+
+func (this *Engine) ContainerRemove(_ context.Context, containerID string, options client.ContainerRemoveOptions) (client.ContainerRemoveResult, error) {
+	this.mu.Lock()
+	name := this.name(containerID)
+	if err := this.call("ContainerRemove", name); err != nil {
+		this.mu.Unlock()
+		return client.ContainerRemoveResult{}, err
+	}
+	c, err := this.find(containerID)
+	if err != nil {
+		this.mu.Unlock()
+		return client.ContainerRemoveResult{}, err
+	}
+	if c.Running && !options.Force {
+		this.mu.Unlock()
+		return client.ContainerRemoveResult{}, conflict("cannot remove container %q: container is running: stop the container before removing or force remove", "/"+c.Name)
+	}
+	if c.Running {
+		this.emit(events.ActionKill, c, map[string]string{"signal": "9"})
+		this.emit(events.ActionDie, c, map[string]string{"exitCode": "137"})
+	}
+	delete(this.containers, c.Name)
+	this.emit(events.ActionDestroy, c, nil)
+	this.mu.Unlock()
+	this.done("ContainerRemove", name)
+	return client.ContainerRemoveResult{}, nil
+}
+
+func (this *Engine) ContainerRestart(_ context.Context, containerID string, _ client.ContainerRestartOptions) (client.ContainerRestartResult, error) {
+	this.mu.Lock()
+	name := this.name(containerID)
+	if err := this.call("ContainerRestart", name); err != nil {
+		this.mu.Unlock()
+		return client.ContainerRestartResult{}, err
+	}
+	c, err := this.find(containerID)
+	if err != nil {
+		this.mu.Unlock()
+		return client.ContainerRestartResult{}, err
+	}
+	if c.Running {
+		this.emit(events.ActionKill, c, map[string]string{"signal": "15"})
+		this.emit(events.ActionDie, c, map[string]string{"exitCode": "0"})
+	}
+	c.Running, c.exitCode, c.health = true, 0, 0
+	c.Started, c.startedAt = c.Started+1, time.Now()
+	this.emit(events.ActionStart, c, nil)
+	this.emit(events.ActionRestart, c, nil)
+	this.mu.Unlock()
+	this.done("ContainerRestart", name)
+	return client.ContainerRestartResult{}, nil
+}
+
+func (this *Engine) ContainerStart(_ context.Context, containerID string, _ client.ContainerStartOptions) (client.ContainerStartResult, error) {
+	this.mu.Lock()
+	name := this.name(containerID)
+	if err := this.call("ContainerStart", name); err != nil {
+		this.mu.Unlock()
+		return client.ContainerStartResult{}, err
+	}
+	c, err := this.find(containerID)
+	if err != nil {
+		this.mu.Unlock()
+		return client.ContainerStartResult{}, err
+	}
+	if !c.Running {
+		c.Running, c.exitCode, c.health = true, 0, 0
+		c.Started, c.startedAt = c.Started+1, time.Now()
+		this.emit(events.ActionStart, c, nil)
+	}
+	this.mu.Unlock()
+	this.done("ContainerStart", name)
+	return client.ContainerStartResult{}, nil
+}
+
+func (this *Engine) ContainerStop(_ context.Context, containerID string, _ client.ContainerStopOptions) (client.ContainerStopResult, error) {
+	this.mu.Lock()
+	name := this.name(containerID)
+	if err := this.call("ContainerStop", name); err != nil {
+		this.mu.Unlock()
+		return client.ContainerStopResult{}, err
+	}
+	c, err := this.find(containerID)
+	if err != nil {
+		this.mu.Unlock()
+		return client.ContainerStopResult{}, err
+	}
+	if c.Running {
+		c.Running = false
+		this.emit(events.ActionKill, c, map[string]string{"signal": "15"})
+		this.emit(events.ActionDie, c, map[string]string{"exitCode": "0"})
+		this.emit(events.ActionStop, c, nil)
+	}
+	this.mu.Unlock()
+	this.done("ContainerStop", name)
+	return client.ContainerStopResult{}, nil
+}
+
+func (this *Engine) CopyToContainer(_ context.Context, containerID string, options client.CopyToContainerOptions) (client.CopyToContainerResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.call("CopyToContainer", this.name(containerID)); err != nil {
+		return client.CopyToContainerResult{}, err
+	}
+	c, err := this.find(containerID)
+	if err != nil {
+		return client.CopyToContainerResult{}, err
+	}
+
+	tr := tar.NewReader(options.Content)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return client.CopyToContainerResult{}, fmt.Errorf("Error response from daemon: invalid tar archive: %w", err)
+		}
+		file := File{Mode: hdr.Mode, Uid: hdr.Uid, Gid: hdr.Gid, Dir: hdr.Typeflag == tar.TypeDir}
+		if !file.Dir {
+			if file.Data, err = io.ReadAll(tr); err != nil {
+				return client.CopyToContainerResult{}, fmt.Errorf("Error response from daemon: invalid tar archive: %w", err)
+			}
+		}
+		p := path.Join(options.DestinationPath, hdr.Name)
+		c.Files[p] = file
+
+		// like the daemon, create missing parent dirs (as root)
+		for dir := path.Dir(p); dir != "/" && dir != "."; dir = path.Dir(dir) {
+			if _, ok := c.Files[dir]; !ok {
+				c.Files[dir] = File{Mode: 0o755, Dir: true}
+			}
+		}
+	}
+	return client.CopyToContainerResult{}, nil
+}
+
+// CopyFromContainer returns a tar of a file of a container (see AddImageFile and CopyToContainer)
+func (this *Engine) CopyFromContainer(_ context.Context, containerID string, options client.CopyFromContainerOptions) (client.CopyFromContainerResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.call("CopyFromContainer", this.name(containerID)); err != nil {
+		return client.CopyFromContainerResult{}, err
+	}
+	c, err := this.find(containerID)
+	if err != nil {
+		return client.CopyFromContainerResult{}, err
+	}
+	file, ok := c.Files[path.Clean(options.SourcePath)]
+	if !ok {
+		return client.CopyFromContainerResult{}, notFound("Could not find the file %s in container %s", options.SourcePath, c.Name)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	hdr := &tar.Header{Name: path.Base(options.SourcePath), Mode: file.Mode, Uid: file.Uid, Gid: file.Gid, Typeflag: tar.TypeReg, Size: int64(len(file.Data))}
+	if file.Dir {
+		hdr.Typeflag, hdr.Size = tar.TypeDir, 0
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return client.CopyFromContainerResult{}, err
+	}
+	if _, err := tw.Write(file.Data); err != nil {
+		return client.CopyFromContainerResult{}, err
+	}
+	if err := tw.Close(); err != nil {
+		return client.CopyFromContainerResult{}, err
+	}
+	return client.CopyFromContainerResult{Content: io.NopCloser(&buf)}, nil
+}
+
+// Events streams the container events that match the options' filters until the context is done; like the
+// daemon's, the error channel then receives the context's error
+func (this *Engine) Events(ctx context.Context, options client.EventsListOptions) client.EventsResult {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	errs := make(chan error, 1)
+	if err := this.call("Events", ""); err != nil {
+		errs <- err
+		return client.EventsResult{Err: errs}
+	}
+
+	w := &watcher{filters: options.Filters.Clone(), messages: make(chan events.Message, 256)}
+	this.watchers = append(this.watchers, w)
+	go func() {
+		<-ctx.Done()
+		this.mu.Lock()
+		defer this.mu.Unlock()
+		this.watchers = slices.DeleteFunc(this.watchers, func(other *watcher) bool { return other == w })
+		errs <- ctx.Err()
+	}()
+	return client.EventsResult{Messages: w.messages, Err: errs}
+}
+
+func (this *Engine) ExecCreate(_ context.Context, containerID string, options client.ExecCreateOptions) (client.ExecCreateResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.call("ExecCreate", this.name(containerID)); err != nil {
+		return client.ExecCreateResult{}, err
+	}
+	c, err := this.find(containerID)
+	if err != nil {
+		return client.ExecCreateResult{}, err
+	}
+	if !c.Running {
+		return client.ExecCreateResult{}, conflict("container %s is not running", c.ID)
+	}
+	c.Execs = append(c.Execs, slices.Clone(options.Cmd))
+	this.nextID++
+	id := fmt.Sprintf("%064x", this.nextID)
+	this.execs[id] = &execution{container: c, result: this.results[c.Name]}
+	return client.ExecCreateResult{ID: id}, nil
+}
+
+func (this *Engine) ExecAttach(_ context.Context, execID string, _ client.ExecAttachOptions) (client.ExecAttachResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	exec, ok := this.execs[execID]
+	if !ok {
+		return client.ExecAttachResult{}, notFound("No such exec instance: %s", execID)
+	}
+	if err := this.call("ExecAttach", exec.container.Name); err != nil {
+		return client.ExecAttachResult{}, err
+	}
+
+	// the output is multiplexed, as it is without a TTY
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		if exec.result.Output == "" {
+			return
+		}
+		frame := make([]byte, 8, 8+len(exec.result.Output))
+		frame[0] = 1 // stdout
+		binary.BigEndian.PutUint32(frame[4:], uint32(len(exec.result.Output)))
+		_, _ = remote.Write(append(frame, exec.result.Output...))
+	}()
+	return client.ExecAttachResult{HijackedResponse: client.NewHijackedResponse(local, "application/vnd.docker.multiplexed-stream")}, nil
+}
+
+func (this *Engine) ExecInspect(_ context.Context, execID string, _ client.ExecInspectOptions) (client.ExecInspectResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	exec, ok := this.execs[execID]
+	if !ok {
+		return client.ExecInspectResult{}, notFound("No such exec instance: %s", execID)
+	}
+	if err := this.call("ExecInspect", exec.container.Name); err != nil {
+		return client.ExecInspectResult{}, err
+	}
+	return client.ExecInspectResult{ID: execID, ContainerID: exec.container.ID, ExitCode: exec.result.ExitCode}, nil
+}
+
+func (this *Engine) ImageInspect(_ context.Context, imageID string, _ ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.call("ImageInspect", imageID); err != nil {
+		return client.ImageInspectResult{}, err
+	}
+	img, ok := this.images[imageID]
+	if !ok {
+		return client.ImageInspectResult{}, notFound("No such image: %s", imageID)
+	}
+	return client.ImageInspectResult{InspectResponse: img}, nil
+}
+
+func (this *Engine) ImagePull(_ context.Context, refStr string, _ client.ImagePullOptions) (client.ImagePullResponse, error) {
+	this.mu.Lock()
+	if err := this.call("ImagePull", refStr); err != nil {
+		this.mu.Unlock()
+		return nil, err
+	}
+	img, ok := this.registry[refStr]
+	if name, digest, pinned := strings.Cut(refStr, "@"); !ok && pinned {
+		// like a registry, serve the image of the tag if it has the digest, and record the digest it was pulled by
+		if img, ok = this.registry[name]; ok {
+			if index, _ := ociBlobs(this.configs[img.ID]); index != digest {
+				ok = false
+			} else if repoDigest := repository(name) + "@" + digest; !slices.Contains(img.RepoDigests, repoDigest) {
+				img.RepoDigests = append(slices.Clone(img.RepoDigests), repoDigest)
+			}
+		}
+	}
+	if !ok {
+		this.mu.Unlock()
+		return nil, notFound("pull access denied for %s, repository does not exist or may require 'docker login'", refStr)
+	}
+	this.images[refStr] = img
+	this.mu.Unlock()
+	this.done("ImagePull", refStr)
+
+	tag := refStr[strings.LastIndex(refStr, ":")+1:]
+	messages := []jsonstream.Message{
+		{Status: "Pulling from " + strings.TrimSuffix(refStr, ":"+tag), ID: tag},
+		{Status: "Pulling fs layer", ID: "layer0"},
+		{Status: "Downloading", ID: "layer0", Progress: &jsonstream.Progress{Current: 512, Total: 1024}},
+		{Status: "Download complete", ID: "layer0"},
+		{Status: "Pull complete", ID: "layer0"},
+		{Status: "Status: Downloaded newer image for " + refStr},
+	}
+	var stream strings.Builder
+	for _, msg := range messages {
+		data, _ := json.Marshal(msg)
+		stream.Write(append(data, '\n'))
+	}
+	return &pullResponse{ReadCloser: io.NopCloser(strings.NewReader(stream.String()))}, nil
+}
+
+// pullResponse is a pull's progress stream
+type pullResponse struct {
+	io.ReadCloser
+}
+
+func (r *pullResponse) JSONMessages(ctx context.Context) iter.Seq2[jsonstream.Message, error] {
+	return func(yield func(jsonstream.Message, error) bool) {
+		decoder := json.NewDecoder(r)
+		for ctx.Err() == nil {
+			var msg jsonstream.Message
+			if err := decoder.Decode(&msg); err != nil {
+				if !errors.Is(err, io.EOF) {
+					yield(msg, err)
+				}
+				return
+			}
+			if !yield(msg, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (r *pullResponse) Wait(ctx context.Context) error {
+	for _, err := range r.JSONMessages(ctx) {
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// repository returns a reference without its tag
+func repository(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i]
+	}
+	return ref
+}
+
+// ociDescriptor is a descriptor in an OCI index
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int               `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociImageName is the annotation of index.json that names an image in a `docker save` archive
+const ociImageName = "io.containerd.image.name"
+
+// ImageSave writes an OCI image layout, like the daemon: index.json lists each image's index, and the blobs are
+// kept by digest (images have no layers)
+func (this *Engine) ImageSave(_ context.Context, imageIDs []string, _ ...client.ImageSaveOption) (client.ImageSaveResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	files := map[string][]byte{"oci-layout": []byte(`{"imageLayoutVersion":"1.0.0"}`)}
+	var manifests []ociDescriptor
+	for _, ref := range imageIDs {
+		if err := this.call("ImageSave", ref); err != nil {
+			return nil, err
+		}
+		img, ok := this.images[ref]
+		if !ok {
+			return nil, notFound("No such image: %s", ref)
+		}
+		digest, blobs := ociBlobs(this.configs[img.ID])
+		for _, blob := range blobs {
+			files["blobs/sha256/"+strings.TrimPrefix(blobDigest(blob), "sha256:")] = blob
+		}
+		manifests = append(manifests, ociDescriptor{
+			MediaType:   "application/vnd.oci.image.index.v1+json",
+			Digest:      digest,
+			Size:        len(blobs[0]),
+			Annotations: map[string]string{ociImageName: ref},
+		})
+	}
+	index, err := json.Marshal(map[string]any{"schemaVersion": 2, "manifests": manifests})
+	if err != nil {
+		return nil, err
+	}
+	files["index.json"] = index
+
+	var archive strings.Builder
+	tw := tar.NewWriter(&archive)
+	names := slices.Sorted(maps.Keys(files))
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(archive.String())), nil
+}
+
+// ImageLoad loads archives of ImageSave, verifying every blob it uses; like the daemon's classic image store, it
+// does not keep repo digests
+func (this *Engine) ImageLoad(_ context.Context, input io.Reader, _ ...client.ImageLoadOption) (client.ImageLoadResult, error) {
+	res, err := this.imageLoad(input)
+	if err == nil {
+		this.done("ImageLoad", "")
+	}
+	return res, err
+}
+
+func (this *Engine) imageLoad(input io.Reader) (client.ImageLoadResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.call("ImageLoad", ""); err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(input)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error response from daemon: invalid tar archive: %w", err)
+		}
+		if files[hdr.Name], err = io.ReadAll(tr); err != nil {
+			return nil, fmt.Errorf("Error response from daemon: invalid tar archive: %w", err)
+		}
+	}
+	blob := func(digest string) ([]byte, error) {
+		data, ok := files["blobs/sha256/"+strings.TrimPrefix(digest, "sha256:")]
+		if !ok || blobDigest(data) != digest {
+			return nil, fmt.Errorf("Error response from daemon: invalid image archive: blob %s is missing or corrupt", digest)
+		}
+		return data, nil
+	}
+
+	var index struct {
+		Manifests []ociDescriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(files["index.json"], &index); err != nil || len(index.Manifests) == 0 {
+		return nil, errors.New("Error response from daemon: invalid image archive: no images")
+	}
+	var stream strings.Builder
+	for _, desc := range index.Manifests {
+		var imageIndex struct {
+			Manifests []ociDescriptor `json:"manifests"`
+		}
+		var manifest struct {
+			Config ociDescriptor `json:"config"`
+		}
+		data, err := blob(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &imageIndex); err != nil || len(imageIndex.Manifests) == 0 {
+			return nil, fmt.Errorf("Error response from daemon: invalid image archive: invalid index %s", desc.Digest)
+		}
+		if data, err = blob(imageIndex.Manifests[0].Digest); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("Error response from daemon: invalid image archive: invalid manifest %s", imageIndex.Manifests[0].Digest)
+		}
+		config, err := blob(manifest.Config.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		ref := desc.Annotations[ociImageName]
+		this.configs[manifest.Config.Digest] = config
+		this.images[ref] = image.InspectResponse{ID: manifest.Config.Digest}
+		data, _ = json.Marshal(jsonstream.Message{Stream: "Loaded image: " + ref + "\n"})
+		stream.Write(append(data, '\n'))
+	}
+	return io.NopCloser(strings.NewReader(stream.String())), nil
+}
+
+func (this *Engine) ImageTag(_ context.Context, options client.ImageTagOptions) (client.ImageTagResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.call("ImageTag", options.Target); err != nil {
+		return client.ImageTagResult{}, err
+	}
+	img, ok := this.images[options.Source]
+	if !ok {
+		return client.ImageTagResult{}, notFound("No such image: %s", options.Source)
+	}
+	this.images[options.Target] = img
+	if files, ok := this.imageFiles[options.Source]; ok && this.imageFiles[options.Target] == nil {
+		this.imageFiles[options.Target] = files
+	}
+	return client.ImageTagResult{}, nil
+}
+
+func (this *Engine) NetworkCreate(_ context.Context, name string, options client.NetworkCreateOptions) (client.NetworkCreateResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.call("NetworkCreate", name); err != nil {
+		return client.NetworkCreateResult{}, err
+	}
+	if _, ok := this.networks[name]; ok {
+		return client.NetworkCreateResult{}, conflict("network with name %s already exists", name)
+	}
+	this.nextID++
+	id := fmt.Sprintf("%064x", this.nextID)
+	this.networks[name] = network.Inspect{Network: network.Network{ID: id, Name: name, Driver: options.Driver}}
+	return client.NetworkCreateResult{ID: id}, nil
+}
+
+func (this *Engine) NetworkInspect(_ context.Context, networkID string, _ client.NetworkInspectOptions) (client.NetworkInspectResult, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err := this.call("NetworkInspect", networkID); err != nil {
+		return client.NetworkInspectResult{}, err
+	}
+	n, ok := this.networks[networkID]
+	if !ok {
+		return client.NetworkInspectResult{}, notFound("network %s not found", networkID)
+	}
+	return client.NetworkInspectResult{Network: n}, nil
+}
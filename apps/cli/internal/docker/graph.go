@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"strings"
+)
+
+// dependencyOrder checks the containers' dependencies and returns the containers so that each comes after its
+// dependencies, otherwise keeping their order
+func dependencyOrder(containers []*Container) ([]*Container, error) {
+
+	byName := map[string]*Container{}
+	for _, c := range containers {
+		if _, ok := byName[c.Name]; ok {
+			return nil, fmt.Errorf("container %s is defined more than once", c.Name)
+		}
+		byName[c.Name] = c
+	}
+
+	waiting := map[string]int{}         // number of unsatisfied dependencies per container
+	dependents := map[string][]string{} // containers waiting on each container
+	for _, c := range containers {
+		for _, dep := range c.DependsOn {
+			if dep == c.Name {
+				return nil, fmt.Errorf("container %s depends on itself", c.Name)
+			}
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("container %s depends on %s, which is not defined", c.Name, dep)
+			}
+			waiting[c.Name]++
+			dependents[dep] = append(dependents[dep], c.Name)
+		}
+	}
+
+	order := make([]*Container, 0, len(containers))
+	placed := map[string]bool{}
+	for len(order) < len(containers) {
+		progress := false
+		for _, c := range containers {
+			if placed[c.Name] || waiting[c.Name] > 0 {
+				continue
+			}
+			order = append(order, c)
+			placed[c.Name] = true
+			progress = true
+			for _, dependent := range dependents[c.Name] {
+				waiting[dependent]--
+			}
+		}
+		if !progress {
+			var cycle []string
+			for _, c := range containers {
+				if !placed[c.Name] {
+					cycle = append(cycle, c.Name)
+				}
+			}
+			return nil, fmt.Errorf("containers %s have circular dependencies", strings.Join(cycle, ", "))
+		}
+	}
+	return order, nil
+}
+
+// partialDependencyOrder orders some containers like dependencyOrder, ignoring dependencies on containers that are
+// not among them; containers whose dependencies cannot be ordered are returned as given
+func partialDependencyOrder(containers []*Container) []*Container {
+
+	byName := map[string]*Container{}
+	for _, c := range containers {
+		byName[c.Name] = c
+	}
+	partial := make([]*Container, len(containers))
+	for i, c := range containers {
+		partial[i] = &Container{Name: c.Name}
+		for _, dep := range c.DependsOn {
+			if _, ok := byName[dep]; ok {
+				partial[i].DependsOn = append(partial[i].DependsOn, dep)
+			}
+		}
+	}
+
+	order, err := dependencyOrder(partial)
+	if err != nil {
+		logger.Global().Debugf("could not order containers: %v", err)
+		return containers
+	}
+	ordered := make([]*Container, len(order))
+	for i, c := range order {
+		ordered[i] = byName[c.Name]
+	}
+	return ordered
+}
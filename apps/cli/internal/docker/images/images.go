@@ -0,0 +1,128 @@
+package images
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"slices"
+	"sync"
+)
+
+// ManifestVersion is the current schema version of the image manifest
+//
+//   - v1: image (repository and tag) and optional digest per service
+const ManifestVersion = 1
+
+//go:embed manifest.json
+var manifestData []byte
+
+var (
+	manifest     Manifest
+	manifestOnce sync.Once
+)
+
+// Image is a container image, optionally pinned to a digest
+type Image struct {
+	Image  string `json:"image"`            // repository and tag, e.g. docker.io/kong:3.9.1
+	Digest string `json:"digest,omitempty"` // sha256 digest of the image's manifest (list), as listed in RepoDigests
+}
+
+// Manifest lists the image of every service this build runs
+type Manifest struct {
+	Version int              `json:"version"`
+	Images  map[string]Image `json:"images"`
+}
+
+// String returns the image as a single reference, including the digest if pinned
+func (i Image) String() string {
+	if i.Digest == "" {
+		return i.Image
+	}
+	return i.Image + "@" + i.Digest
+}
+
+// Pinned reports whether the image is pinned to a digest
+func (i Image) Pinned() bool {
+	return i.Digest != ""
+}
+
+// Parse splits a reference such as "ghcr.io/supabase/gotrue:v2.184.0@sha256:…" into an image and its digest
+func Parse(ref string) (Image, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return Image{}, fmt.Errorf("%q is not a valid image reference: %w", ref, err)
+	}
+	var image Image
+	if digested, ok := named.(reference.Digested); ok {
+		if digested.Digest().Algorithm() != digest.SHA256 {
+			return Image{}, fmt.Errorf("%q must be pinned with a sha256 digest", ref)
+		}
+		image.Digest = digested.Digest().String()
+	}
+	if tagged, ok := named.(reference.Tagged); ok {
+		image.Image = reference.TrimNamed(named).String() + ":" + tagged.Tag()
+	} else if image.Digest != "" {
+		image.Image = reference.TrimNamed(named).String()
+	} else {
+		image.Image = reference.TagNameOnly(named).String()
+	}
+	return image, nil
+}
+
+// MatchesDigest reports whether one of an image's repo digests (see ImageInspect) is the pinned digest
+// of the same repository
+func (i Image) MatchesDigest(repoDigests []string) bool {
+	named, err := reference.ParseNormalizedNamed(i.Image)
+	if err != nil {
+		return false
+	}
+	for _, repoDigest := range repoDigests {
+		actual, err := reference.ParseNormalizedNamed(repoDigest)
+		if err != nil {
+			continue
+		}
+		if digested, ok := actual.(reference.Digested); ok && actual.Name() == named.Name() && digested.Digest().String() == i.Digest {
+			return true
+		}
+	}
+	return false
+}
+
+// Load returns the embedded manifest; it panics if the manifest is invalid, which is a build error
+func Load() Manifest {
+	manifestOnce.Do(func() {
+		if err := json.Unmarshal(manifestData, &manifest); err != nil {
+			panic(fmt.Errorf("invalid image manifest: %w", err))
+		}
+		if manifest.Version != ManifestVersion {
+			panic(fmt.Errorf("image manifest has version %d, expected %d", manifest.Version, ManifestVersion))
+		}
+		for service, image := range manifest.Images {
+			if _, err := Parse(image.String()); err != nil {
+				panic(fmt.Errorf("invalid image for %s in manifest: %w", service, err))
+			}
+		}
+	})
+	return manifest
+}
+
+// Services returns the name of every service in the manifest, sorted
+func Services() []string {
+	var services []string
+	for service := range Load().Images {
+		services = append(services, service)
+	}
+	slices.Sort(services)
+	return services
+}
+
+// Get returns the manifest image of a service; it panics on unknown services
+func Get(service string) Image {
+	image, ok := Load().Images[service]
+	if !ok {
+		panic(fmt.Errorf("service %s is not in the image manifest", service))
+	}
+	return image
+}
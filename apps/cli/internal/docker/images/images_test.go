@@ -0,0 +1,20 @@
+package images
+
+import (
+	"github.com/opencontainers/go-digest"
+	"testing"
+)
+
+// every image this build runs must be pinned, so that serve, pull and bundles can verify it
+func TestManifestPinsEveryImage(t *testing.T) {
+	for _, service := range Services() {
+		image := Get(service)
+		if image.Digest == "" {
+			t.Errorf("%s (%s) has no digest; pin it with the digest of its manifest list (`docker buildx imagetools inspect %s`)", service, image.Image, image.Image)
+			continue
+		}
+		if d, err := digest.Parse(image.Digest); err != nil || d.Algorithm() != digest.SHA256 {
+			t.Errorf("%s (%s) has the invalid digest %q; expected a sha256 digest", service, image.Image, image.Digest)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ContainerStatus is the status of a container of the instance, as found by its labels
+type ContainerStatus struct {
+	Service   string     `json:"service"`
+	Aliases   []string   `json:"aliases,omitempty"`
+	Container string     `json:"container"`
+	Image     string     `json:"image"`
+	State     string     `json:"state"`            // e.g. running, exited
+	Health    string     `json:"health,omitempty"` // healthy, unhealthy or starting; empty without a health check
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	Uptime    int64      `json:"uptime_seconds"` // 0 unless running
+	Ports     []string   `json:"ports"`          // published ports, as host-ip:host-port->container-port/protocol
+	DependsOn []string   `json:"depends_on,omitempty"`
+}
+
+// InstanceContainers returns the status of every container of the instance (running or not), in dependency order
+func (this *Docker) InstanceContainers(ctx context.Context) ([]ContainerStatus, error) {
+
+	list, err := this.api.ContainerList(ctx, client.ContainerListOptions{
+		All:     true,
+		Filters: make(client.Filters).Add("label", ProjectLabel+"="+ProjectName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list containers: %w", err)
+	}
+
+	var statuses []ContainerStatus
+	for _, item := range list.Items {
+		name := strings.TrimPrefix(item.Names[0], "/")
+		inspect, err := this.api.ContainerInspect(ctx, item.ID, client.ContainerInspectOptions{})
+		if errors.Is(err, ErrNotFound) {
+			continue // removed since it was listed
+		} else if err != nil {
+			return nil, fmt.Errorf("could not inspect container %s: %w", name, err)
+		}
+		statuses = append(statuses, containerStatus(name, item, inspect.Container))
+	}
+	return dependencyOrderOf(statuses), nil
+}
+
+// InstanceServices returns the containers of the instance (see InstanceContainers) with only their names, aliases
+// and dependencies, e.g. to show their logs without the definitions of the instance
+func (this *Docker) InstanceServices(ctx context.Context) ([]*Container, error) {
+
+	statuses, err := this.InstanceContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var containers []*Container
+	for _, status := range statuses {
+		aliases := status.Aliases
+		if len(aliases) == 0 {
+			aliases = []string{status.Service}
+		}
+		containers = append(containers, &Container{Name: status.Container, Aliases: aliases, DependsOn: status.DependsOn})
+	}
+	return containers, nil
+}
+
+// containerStatus returns the status of a listed and inspected container
+func containerStatus(name string, item container.Summary, inspect container.InspectResponse) ContainerStatus {
+
+	status := ContainerStatus{
+		Service:   item.Labels[ServiceLabel],
+		Container: name,
+		Image:     item.Image,
+		State:     string(item.State),
+		Ports:     []string{},
+	}
+	if status.Service == "" {
+		status.Service = name
+	}
+	if aliases := item.Labels[AliasesLabel]; aliases != "" {
+		status.Aliases = strings.Split(aliases, ",")
+	}
+	if deps := item.Labels[DependsOnLabel]; deps != "" {
+		status.DependsOn = strings.Split(deps, ",")
+	}
+
+	if state := inspect.State; state != nil {
+		status.State = string(state.Status)
+		if state.Health != nil {
+			status.Health = string(state.Health.Status)
+		}
+		if started, err := time.Parse(time.RFC3339Nano, state.StartedAt); err == nil && state.Running {
+			status.StartedAt = &started
+			status.Uptime = int64(time.Since(started).Seconds())
+		}
+	}
+
+	if inspect.HostConfig != nil {
+		for port, bindings := range inspect.HostConfig.PortBindings {
+			for _, binding := range bindings {
+				status.Ports = append(status.Ports, fmt.Sprintf("%s:%s->%s", binding.HostIP, binding.HostPort, port))
+			}
+		}
+		slices.Sort(status.Ports)
+	}
+	return status
+}
+
+// dependencyOrderOf orders the containers of the instance so that each comes after its dependencies (as recorded
+// by their labels), otherwise by service name
+func dependencyOrderOf(statuses []ContainerStatus) []ContainerStatus {
+
+	slices.SortFunc(statuses, func(a, b ContainerStatus) int { return strings.Compare(a.Service, b.Service) })
+	containers := make([]*Container, len(statuses))
+	byName := map[string]ContainerStatus{}
+	for i, status := range statuses {
+		byName[status.Container] = status
+		containers[i] = &Container{Name: status.Container, DependsOn: status.DependsOn}
+	}
+
+	ordered := make([]ContainerStatus, len(statuses))
+	for i, c := range partialDependencyOrder(containers) {
+		ordered[i] = byName[c.Name]
+	}
+	return ordered
+}
+
+// RestartServices restarts the running containers among the given ones (see Restart), dependencies first, and
+// returns those that are not running
+func (this *Docker) RestartServices(ctx context.Context, containers []*Container) ([]*Container, error) {
+
+	var stopped []*Container
+	for _, c := range partialDependencyOrder(containers) {
+		if running, err := this.IsRunning(ctx, c); err != nil {
+			return stopped, err
+		} else if !running {
+			stopped = append(stopped, c)
+			continue
+		}
+		logger.Global().Infof("restarting %s", c.ServiceName())
+		if err := this.Restart(ctx, c); err != nil {
+			return stopped, fmt.Errorf("could not restart %s: %w", c.ServiceName(), err)
+		}
+	}
+	return stopped, nil
+}
+
+// Down stops and removes every container of the instance (found by their labels, whether or not this process
+// started them), dependents before their dependencies
+func (this *Docker) Down(ctx context.Context) error {
+
+	// obtain lock
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	statuses, err := this.InstanceContainers(ctx)
+	if err != nil {
+		return err
+	}
+	slices.Reverse(statuses)
+
+	var errs []error
+	for _, status := range statuses {
+		if status.State == string(container.StateRunning) || status.State == string(container.StateRestarting) {
+			logger.Global().Infof("stopping %s", status.Service)
+			if _, err := this.api.ContainerStop(ctx, status.Container, client.ContainerStopOptions{}); err != nil && !errors.Is(err, ErrNotFound) {
+				errs = append(errs, fmt.Errorf("could not stop %s: %w", status.Service, err))
+				continue
+			}
+		}
+		logger.Global().Debugf("removing container %s", status.Container)
+		if _, err := this.api.ContainerRemove(ctx, status.Container, client.ContainerRemoveOptions{
+			RemoveVolumes: true,
+			Force:         true,
+		}); err != nil && !errors.Is(err, ErrNotFound) {
+			errs = append(errs, fmt.Errorf("could not remove %s: %w", status.Service, err))
+		}
+	}
+	return errors.Join(errs...)
+}
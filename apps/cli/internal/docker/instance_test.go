@@ -0,0 +1,109 @@
+package docker
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestInstanceContainersAreFoundByLabel(t *testing.T) {
+	dkr, engine := newTestDocker()
+	db, auth := testContainer(engine, "db"), testContainer(engine, "auth", "db")
+	db.Aliases = []string{"postgres"}
+	db.Ports = []*PortBindingMap{{Server: &PortBinding{Host: "127.0.0.1", Port: 54322}, ContainerPort: 5432}}
+	if err := run(t, dkr, auth, db); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	engine.AddContainer("unrelated", "example.com/unrelated:1", true)
+	engine.Crash("auth", 1)
+
+	statuses, err := NewClient(engine).InstanceContainers(context.Background())
+	if err != nil {
+		t.Fatalf("could not get the instance's containers: %v", err)
+	}
+	if len(statuses) != 2 || statuses[0].Container != "db" || statuses[1].Container != "auth" {
+		t.Fatalf("expected db and then auth, got %+v", statuses)
+	}
+	pg := statuses[0]
+	if pg.Service != "postgres" || pg.State != "running" || pg.Health != "healthy" || pg.StartedAt == nil || pg.Image != db.Image {
+		t.Errorf("unexpected status of db: %+v", pg)
+	}
+	if strings.Join(pg.Ports, ",") != "127.0.0.1:54322->5432/tcp" {
+		t.Errorf("expected the published port of db, got %v", pg.Ports)
+	}
+	if a := statuses[1]; a.State != "exited" || a.StartedAt != nil || a.Uptime != 0 || !slices.Equal(a.DependsOn, []string{"db"}) {
+		t.Errorf("unexpected status of auth: %+v", a)
+	}
+}
+
+func TestInstanceServicesAreFoundByTheirAliases(t *testing.T) {
+	dkr, engine := newTestDocker()
+	db, auth := testContainer(engine, "db"), testContainer(engine, "auth", "db")
+	db.Aliases = []string{"postgres", "pg"}
+	if err := run(t, dkr, auth, db); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	// e.g. for the logs of a service, without the definitions of the instance
+	services, err := NewClient(engine).InstanceServices(context.Background())
+	if err != nil {
+		t.Fatalf("could not get the instance's services: %v", err)
+	}
+	found, err := FindContainers(services, "pg", "auth")
+	if err != nil {
+		t.Fatalf("could not find the services: %v", err)
+	}
+	if found[0].Name != "db" || found[0].ServiceName() != "postgres" || found[1].Name != "auth" || !slices.Equal(found[1].DependsOn, []string{"db"}) {
+		t.Errorf("unexpected services: %+v, %+v", found[0], found[1])
+	}
+}
+
+func TestDownStopsDependentsFirst(t *testing.T) {
+	dkr, engine := newTestDocker()
+	if err := run(t, dkr, testContainer(engine, "db"), testContainer(engine, "auth", "db"), testContainer(engine, "kong", "auth")); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	engine.AddContainer("unrelated", "example.com/unrelated:1", true)
+
+	// e.g. from another shell
+	if err := NewClient(engine).Down(context.Background()); err != nil {
+		t.Fatalf("down failed: %v", err)
+	}
+	calls := engine.Calls()
+	if !(indexOf(t, calls, "ContainerStop kong") < indexOf(t, calls, "ContainerStop auth") && indexOf(t, calls, "ContainerStop auth") < indexOf(t, calls, "ContainerStop db")) {
+		t.Errorf("expected kong, auth and db to be stopped in that order: %v", calls)
+	}
+	for _, name := range []string{"db", "auth", "kong"} {
+		if _, ok := engine.Container(name); ok {
+			t.Errorf("container %s was not removed", name)
+		}
+	}
+	if c, ok := engine.Container("unrelated"); !ok || !c.Running {
+		t.Errorf("a container of another project was stopped")
+	}
+}
+
+func TestRestartServicesSkipsStoppedServices(t *testing.T) {
+	dkr, engine := newTestDocker()
+	db, auth, rest := testContainer(engine, "db"), testContainer(engine, "auth", "db"), testContainer(engine, "rest", "db")
+	if err := run(t, dkr, db, auth, rest); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	engine.Crash("rest", 1)
+
+	stopped, err := NewClient(engine).RestartServices(context.Background(), []*Container{redefine(auth), redefine(rest), redefine(db)})
+	if err != nil {
+		t.Fatalf("restart failed: %v", err)
+	}
+	if len(stopped) != 1 || stopped[0].Name != "rest" {
+		t.Errorf("expected rest to be skipped, got %v", stopped)
+	}
+	calls := engine.Calls()
+	if indexOf(t, calls, "ContainerRestart db") > indexOf(t, calls, "ContainerRestart auth") {
+		t.Errorf("auth was restarted before db: %v", calls)
+	}
+	if slices.Contains(calls, "ContainerRestart rest") {
+		t.Errorf("the stopped service was restarted: %v", calls)
+	}
+}
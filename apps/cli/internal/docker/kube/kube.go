@@ -0,0 +1,539 @@
+// Package kube exports the containers of an instance as Kubernetes manifests, so that the stack can be deployed to
+// a cluster (e.g. k3s) from the same definitions serve runs.
+package kube
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/moby/moby/api/types/mount"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/images"
+	"gopkg.in/yaml.v3"
+	"math"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Object is a Kubernetes object; only the fields of its kind are set
+type Object struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   Metadata          `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	BinaryData map[string]string `yaml:"binaryData,omitempty"`
+	StringData map[string]string `yaml:"stringData,omitempty"`
+	Spec       any               `yaml:"spec,omitempty"`
+}
+
+type Metadata struct {
+	Name   string            `yaml:"name,omitempty"`
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+// WorkloadSpec is the spec of a Deployment or StatefulSet
+type WorkloadSpec struct {
+	Replicas    int         `yaml:"replicas"`
+	ServiceName string      `yaml:"serviceName,omitempty"` // StatefulSet only
+	Selector    Selector    `yaml:"selector"`
+	Template    PodTemplate `yaml:"template"`
+}
+
+type JobSpec struct {
+	BackoffLimit int         `yaml:"backoffLimit"`
+	Template     PodTemplate `yaml:"template"`
+}
+
+type ServiceSpec struct {
+	Selector map[string]string `yaml:"selector"`
+	Ports    []ServicePort     `yaml:"ports"`
+}
+
+type ServicePort struct {
+	Name       string `yaml:"name"`
+	Port       uint16 `yaml:"port"`
+	TargetPort uint16 `yaml:"targetPort"`
+}
+
+type ClaimSpec struct {
+	AccessModes []string  `yaml:"accessModes"`
+	Resources   Resources `yaml:"resources"`
+}
+
+type Resources struct {
+	Requests map[string]string `yaml:"requests"`
+}
+
+type Selector struct {
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+type PodTemplate struct {
+	Metadata Metadata `yaml:"metadata"`
+	Spec     PodSpec  `yaml:"spec"`
+}
+
+type PodSpec struct {
+	EnableServiceLinks bool        `yaml:"enableServiceLinks"`
+	RestartPolicy      string      `yaml:"restartPolicy,omitempty"`
+	Containers         []Container `yaml:"containers"`
+	Volumes            []Volume    `yaml:"volumes,omitempty"`
+}
+
+type Container struct {
+	Name           string          `yaml:"name"`
+	Image          string          `yaml:"image"`
+	Command        []string        `yaml:"command,omitempty"`
+	Args           []string        `yaml:"args,omitempty"`
+	Env            []EnvVar        `yaml:"env,omitempty"`
+	Ports          []ContainerPort `yaml:"ports,omitempty"`
+	VolumeMounts   []VolumeMount   `yaml:"volumeMounts,omitempty"`
+	ReadinessProbe *Probe          `yaml:"readinessProbe,omitempty"`
+}
+
+type EnvVar struct {
+	Name      string        `yaml:"name"`
+	Value     *string       `yaml:"value,omitempty"`
+	ValueFrom *EnvVarSource `yaml:"valueFrom,omitempty"`
+}
+
+type EnvVarSource struct {
+	SecretKeyRef KeySelector `yaml:"secretKeyRef"`
+}
+
+type KeySelector struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+type ContainerPort struct {
+	ContainerPort uint16 `yaml:"containerPort"`
+}
+
+type VolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	SubPath   string `yaml:"subPath,omitempty"`
+	ReadOnly  bool   `yaml:"readOnly,omitempty"`
+}
+
+type Volume struct {
+	Name                  string        `yaml:"name"`
+	ConfigMap             *ConfigMapRef `yaml:"configMap,omitempty"`
+	Secret                *SecretRef    `yaml:"secret,omitempty"`
+	PersistentVolumeClaim *ClaimRef     `yaml:"persistentVolumeClaim,omitempty"`
+}
+
+type ConfigMapRef struct {
+	Name  string      `yaml:"name"`
+	Items []KeyToPath `yaml:"items,omitempty"`
+}
+
+type SecretRef struct {
+	SecretName string      `yaml:"secretName"`
+	Items      []KeyToPath `yaml:"items,omitempty"`
+}
+
+// KeyToPath projects a key of a ConfigMap or Secret to a file of its volume, with a mode (in decimal)
+type KeyToPath struct {
+	Key  string `yaml:"key"`
+	Path string `yaml:"path"`
+	Mode int    `yaml:"mode"`
+}
+
+type ClaimRef struct {
+	ClaimName string `yaml:"claimName"`
+}
+
+type Probe struct {
+	Exec                ExecAction `yaml:"exec"`
+	InitialDelaySeconds int        `yaml:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int        `yaml:"periodSeconds,omitempty"`
+	TimeoutSeconds      int        `yaml:"timeoutSeconds,omitempty"`
+	FailureThreshold    int        `yaml:"failureThreshold,omitempty"`
+}
+
+type ExecAction struct {
+	Command []string `yaml:"command"`
+}
+
+// Export is the manifests of a stack, in the order they are applied, with what could not be exported
+type Export struct {
+	Objects  []Object
+	Warnings []string
+}
+
+// YAML returns the manifests as YAML documents
+func (this *Export) YAML() ([]byte, error) {
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	for _, obj := range this.Objects {
+		if err := enc.Encode(obj); err != nil {
+			return nil, fmt.Errorf("could not encode %s %s: %w", obj.Kind, obj.Metadata.Name, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("could not encode manifests: %w", err)
+	}
+	return []byte(b.String()), nil
+}
+
+// DefaultStorageSize is the size of the volume claims, unless configured
+const DefaultStorageSize = "10Gi"
+
+// Exporter exports containers as Kubernetes manifests
+type Exporter struct {
+	StorageSize string // the size each volume claim requests; defaults to DefaultStorageSize
+}
+
+// Export returns the manifests that run the containers as serve would. Each container becomes a Deployment (or
+// a StatefulSet, with a PersistentVolumeClaim for each of its mounts), reachable by its service and container
+// names through Services for its exposed and published ports. Embedded files are mounted from a ConfigMap (or a
+// Secret, if sensitive), and environment variables that look sensitive are taken from a Secret. Health checks
+// become readiness probes, and after-start hooks Jobs. What cannot be exported is listed in the warnings.
+func (this Exporter) Export(containers []*docker.Container) (*Export, error) {
+
+	export := &Export{}
+	seen := map[string]string{}
+	for _, c := range containers {
+		name := c.ServiceName()
+		if !dnsLabel.MatchString(name) {
+			return nil, fmt.Errorf("service %s: not a valid Kubernetes name", name)
+		}
+		objects, err := this.objects(c, export)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		for _, obj := range objects {
+			key := obj.Kind + "/" + obj.Metadata.Name
+			if other, ok := seen[key]; ok {
+				return nil, fmt.Errorf("service %s: %s is also exported for service %s", name, key, other)
+			}
+			seen[key] = name
+		}
+		export.Objects = append(export.Objects, objects...)
+	}
+	return export, nil
+}
+
+// objects returns the objects of a container, adding what cannot be exported to the warnings
+func (this Exporter) objects(c *docker.Container, export *Export) ([]Object, error) {
+
+	name := c.ServiceName()
+	labels := map[string]string{
+		"app.kubernetes.io/name":    name,
+		"app.kubernetes.io/part-of": docker.ProjectName,
+	}
+	warn := func(format string, args ...any) {
+		export.Warnings = append(export.Warnings, fmt.Sprintf("service %s "+format, append([]any{name}, args...)...))
+	}
+
+	var objects []Object
+	ctr := Container{
+		Name:    name,
+		Image:   images.Image{Image: c.Image, Digest: c.Digest}.String(),
+		Command: escapeAll(c.Entrypoint),
+		Args:    escapeAll(c.Command),
+	}
+	pod := PodSpec{}
+
+	// environment
+	env, secretEnv := envVars(name, c.Env)
+	ctr.Env = env
+	if len(secretEnv) > 0 {
+		objects = append(objects, Object{
+			APIVersion: "v1",
+			Kind:       "Secret",
+			Metadata:   Metadata{Name: name + "-env", Labels: labels},
+			Type:       "Opaque",
+			StringData: secretEnv,
+		})
+	}
+
+	// volumes
+	claims := map[string]bool{}
+	for _, m := range c.Mounts {
+		if m.Type != mount.TypeBind && m.Type != mount.TypeVolume {
+			return nil, fmt.Errorf("mount of %s has unsupported type %s", m.Target, m.Type)
+		}
+		claim := name + "-" + strings.Trim(dnsUnsafe.ReplaceAllString(strings.ToLower(path.Base(m.Target)), "-"), "-")
+		if claims[claim] {
+			return nil, fmt.Errorf("mount of %s has the same claim name as another mount: %s", m.Target, claim)
+		}
+		claims[claim] = true
+		objects = append(objects, Object{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+			Metadata:   Metadata{Name: claim, Labels: labels},
+			Spec: ClaimSpec{
+				AccessModes: []string{"ReadWriteOnce"},
+				Resources:   Resources{Requests: map[string]string{"storage": this.storageSize()}},
+			},
+		})
+		pod.Volumes = append(pod.Volumes, Volume{Name: claim, PersistentVolumeClaim: &ClaimRef{ClaimName: claim}})
+		ctr.VolumeMounts = append(ctr.VolumeMounts, VolumeMount{Name: claim, MountPath: m.Target, ReadOnly: m.ReadOnly})
+	}
+
+	// embedded files
+	files := Object{APIVersion: "v1", Kind: "ConfigMap", Metadata: Metadata{Name: name + "-files", Labels: labels}}
+	secretFiles := Object{APIVersion: "v1", Kind: "Secret", Metadata: Metadata{Name: name + "-secret-files", Labels: labels}, Type: "Opaque"}
+	embeds, err := c.EmbeddedFiles()
+	if err != nil {
+		return nil, err
+	}
+	var fileItems, secretFileItems []KeyToPath
+	keys := map[string]bool{}
+	for _, file := range embeds {
+		if file.Mode.IsDir() {
+			continue // created with the files they contain
+		}
+		key := fileKey(file.Path)
+		if keys[key] {
+			return nil, fmt.Errorf("embedded file %s has the same key as another file: %s", file.Path, key)
+		}
+		keys[key] = true
+		mode := file.Mode.Perm()
+		if file.User != "" || file.Uid != 0 || file.Gid != 0 {
+			// the files of a volume are owned by root (or the pod's fsGroup)
+			mode |= 0o444
+			warn("has the embedded file %s owned by %s, which cannot be exported; it is readable by every user instead (mode %#o)", file.Path, owner(file), mode)
+		}
+		volume := "files"
+		item := KeyToPath{Key: key, Path: key, Mode: int(mode)}
+		if file.Sensitive {
+			volume = "secret-files"
+			secretFileItems = append(secretFileItems, item)
+		} else {
+			fileItems = append(fileItems, item)
+		}
+		switch text := utf8.Valid(file.Data); {
+		case file.Sensitive && text:
+			setKey(&secretFiles.StringData, key, string(file.Data))
+		case file.Sensitive:
+			setKey(&secretFiles.Data, key, base64.StdEncoding.EncodeToString(file.Data))
+		case text:
+			setKey(&files.Data, key, string(file.Data))
+		default:
+			setKey(&files.BinaryData, key, base64.StdEncoding.EncodeToString(file.Data))
+		}
+		ctr.VolumeMounts = append(ctr.VolumeMounts, VolumeMount{Name: volume, MountPath: file.Path, SubPath: key, ReadOnly: true})
+	}
+	if files.Data != nil || files.BinaryData != nil {
+		objects = append(objects, files)
+		pod.Volumes = append(pod.Volumes, Volume{Name: "files", ConfigMap: &ConfigMapRef{Name: files.Metadata.Name, Items: fileItems}})
+	}
+	if secretFiles.StringData != nil || secretFiles.Data != nil {
+		objects = append(objects, secretFiles)
+		pod.Volumes = append(pod.Volumes, Volume{Name: "secret-files", Secret: &SecretRef{SecretName: secretFiles.Metadata.Name, Items: secretFileItems}})
+	}
+
+	// services, by the service's name and, as other services are configured to reach it, its container's name
+	ports := slices.Clone(c.Expose)
+	for _, p := range c.Ports {
+		ports = append(ports, p.ContainerPort)
+		warn("publishes port %d on %s; it is only exported as a cluster service, so expose it with an ingress or `kubectl port-forward`", p.ContainerPort, net.JoinHostPort(p.Server.Host, strconv.Itoa(int(p.Server.Port))))
+	}
+	slices.Sort(ports)
+	ports = slices.Compact(ports)
+	if len(ports) > 0 {
+		spec := ServiceSpec{Selector: labels}
+		for _, p := range ports {
+			ctr.Ports = append(ctr.Ports, ContainerPort{ContainerPort: p})
+			spec.Ports = append(spec.Ports, ServicePort{Name: fmt.Sprintf("tcp-%d", p), Port: p, TargetPort: p})
+		}
+		objects = append(objects, Object{APIVersion: "v1", Kind: "Service", Metadata: Metadata{Name: name, Labels: labels}, Spec: spec})
+		switch {
+		case c.Name == name:
+		case dnsLabel.MatchString(c.Name):
+			objects = append(objects, Object{APIVersion: "v1", Kind: "Service", Metadata: Metadata{Name: c.Name, Labels: labels}, Spec: spec})
+		default:
+			warn("cannot be reached by its container name %s, which is not a valid Kubernetes service name", c.Name)
+		}
+	}
+
+	// readiness
+	ctr.ReadinessProbe = readinessProbe(c)
+	switch {
+	case c.Readiness == nil || c.Readiness.Probe == nil:
+	case c.HealthCheck != nil:
+		warn("has a readiness probe as well as a health check; only the health check is exported")
+	case ctr.ReadinessProbe == nil:
+		warn("has a readiness probe that cannot be exported: only commands run in the container can")
+	}
+
+	pod.Containers = []Container{ctr}
+	workload := Object{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Metadata:   Metadata{Name: name, Labels: labels},
+	}
+	spec := WorkloadSpec{
+		Replicas: 1,
+		Selector: Selector{MatchLabels: labels},
+		Template: PodTemplate{Metadata: Metadata{Labels: labels}, Spec: pod},
+	}
+	if len(c.Mounts) > 0 {
+		workload.Kind = "StatefulSet"
+		spec.ServiceName = name
+	}
+	workload.Spec = spec
+	objects = append(objects, workload)
+
+	// after-start hook
+	switch {
+	case c.AfterStart == nil:
+	case c.AfterStartJob == nil:
+		warn("has an after-start hook that cannot be exported")
+	default:
+		objects = append(objects, Object{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+			Metadata:   Metadata{Name: name + "-after-start", Labels: labels},
+			Spec: JobSpec{
+				BackoffLimit: 10,
+				Template: PodTemplate{
+					// not selected by the service's selector
+					Metadata: Metadata{Labels: map[string]string{"app.kubernetes.io/part-of": docker.ProjectName}},
+					Spec: PodSpec{
+						RestartPolicy: "OnFailure",
+						Containers: []Container{{
+							Name:    "after-start",
+							Image:   ctr.Image,
+							Command: escapeAll(c.AfterStartJob),
+							Env:     ctr.Env,
+						}},
+					},
+				},
+			},
+		})
+	}
+
+	return objects, nil
+}
+
+func (this Exporter) storageSize() string {
+	if this.StorageSize == "" {
+		return DefaultStorageSize
+	}
+	return this.StorageSize
+}
+
+var (
+	dnsLabel  = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	dnsUnsafe = regexp.MustCompile(`[^a-z0-9-]+`)
+	keyUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+	// sensitiveEnv matches the names of environment variables that are kept in a Secret
+	sensitiveEnv = regexp.MustCompile(`PASS|SECRET|KEY|TOKEN`)
+)
+
+// envVars returns the environment of a container, taking sensitive variables (by their name, or URLs with a
+// password) from the service's Secret, and the data of that Secret
+func envVars(name string, env []string) ([]EnvVar, map[string]string) {
+	var vars []EnvVar
+	var secret map[string]string
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		if sensitiveEnv.MatchString(strings.ToUpper(k)) || hasPassword(v) {
+			if secret == nil {
+				secret = map[string]string{}
+			}
+			secret[k] = v
+			vars = append(vars, EnvVar{Name: k, ValueFrom: &EnvVarSource{SecretKeyRef: KeySelector{Name: name + "-env", Key: k}}})
+			continue
+		}
+		v = escape(v)
+		vars = append(vars, EnvVar{Name: k, Value: &v})
+	}
+	return vars, secret
+}
+
+// hasPassword reports whether a value is a URL with a password
+func hasPassword(v string) bool {
+	u, err := url.Parse(v)
+	if err != nil || u.User == nil {
+		return false
+	}
+	_, ok := u.User.Password()
+	return ok
+}
+
+// readinessProbe returns the readiness probe of a container: its health check, or else its readiness probe if it
+// runs a command in the container; nil if it has neither
+func readinessProbe(c *docker.Container) *Probe {
+	if hc := c.HealthCheck; hc != nil {
+		var command []string
+		switch {
+		case len(hc.Test) == 0 || hc.Test[0] == "NONE":
+			return nil
+		case hc.Test[0] == "CMD":
+			command = hc.Test[1:]
+		case hc.Test[0] == "CMD-SHELL":
+			command = []string{"sh", "-c", strings.Join(hc.Test[1:], " ")}
+		default:
+			command = []string{"sh", "-c", strings.Join(hc.Test, " ")}
+		}
+		return &Probe{
+			Exec:                ExecAction{Command: escapeAll(command)},
+			InitialDelaySeconds: seconds(hc.StartPeriod),
+			PeriodSeconds:       seconds(hc.Interval),
+			TimeoutSeconds:      seconds(hc.Timeout),
+			FailureThreshold:    hc.Retries,
+		}
+	}
+	if c.Readiness != nil {
+		if probe, ok := c.Readiness.Probe.(docker.ExecProbe); ok {
+			return &Probe{Exec: ExecAction{Command: escapeAll(probe.Command)}}
+		}
+	}
+	return nil
+}
+
+// owner returns the owner of an embedded file, for messages
+func owner(file *docker.EmbeddedFile) string {
+	if file.User != "" {
+		return file.User
+	}
+	return fmt.Sprintf("%d:%d", file.Uid, file.Gid)
+}
+
+// fileKey returns the key of an embedded file in its ConfigMap or Secret
+func fileKey(file string) string {
+	return strings.Trim(keyUnsafe.ReplaceAllString(file, "-"), "-.")
+}
+
+func setKey(m *map[string]string, key string, value string) {
+	if *m == nil {
+		*m = map[string]string{}
+	}
+	(*m)[key] = value
+}
+
+// escape escapes a value so that Kubernetes does not expand variable references, i.e. $(NAME), in it
+func escape(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+func escapeAll(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	escaped := make([]string, len(values))
+	for i, v := range values {
+		escaped[i] = escape(v)
+	}
+	return escaped
+}
+
+// seconds returns a duration in whole seconds (rounded up), or 0 if it is unset
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,81 @@
+package kube
+
+import (
+	"flag"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/dockertest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestExportGolden(t *testing.T) {
+	export, err := Exporter{}.Export(dockertest.Containers())
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	actual, err := export.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "stack.yaml")
+	if *update {
+		if err := os.WriteFile(golden, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("could not read golden file (run the test with -update to create it): %v", err)
+	}
+	if string(actual) != string(expected) {
+		t.Errorf("the manifests differ from %s (run the test with -update if the change is intended):\n%s", golden, actual)
+	}
+
+	expectedWarnings := []string{
+		"service db has the embedded file /etc/postgresql-custom/pgsodium_root.key owned by postgres, which cannot be exported; it is readable by every user instead (mode 0644)",
+		"service db publishes port 5432 on 127.0.0.1:54322; it is only exported as a cluster service, so expose it with an ingress or `kubectl port-forward`",
+		"service kong publishes port 8000 on [::1]:8000; it is only exported as a cluster service, so expose it with an ingress or `kubectl port-forward`",
+	}
+	if !slices.Equal(export.Warnings, expectedWarnings) {
+		t.Errorf("expected warnings %q, got %q", expectedWarnings, export.Warnings)
+	}
+}
+
+func TestExportWarnsAboutWhatCannotBeExported(t *testing.T) {
+	containers := dockertest.Containers()
+	containers[0].AfterStartJob = nil
+	containers[1].Name = "realtime-dev.supabase-realtime"
+	containers[2].Readiness.Probe = docker.HTTPProbe{URL: "http://127.0.0.1:8000/status"}
+
+	export, err := Exporter{}.Export(containers)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	for _, expected := range []string{
+		"service db has an after-start hook that cannot be exported",
+		"service rest cannot be reached by its container name realtime-dev.supabase-realtime, which is not a valid Kubernetes service name",
+		"service kong has a readiness probe that cannot be exported: only commands run in the container can",
+	} {
+		if !slices.Contains(export.Warnings, expected) {
+			t.Errorf("expected warning %q, got %q", expected, export.Warnings)
+		}
+	}
+	for _, obj := range export.Objects {
+		if obj.Kind == "Job" {
+			t.Errorf("expected no job without an after-start job command, got %s", obj.Metadata.Name)
+		}
+	}
+}
+
+func TestExportRejectsDuplicateServices(t *testing.T) {
+	containers := dockertest.Containers()
+	containers[1].Aliases = []string{"db"}
+	if _, err := (Exporter{}).Export(containers); err == nil {
+		t.Errorf("expected services with the same name to be rejected")
+	}
+}
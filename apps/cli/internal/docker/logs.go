@@ -0,0 +1,159 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/client"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logReorderWindow is how long a log line is held back, so that lines of other containers logged before it
+// (but read after it) are written first
+const logReorderWindow = 200 * time.Millisecond
+
+// LogLine is a line a container logged
+type LogLine struct {
+	Container *Container
+	Stderr    bool
+	Time      time.Time
+	Text      string
+}
+
+// LogOptions selects the log lines of each container
+type LogOptions struct {
+	Follow bool      // keep streaming new lines until the context is done or the container stops
+	Since  time.Time // if set, only lines logged since
+	Tail   int       // if positive, only the last lines (before following)
+}
+
+// Logs streams the logs of containers, interleaved by time, to a func; stdout and stderr are demultiplexed.
+// Containers that do not exist are reported in the error, after the logs of the others.
+func (this *Docker) Logs(ctx context.Context, containers []*Container, options LogOptions, handle func(LogLine)) error {
+
+	opts := client.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+		Follow:     options.Follow,
+	}
+	if !options.Since.IsZero() {
+		opts.Since = options.Since.Format(time.RFC3339Nano)
+	}
+	if options.Tail > 0 {
+		opts.Tail = strconv.Itoa(options.Tail)
+	}
+
+	type received struct {
+		line LogLine
+		at   time.Time
+	}
+	var (
+		lines = make(chan received)
+		errs  = make([]error, len(containers))
+		wg    sync.WaitGroup
+	)
+	for i, c := range containers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream, err := this.api.ContainerLogs(ctx, c.Name, opts)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", c.ServiceName(), err)
+				return
+			}
+			defer stream.Close()
+			send := func(line LogLine) {
+				select {
+				case lines <- received{line: line, at: time.Now()}:
+				case <-ctx.Done():
+				}
+			}
+			stdout := &logLineWriter{container: c, handle: send}
+			stderr := &logLineWriter{container: c, stderr: true, handle: send}
+			if _, err := stdcopy.StdCopy(stdout, stderr, stream); err != nil && ctx.Err() == nil {
+				errs[i] = fmt.Errorf("%s: could not read logs: %w", c.ServiceName(), err)
+			}
+			stdout.flush()
+			stderr.flush()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+
+	// lines are held back for the reorder window, then written in the order they were logged
+	var pending []received
+	flush := func(until time.Time) {
+		slices.SortStableFunc(pending, func(a, b received) int { return a.line.Time.Compare(b.line.Time) })
+		n := 0
+		for _, r := range pending {
+			if r.at.After(until) {
+				pending[n] = r
+				n++
+				continue
+			}
+			handle(r.line)
+		}
+		pending = pending[:n]
+	}
+	ticker := time.NewTicker(logReorderWindow / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case r, ok := <-lines:
+			if !ok {
+				flush(time.Now())
+				return errors.Join(errs...)
+			}
+			pending = append(pending, r)
+		case <-ticker.C:
+			flush(time.Now().Add(-logReorderWindow))
+		}
+	}
+}
+
+// logLineWriter splits a container's demultiplexed stdout or stderr into lines, parsing their timestamps
+type logLineWriter struct {
+	container *Container
+	stderr    bool
+	handle    func(LogLine)
+	partial   []byte
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.line(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+}
+
+// flush handles the last line, if it did not end with a newline
+func (w *logLineWriter) flush() {
+	if len(w.partial) > 0 {
+		w.line(string(w.partial))
+		w.partial = nil
+	}
+}
+
+func (w *logLineWriter) line(s string) {
+	line := LogLine{Container: w.container, Stderr: w.stderr, Text: strings.TrimSuffix(s, "\r")}
+	if ts, text, ok := strings.Cut(line.Text, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			line.Time, line.Text = t, text
+		}
+	}
+	w.handle(line)
+}
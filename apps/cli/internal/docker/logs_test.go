@@ -0,0 +1,133 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/fake"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collectLogs returns the lines of Logs, as "service: text" (or "service! text" for stderr)
+func collectLogs(ctx context.Context, dkr *Docker, containers []*Container, options LogOptions) ([]string, error) {
+	var (
+		mu    sync.Mutex
+		lines []string
+	)
+	err := dkr.Logs(ctx, containers, options, func(line LogLine) {
+		mu.Lock()
+		defer mu.Unlock()
+		separator := ":"
+		if line.Stderr {
+			separator = "!"
+		}
+		lines = append(lines, fmt.Sprintf("%s%s %s", line.Container.ServiceName(), separator, line.Text))
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	return lines, err
+}
+
+func TestLogsInterleavesContainersByTime(t *testing.T) {
+	dkr, engine := newTestDocker()
+	db, auth := testContainer(engine, "db"), testContainer(engine, "auth", "db")
+	if err := run(t, dkr, db, auth); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	start := time.Now().Add(-time.Minute)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	engine.Log("db", fake.LogEntry{Time: at(0), Text: "starting"}, fake.LogEntry{Time: at(2), Text: "ready", Stderr: true})
+	engine.Log("auth", fake.LogEntry{Time: at(1), Text: "waiting for db"}, fake.LogEntry{Time: at(3), Text: "listening"})
+
+	lines, err := collectLogs(context.Background(), dkr, []*Container{db, auth}, LogOptions{})
+	if err != nil {
+		t.Fatalf("logs failed: %v", err)
+	}
+	expected := []string{"db: starting", "auth: waiting for db", "db! ready", "auth: listening"}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+
+	lines, err = collectLogs(context.Background(), dkr, []*Container{db, auth}, LogOptions{Since: at(1), Tail: 1})
+	if err != nil {
+		t.Fatalf("logs failed: %v", err)
+	}
+	if strings.Join(lines, "\n") != "db! ready\nauth: listening" {
+		t.Errorf("expected the last line of each since 1s, got %q", lines)
+	}
+}
+
+func TestLogsFollowsUntilCancelled(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "realtime")
+	if err := run(t, dkr, c); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	engine.Log("realtime", fake.LogEntry{Time: time.Now(), Text: "before"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		lines []string
+		err   error
+		done  = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		lines, err = collectLogs(ctx, dkr, []*Container{c}, LogOptions{Follow: true})
+	}()
+
+	eventually(t, "the logs are followed", func() bool {
+		return strings.Contains(strings.Join(engine.Calls(), ","), "ContainerLogs realtime")
+	})
+	time.Sleep(10 * time.Millisecond)
+	engine.Log("realtime", fake.LogEntry{Time: time.Now(), Text: "after"})
+	time.Sleep(2 * logReorderWindow)
+	cancel()
+	<-done
+
+	if err != nil {
+		t.Fatalf("logs failed: %v", err)
+	}
+	if strings.Join(lines, "\n") != "realtime: before\nrealtime: after" {
+		t.Errorf("expected the lines before and after following, got %q", lines)
+	}
+}
+
+func TestLogsReportsMissingContainers(t *testing.T) {
+	dkr, engine := newTestDocker()
+	db := testContainer(engine, "db")
+	if err := run(t, dkr, db); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	engine.Log("db", fake.LogEntry{Time: time.Now(), Text: "ready"})
+
+	lines, err := collectLogs(context.Background(), dkr, []*Container{db, {Name: "storage"}}, LogOptions{})
+	if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "storage") {
+		t.Errorf("expected storage not to be found, got %v", err)
+	}
+	if strings.Join(lines, "\n") != "db: ready" {
+		t.Errorf("expected the logs of db, got %q", lines)
+	}
+}
+
+func TestFindContainersByAlias(t *testing.T) {
+	auth := &Container{Name: "projdocs-supabase-auth", Aliases: []string{"auth", "gotrue"}}
+	db := &Container{Name: "projdocs-supabase-db", Aliases: []string{"db"}}
+	containers := []*Container{db, auth}
+
+	found, err := FindContainers(containers, "gotrue", "projdocs-supabase-db", "auth")
+	if err != nil || len(found) != 2 || found[0] != auth || found[1] != db {
+		t.Fatalf("expected auth and db, got %v (%v)", found, err)
+	}
+	if found, _ := FindContainers(containers); len(found) != 2 {
+		t.Errorf("expected every container without names, got %v", found)
+	}
+	if _, err := FindContainers(containers, "kong"); err == nil || !strings.Contains(err.Error(), "services: db, auth") {
+		t.Errorf("expected an error listing the services, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/moby/moby/client"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/images"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PullEvent is a step of an image pull, as reported by the daemon: a layer's status or progress changed,
+// or (without a layer) the pull of the image started, finished or failed
+type PullEvent struct {
+	Image   string `json:"image"`
+	Layer   string `json:"layer,omitempty"`
+	Status  string `json:"status"`
+	Current int64  `json:"current,omitempty"` // bytes of the layer downloaded or extracted so far
+	Total   int64  `json:"total,omitempty"`   // size of the layer in bytes, if known
+	Done    bool   `json:"done,omitempty"`    // the image is pulled (or the pull failed, see Error)
+	Error   string `json:"error,omitempty"`
+}
+
+// PullReporter receives the events of image pulls; it is called concurrently when pulling several images
+type PullReporter func(event PullEvent)
+
+// pullLogInterval is how often the console logger reports the progress of a pull
+const pullLogInterval = 2 * time.Second
+
+// pullLayer is a layer's progress, as tracked by LogPullProgress
+type pullLayer struct {
+	current int64
+	total   int64
+	done    bool
+}
+
+// LogPullProgress returns a reporter that summarizes the progress of each pull in the console logger
+func LogPullProgress() PullReporter {
+
+	type pull struct {
+		layers  map[string]*pullLayer
+		order   []string
+		logged  time.Time
+		started time.Time
+	}
+
+	var (
+		mu    sync.Mutex
+		pulls = make(map[string]*pull)
+	)
+
+	return func(event PullEvent) {
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		p, ok := pulls[event.Image]
+		if !ok {
+			p = &pull{layers: make(map[string]*pullLayer), logged: time.Now(), started: time.Now()}
+			pulls[event.Image] = p
+			logger.Global().Infof("pulling docker image '%s'", event.Image)
+		}
+
+		switch {
+		case event.Error != "":
+			delete(pulls, event.Image)
+			return
+		case event.Done:
+			delete(pulls, event.Image)
+			logger.Global().Infof("pulled docker image '%s' in %s", event.Image, time.Since(p.started).Round(time.Second))
+			return
+		case event.Layer == "":
+			logger.Global().Debugf("%s: %s", event.Image, event.Status)
+			return
+		}
+
+		layer, ok := p.layers[event.Layer]
+		if !ok {
+			layer = &pullLayer{}
+			p.layers[event.Layer] = layer
+			p.order = append(p.order, event.Layer)
+		}
+		switch event.Status {
+		case "Downloading":
+			layer.current, layer.total = event.Current, event.Total
+		case "Download complete", "Verifying Checksum":
+			layer.current = layer.total
+		case "Pull complete", "Already exists":
+			layer.current, layer.done = layer.total, true
+			logger.Global().Debugf("%s: layer %s: %s", event.Image, event.Layer, event.Status)
+		}
+
+		if time.Since(p.logged) < pullLogInterval {
+			return
+		}
+		p.logged = time.Now()
+
+		var done int
+		var current, total int64
+		for _, id := range p.order {
+			if p.layers[id].done {
+				done++
+			}
+			current += p.layers[id].current
+			total += p.layers[id].total
+		}
+		logger.Global().Infof("pulling docker image '%s': %d/%d layers, %s of %s", event.Image, done, len(p.order), formatBytes(current), formatBytes(total))
+	}
+}
+
+// JSONPullProgress returns a reporter that writes every event as a line of JSON, for machines
+func JSONPullProgress(w io.Writer) PullReporter {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	return func(event PullEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := encoder.Encode(event); err != nil {
+			logger.Global().Debugf("could not write pull progress: %v", err)
+		}
+	}
+}
+
+// formatBytes formats a size in bytes with a binary unit, e.g. "12.3 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// SetPullReporter sets where the progress of image pulls is reported; by default, it is logged (see LogPullProgress)
+func (this *Docker) SetPullReporter(report PullReporter) {
+	this.pullReporter = report
+}
+
+// Pull pulls an image from its registry, reporting its progress
+func (this *Docker) Pull(ctx context.Context, ref string) error {
+
+	report := this.pullReporter
+	if report == nil {
+		report = func(PullEvent) {}
+	}
+	fail := func(err error) error {
+		report(PullEvent{Image: ref, Status: "Failed", Done: true, Error: err.Error()})
+		return fmt.Errorf("unable to pull image %s: %w", ref, err)
+	}
+
+	rc, err := this.api.ImagePull(ctx, ref, client.ImagePullOptions{})
+	if err != nil {
+		return fail(err)
+	}
+	defer rc.Close()
+
+	for msg, err := range rc.JSONMessages(ctx) {
+		if err != nil {
+			return fail(err)
+		}
+		if msg.Error != nil {
+			return fail(msg.Error)
+		}
+		event := PullEvent{Image: ref, Layer: msg.ID, Status: msg.Status}
+		if strings.HasPrefix(msg.Status, "Pulling from ") {
+			event.Layer = "" // the ID is the tag
+		}
+		if msg.Progress != nil {
+			event.Current, event.Total = msg.Progress.Current, msg.Progress.Total
+		}
+		report(event)
+	}
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+
+	report(PullEvent{Image: ref, Status: "Pulled", Done: true})
+	return nil
+}
+
+// ensureImage pulls a container's image if it is not available locally, and verifies its pinned digest
+func (this *Docker) ensureImage(ctx context.Context, c *Container) error {
+
+	if inspect, err := this.api.ImageInspect(ctx, c.Image); err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("unable to inspect image: %w", err)
+		}
+		logger.Global().Debugf("docker image '%s' was not found locally, and will be pulled instead", c.Image)
+		// a pinned image is pulled by digest, so the registry cannot serve another image for the tag, and is
+		// then tagged so the container can be created from its tag
+		ref := images.Image{Image: c.Image, Digest: c.Digest}.String()
+		if err := this.Pull(ctx, ref); err != nil {
+			return err
+		}
+		if c.Digest != "" {
+			if _, err := this.api.ImageTag(ctx, client.ImageTagOptions{Source: ref, Target: c.Image}); err != nil {
+				return fmt.Errorf("unable to tag image %s as %s: %w", ref, c.Image, err)
+			}
+		}
+	} else {
+		logger.Global().Debugf("found image %s: %s", c.Image, inspect.ID)
+	}
+	return this.verifyDigest(ctx, c)
+}
+
+// EnsureImages pulls the images of the containers that are not available locally, in parallel, and verifies
+// their pinned digests
+func (this *Docker) EnsureImages(ctx context.Context, containers []*Container) error {
+
+	// an image is pulled once, even if several containers use it
+	byImage := make(map[string][]*Container)
+	var refs []string
+	for _, c := range containers {
+		if _, ok := byImage[c.Image]; !ok {
+			refs = append(refs, c.Image)
+		}
+		byImage[c.Image] = append(byImage[c.Image], c)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(refs))
+	for i, ref := range refs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, c := range byImage[ref] {
+				if err := this.ensureImage(ctx, c); err != nil {
+					errs[i] = fmt.Errorf("%s: %w", c.Name, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
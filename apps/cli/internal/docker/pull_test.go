@@ -0,0 +1,145 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/fake"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPullReportsLayerProgress(t *testing.T) {
+	dkr, engine := newTestDocker()
+	engine.AddRemoteImage("example.com/a:1")
+
+	var (
+		mu     sync.Mutex
+		events []PullEvent
+	)
+	dkr.SetPullReporter(func(event PullEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	})
+
+	if err := dkr.Pull(context.Background(), "example.com/a:1"); err != nil {
+		t.Fatalf("pull failed: %v", err)
+	}
+
+	var progress *PullEvent
+	for i, event := range events {
+		if event.Image != "example.com/a:1" {
+			t.Errorf("event for the wrong image: %+v", event)
+		}
+		if event.Status == "Downloading" {
+			progress = &events[i]
+		}
+	}
+	if progress == nil || progress.Layer != "layer0" || progress.Current != 512 || progress.Total != 1024 {
+		t.Errorf("expected the download progress of layer0, got %+v", events)
+	}
+	if events[0].Layer != "" {
+		t.Errorf("the tag was reported as a layer: %+v", events[0])
+	}
+	if last := events[len(events)-1]; !last.Done || last.Error != "" {
+		t.Errorf("the last event does not report the pull as done: %+v", last)
+	}
+}
+
+func TestPullReportsFailure(t *testing.T) {
+	dkr, _ := newTestDocker()
+
+	var buf bytes.Buffer
+	dkr.SetPullReporter(JSONPullProgress(&buf))
+
+	if err := dkr.Pull(context.Background(), "example.com/missing:1"); err == nil {
+		t.Fatal("expected pull error")
+	}
+	var event PullEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("invalid JSON progress %q: %v", buf.String(), err)
+	}
+	if !event.Done || !strings.Contains(event.Error, "pull access denied") {
+		t.Errorf("expected a failed event, got %+v", event)
+	}
+}
+
+func TestEnsureImagesPullsEachMissingImageOnce(t *testing.T) {
+	dkr, engine := newTestDocker()
+	dkr.SetPullReporter(nil)
+	engine.AddRemoteImage("example.com/a:1")
+	engine.AddRemoteImage("example.com/b:1")
+	engine.AddImage("example.com/c:1")
+
+	err := dkr.EnsureImages(context.Background(), []*Container{
+		{Name: "a", Image: "example.com/a:1"},
+		{Name: "a2", Image: "example.com/a:1"},
+		{Name: "b", Image: "example.com/b:1"},
+		{Name: "c", Image: "example.com/c:1"},
+	})
+	if err != nil {
+		t.Fatalf("ensure images failed: %v", err)
+	}
+
+	pulls := make(map[string]int)
+	for _, call := range engine.Calls() {
+		if ref, ok := strings.CutPrefix(call, "ImagePull "); ok {
+			pulls[ref]++
+		}
+	}
+	if pulls["example.com/a:1"] != 1 || pulls["example.com/b:1"] != 1 || pulls["example.com/c:1"] != 0 {
+		t.Errorf("expected a and b to be pulled once, got %v", pulls)
+	}
+}
+
+func TestEnsureImagesReportsEveryFailure(t *testing.T) {
+	dkr, engine := newTestDocker()
+	dkr.SetPullReporter(nil)
+	engine.AddRemoteImage("example.com/a:1")
+
+	err := dkr.EnsureImages(context.Background(), []*Container{
+		{Name: "a", Image: "example.com/a:1"},
+		{Name: "b", Image: "example.com/b:1"},
+		{Name: "c", Image: "example.com/c:1"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "b: unable to pull") || !strings.Contains(err.Error(), "c: unable to pull") {
+		t.Fatalf("expected errors for b and c, got %v", err)
+	}
+	if !engine.HasImage("example.com/a:1") {
+		t.Errorf("a was not pulled")
+	}
+}
+
+func TestEnsureImagesPullsPinnedImagesByDigest(t *testing.T) {
+	dkr, engine := newTestDocker()
+	dkr.SetPullReporter(nil)
+	// the tag is not pullable: only the pinned image is
+	engine.AddRemoteImage("example.com/a:1@"+fake.Digest, "example.com/a@"+fake.Digest)
+
+	if err := dkr.EnsureImages(context.Background(), []*Container{{Name: "a", Image: "example.com/a:1", Digest: fake.Digest}}); err != nil {
+		t.Fatalf("ensure images failed: %v", err)
+	}
+	calls := engine.Calls()
+	if !slices.Contains(calls, "ImagePull example.com/a:1@"+fake.Digest) || !slices.Contains(calls, "ImageTag example.com/a:1") {
+		t.Errorf("expected the image to be pulled by digest and tagged, got %v", calls)
+	}
+	if !engine.HasImage("example.com/a:1") {
+		t.Errorf("the pulled image was not tagged")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:                 "0 B",
+		1023:              "1023 B",
+		1536:              "1.5 KiB",
+		120 * 1024 * 1024: "120.0 MiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
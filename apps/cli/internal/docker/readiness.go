@@ -0,0 +1,213 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultPollInterval is how often a starting container's readiness is checked, unless configured
+	defaultPollInterval = 250 * time.Millisecond
+	// defaultMaxPollInterval bounds the poll interval when it backs off, unless configured
+	defaultMaxPollInterval = 5 * time.Second
+	// defaultProbeMaxWait is how long a container without a health check may take to pass its probe, unless configured
+	defaultProbeMaxWait = time.Minute
+)
+
+// Readiness is how to wait for a started container to become ready: its health check (if any) must report
+// healthy and its probe (if any) must pass
+type Readiness struct {
+	MaxWait      time.Duration // how long to wait; defaults to what the health check may take, or a minute
+	PollInterval time.Duration // how long to wait between checks at first; defaults to 250ms
+	Backoff      float64       // factor the interval grows by after each check; 1 (the default) keeps it constant
+	MaxInterval  time.Duration // bounds the interval as it grows; defaults to 5s
+	Probe        Probe         // checked from the CLI, e.g. for images without a health check
+}
+
+// Probe checks whether a started container is ready
+type Probe interface {
+	Check(ctx context.Context, docker *Docker, container *Container) error
+}
+
+// HTTPProbe passes when a GET of a URL (usually a published port) returns a 2xx or 3xx status
+type HTTPProbe struct {
+	URL string
+}
+
+func (p HTTPProbe) Check(ctx context.Context, _ *Docker, _ *Container) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("GET %s: %s", p.URL, res.Status)
+	}
+	return nil
+}
+
+// TCPProbe passes when a TCP connection to an address (usually a published port) can be opened
+type TCPProbe struct {
+	Address string
+}
+
+func (p TCPProbe) Check(ctx context.Context, _ *Docker, _ *Container) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", p.Address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// ExecProbe passes when a command run in the container exits with 0
+type ExecProbe struct {
+	Command []string
+}
+
+func (p ExecProbe) Check(ctx context.Context, docker *Docker, c *Container) error {
+	output, err := docker.ExecInContainer(ctx, c, p.Command)
+	if err != nil {
+		if output = strings.TrimSpace(output); output != "" {
+			return fmt.Errorf("%w: %s", err, output)
+		}
+		return err
+	}
+	return nil
+}
+
+// readiness returns the container's readiness policy with its defaults
+func (c *Container) readiness() Readiness {
+	var r Readiness
+	if c.Readiness != nil {
+		r = *c.Readiness
+	}
+	if r.MaxWait == 0 {
+		if c.HealthCheck != nil {
+			r.MaxWait = c.healthTimeout()
+		} else {
+			r.MaxWait = defaultProbeMaxWait
+		}
+	}
+	if r.PollInterval == 0 {
+		r.PollInterval = defaultPollInterval
+	}
+	if r.Backoff < 1 {
+		r.Backoff = 1
+	}
+	if r.MaxInterval == 0 {
+		r.MaxInterval = max(defaultMaxPollInterval, r.PollInterval)
+	}
+	return r
+}
+
+// lastHealthLog returns the output of a container's latest health check, if any
+func lastHealthLog(health *container.Health) string {
+	if health == nil || len(health.Log) == 0 {
+		return ""
+	}
+	last := health.Log[len(health.Log)-1]
+	return fmt.Sprintf("exit code %d: %s", last.ExitCode, strings.TrimSpace(last.Output))
+}
+
+// waitReady blocks until a started container is ready (see Readiness), it stops, or the context is done
+func (this *Docker) waitReady(ctx context.Context, c *Container) error {
+
+	if c.HealthCheck == nil && (c.Readiness == nil || c.Readiness.Probe == nil) {
+		return nil
+	}
+
+	policy := c.readiness()
+	ctx, cancel := context.WithTimeout(ctx, policy.MaxWait)
+	defer cancel()
+
+	// last describes why the container was not ready at the last check, for the timeout error
+	last := "it was not checked"
+	timedOut := func() error {
+		return fmt.Errorf("container is not ready after %s (%s)", policy.MaxWait, last)
+	}
+
+	interval := policy.PollInterval
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return timedOut()
+			}
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		ready, err := this.checkReady(ctx, c, policy, &last)
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return timedOut()
+			}
+			return err
+		}
+		if ready {
+			logger.Global().Debugf("container %v is ready", c.Name)
+			return nil
+		}
+
+		timer.Reset(interval)
+		interval = min(time.Duration(float64(interval)*policy.Backoff), policy.MaxInterval)
+	}
+}
+
+// checkReady checks a container's health and probe once; it returns an error if the container cannot become
+// ready, and otherwise records why it is not ready yet in last
+func (this *Docker) checkReady(ctx context.Context, c *Container, policy Readiness, last *string) (bool, error) {
+
+	inspect, err := this.api.ContainerInspect(ctx, c.GetID(), client.ContainerInspectOptions{})
+	if err != nil {
+		return false, fmt.Errorf("could not inspect container: %w", err)
+	}
+	state := inspect.Container.State
+	switch {
+	case state == nil:
+		return false, errors.New("container has no state")
+	case !state.Running:
+		return false, fmt.Errorf("container stopped (status=%s;exit-code=%d)", state.Status, state.ExitCode)
+	}
+
+	if c.HealthCheck != nil {
+		switch {
+		case state.Health == nil:
+			*last = "docker has not reported its health yet"
+			return false, nil
+		case state.Health.Status == container.Unhealthy:
+			if log := lastHealthLog(state.Health); log != "" {
+				return false, fmt.Errorf("container is unhealthy (last health check: %s)", log)
+			}
+			return false, errors.New("container is unhealthy")
+		case state.Health.Status != container.Healthy:
+			*last = "it is " + string(state.Health.Status)
+			if log := lastHealthLog(state.Health); log != "" {
+				*last += "; last health check: " + log
+			}
+			return false, nil
+		}
+	}
+
+	if policy.Probe != nil {
+		if err := policy.Probe.Check(ctx, this, c); err != nil {
+			*last = "probe failed: " + err.Error()
+			return false, nil
+		}
+	}
+	return true, nil
+}
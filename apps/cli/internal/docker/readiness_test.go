@@ -0,0 +1,181 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"github.com/moby/moby/api/types/container"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/fake"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// probeFunc is a probe that calls a func
+type probeFunc func() error
+
+func (p probeFunc) Check(context.Context, *Docker, *Container) error {
+	return p()
+}
+
+func TestWaitReadyReportsLastHealthLogOnTimeout(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "db")
+	c.Readiness = &Readiness{MaxWait: 200 * time.Millisecond, PollInterval: 10 * time.Millisecond}
+	engine.SetHealth("db", container.Starting)
+	engine.SetHealthOutput("db", "pg_isready: no response")
+
+	err := run(t, dkr, c)
+	if err == nil || !strings.Contains(err.Error(), "not ready after 200ms") || !strings.Contains(err.Error(), "pg_isready: no response") {
+		t.Fatalf("expected timeout with the last health check output, got %v", err)
+	}
+}
+
+func TestWaitReadyReportsHealthLogWhenUnhealthy(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "db")
+	engine.SetHealth("db", container.Starting, container.Unhealthy)
+	engine.SetHealthOutput("db", "connection refused")
+
+	err := run(t, dkr, c)
+	if err == nil || !strings.Contains(err.Error(), "unhealthy (last health check: exit code 1: connection refused)") {
+		t.Fatalf("expected unhealthy error with the health check output, got %v", err)
+	}
+}
+
+func TestWaitReadyIsCancellable(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "db")
+	c.Readiness = &Readiness{MaxWait: time.Hour, PollInterval: time.Hour}
+	engine.SetHealth("db", container.Starting)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	started := time.Now()
+	runCtx, cancelRun := dkr.Run(ctx, []*Container{c})
+	defer cancelRun(nil)
+
+	if !errors.Is(context.Cause(runCtx), context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", context.Cause(runCtx))
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("waiting was not cancelled promptly (%s)", elapsed)
+	}
+}
+
+func TestWaitReadyWaitsForProbe(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := &Container{Name: "proxy", Image: "example.com/proxy:1"}
+	engine.AddImage(c.Image)
+
+	var mu sync.Mutex
+	checks := 0
+	c.Readiness = &Readiness{PollInterval: 5 * time.Millisecond, Probe: probeFunc(func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if checks++; checks < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})}
+	dependent := testContainer(engine, "app", "proxy")
+
+	if err := run(t, dkr, c, dependent); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if checks != 3 {
+		t.Errorf("expected 3 probe checks, got %d", checks)
+	}
+}
+
+func TestWaitReadyReportsProbeFailureOnTimeout(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := &Container{Name: "proxy", Image: "example.com/proxy:1"}
+	engine.AddImage(c.Image)
+	c.Readiness = &Readiness{MaxWait: 100 * time.Millisecond, PollInterval: 5 * time.Millisecond, Probe: probeFunc(func() error {
+		return errors.New("connection refused")
+	})}
+
+	err := run(t, dkr, c)
+	if err == nil || !strings.Contains(err.Error(), "probe failed: connection refused") {
+		t.Fatalf("expected timeout with the probe failure, got %v", err)
+	}
+}
+
+func TestWaitReadyBacksOff(t *testing.T) {
+	count := func(backoff float64) int {
+		dkr, engine := newTestDocker()
+		c := &Container{Name: "proxy", Image: "example.com/proxy:1"}
+		engine.AddImage(c.Image)
+		var mu sync.Mutex
+		checks := 0
+		c.Readiness = &Readiness{MaxWait: 300 * time.Millisecond, PollInterval: 10 * time.Millisecond, Backoff: backoff, Probe: probeFunc(func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			checks++
+			return errors.New("not yet")
+		})}
+		_ = run(t, dkr, c)
+		mu.Lock()
+		defer mu.Unlock()
+		return checks
+	}
+
+	constant, backoff := count(1), count(2)
+	// 10ms, 20ms, 40ms, 80ms, 160ms: at most 6 checks in 300ms
+	if backoff > 6 || backoff >= constant {
+		t.Errorf("expected fewer checks with backoff, got %d (constant: %d)", backoff, constant)
+	}
+}
+
+func TestExecProbeReportsOutput(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "kong")
+	if err := run(t, dkr, c); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	engine.SetExecResult("kong", fake.ExecResult{Output: "Kong is not running", ExitCode: 1})
+	err := (ExecProbe{Command: []string{"kong", "health"}}).Check(context.Background(), dkr, c)
+	if err == nil || !strings.Contains(err.Error(), "Kong is not running") {
+		t.Fatalf("expected the probe to fail with its output, got %v", err)
+	}
+
+	engine.SetExecResult("kong", fake.ExecResult{Output: "Kong is healthy"})
+	if err := (ExecProbe{Command: []string{"kong", "health"}}).Check(context.Background(), dkr, c); err != nil {
+		t.Fatalf("expected the probe to pass, got %v", err)
+	}
+}
+
+func TestNetworkProbes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	if err := (HTTPProbe{URL: server.URL + "/ready"}).Check(ctx, nil, nil); err != nil {
+		t.Errorf("http probe failed: %v", err)
+	}
+	if err := (HTTPProbe{URL: server.URL + "/other"}).Check(ctx, nil, nil); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected http probe to fail with 503, got %v", err)
+	}
+
+	if err := (TCPProbe{Address: server.Listener.Addr().String()}).Check(ctx, nil, nil); err != nil {
+		t.Errorf("tcp probe failed: %v", err)
+	}
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := closed.Addr().String()
+	closed.Close()
+	if err := (TCPProbe{Address: address}).Check(ctx, nil, nil); err == nil {
+		t.Errorf("expected tcp probe of a closed port to fail")
+	}
+}
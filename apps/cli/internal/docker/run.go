@@ -5,44 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/moby/moby/client"
-	"github.com/projdocs/projdocs/apps/cli/internal/docker/network"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/images"
 	"github.com/projdocs/projdocs/apps/cli/internal/logger"
-	"github.com/projdocs/projdocs/apps/cli/internal/utils"
-	"io"
-	"math"
-	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
-var (
-	containerNameConflictRE = regexp.MustCompile(`^Error response from daemon: Conflict\. The container name "/[^"]+" is already in use by container "[a-f0-9]{64}"\. You have to remove \(or rename\) that container to be able to reuse that name\.$`)
-	imageNotFoundRe         = regexp.MustCompile(`^Error response from daemon: No such image: `)
-	networkNotFoundRe       = regexp.MustCompile(`^Error response from daemon: \{"message":"network [^"]+ not found"}$`)
-	networkNotFoundRe2      = regexp.MustCompile(`^Error response from daemon: network [^"]+ not found$`)
-)
-
-func isNetworkNotFoundErr(err error) bool {
-	if err == nil {
-		return false
-	}
-	return networkNotFoundRe.MatchString(err.Error()) || networkNotFoundRe2.MatchString(err.Error())
-}
-
-func isNameConflictErr(err error) bool {
-	if err == nil {
-		return false
-	}
-	return containerNameConflictRE.MatchString(err.Error())
-}
-
-func isImageNotFoundErr(err error) bool {
-	if err == nil {
-		return false
-	}
-	return imageNotFoundRe.MatchString(err.Error())
-}
-
 func (this *Docker) createContainer(ctx context.Context, c *Container) error {
 
 	if c.created != nil {
@@ -62,30 +32,26 @@ func (this *Docker) createContainer(ctx context.Context, c *Container) error {
 		}
 
 		// ensure image exists
-		if inspect, err := this.api.ImageInspect(ctx, c.Image); err != nil {
-			if isImageNotFoundErr(err) {
-				logger.Global().Warnf("docker image '%s' was not found locally, and will be pulled instead (this may take a while)", c.Image)
-				if rc, err := this.api.ImagePull(ctx, c.Image, client.ImagePullOptions{}); err != nil {
-					return fmt.Errorf("unable to pull image %s: %w", c.Image, err)
-				} else {
-					defer rc.Close()
-					_, err = io.Copy(io.Discard, rc)
-					if err != nil {
-						return fmt.Errorf("unable to pull image %s: %w", c.Image, err)
-					}
-					logger.Global().Infof("pulled docker image '%s'", c.Image)
-				}
-			} else {
-				return fmt.Errorf("unable to inspect image: %w", err)
-			}
-		} else {
-			logger.Global().Debugf("found image %s: %s", c.Image, inspect.ID)
+		if err := this.ensureImage(ctx, c); err != nil {
+			return err
+		}
+
+		// reuse the existing container if it has not drifted from its definition
+		hash, err := c.ConfigHash()
+		if err != nil {
+			return err
+		}
+		opts.Config.Labels[ConfigHashLabel] = hash
+		if adopted, err := this.adoptContainer(ctx, c, hash); err != nil {
+			return err
+		} else if adopted {
+			return nil
 		}
 
 		// create container
 		ctr, err := this.api.ContainerCreate(ctx, *opts)
 		if err != nil {
-			if isNameConflictErr(err) {
+			if errors.Is(err, ErrConflict) {
 				logger.Global().Debugf("container name conflict (%v); attempting to manually resolve", c.Name)
 				if _, e := this.api.ContainerRemove(ctx, c.Name, client.ContainerRemoveOptions{
 					RemoveVolumes: true,
@@ -110,6 +76,31 @@ func (this *Docker) createContainer(ctx context.Context, c *Container) error {
 	}
 }
 
+// verifyDigest checks that a pinned container's local image has the pinned digest
+func (this *Docker) verifyDigest(ctx context.Context, c *Container) error {
+
+	if c.Digest == "" {
+		logger.Global().Debugf("image %s is not pinned to a digest; skipping verification", c.Image)
+		return nil
+	}
+
+	inspect, err := this.api.ImageInspect(ctx, c.Image)
+	if err != nil {
+		return fmt.Errorf("unable to inspect image: %w", err)
+	}
+	image := images.Image{Image: c.Image, Digest: c.Digest}
+	if id, ok := this.trustedImages[image.String()]; ok && id == inspect.ID {
+		logger.Global().Debugf("image %s was loaded from a bundle with its pinned digest %s", c.Image, c.Digest)
+		return nil
+	}
+	if !image.MatchesDigest(inspect.RepoDigests) {
+		return fmt.Errorf("image %s does not match its pinned digest %s (local digests: %s); remove the local image so it is pulled again, or override the image with `projdocs config set images.<service>`",
+			c.Image, c.Digest, strings.Join(inspect.RepoDigests, ", "))
+	}
+	logger.Global().Debugf("image %s matches its pinned digest %s", c.Image, c.Digest)
+	return nil
+}
+
 func (this *Docker) startContainer(ctx context.Context, c *Container) error {
 
 	if c.started != nil {
@@ -124,16 +115,12 @@ func (this *Docker) startContainer(ctx context.Context, c *Container) error {
 		logger.Global().Debugf("starting container %s (%s)", c.Name, c.Image)
 
 		// write any embedded files
-		if c.Embeds != nil && len(c.Embeds) > 0 {
+		if len(c.Embeds) > 0 {
 			logger.Global().Debugf("creating %d embedded files for container %s (%v)", len(c.Embeds), c.Name, c.Image)
-			for f, file := range c.Embeds {
-				if _, err := this.copyToContainer(ctx, c, file); err != nil {
-					e := fmt.Sprintf("failed to create file %d in container %s (%v): %v", f, c.Name, c.Image, err)
-					logger.Global().Debugf(e)
-					return errors.New(e)
-				} else {
-					logger.Global().Debugf("created file %d in container %s (%v)", f, c.Name, c.Image)
-				}
+			if err := this.copyToContainer(ctx, c); err != nil {
+				e := fmt.Sprintf("failed to create files in container %s (%v): %v", c.Name, c.Image, err)
+				logger.Global().Debugf(e)
+				return errors.New(e)
 			}
 		}
 
@@ -148,15 +135,28 @@ func (this *Docker) startContainer(ctx context.Context, c *Container) error {
 
 }
 
+// Stop stops and removes the containers Run created, dependents first; containers it adopted are left running
 func (this *Docker) Stop(ctx context.Context, containers []*Container) (errors []error) {
 
 	// obtain lock
 	this.lock.Lock()
 	defer this.lock.Unlock()
 
+	// stop dependents before their dependencies
+	if order, err := dependencyOrder(containers); err == nil {
+		containers = slices.Clone(order)
+		slices.Reverse(containers)
+	}
+
 	logger.Global().Debugf("docker shutting down %d containers", len(containers))
 	for i, c := range containers {
 
+		// leave containers that were running before they were adopted as they were found
+		if c.adopted {
+			logger.Global().Infof("leaving container %s running: it was running before serve", c.Name)
+			continue
+		}
+
 		// only stop if started
 		if c.started == nil {
 			logger.Global().Debugf("skipping stop of container %d (%v): was not started", i, c.Name)
@@ -191,6 +191,72 @@ func (this *Docker) Stop(ctx context.Context, containers []*Container) (errors [
 	return
 }
 
+// Restart re-writes a running container's embedded files, restarts it and waits for it to become ready
+func (this *Docker) Restart(ctx context.Context, container *Container) error {
+
+	// obtain lock
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if err := this.copyToContainer(ctx, container); err != nil {
+		return fmt.Errorf("failed to create files in container %s (%v): %w", container.Name, container.Image, err)
+	}
+
+	logger.Global().Debugf("restarting container %s (%v)", container.Name, container.Image)
+	if _, err := this.api.ContainerRestart(ctx, container.GetID(), client.ContainerRestartOptions{}); err != nil {
+		return fmt.Errorf("could not restart container %s: %w", container.Name, err)
+	}
+
+	return this.waitReady(ctx, container)
+}
+
+// IsRunning reports whether a container with the given container's name is currently running
+func (this *Docker) IsRunning(ctx context.Context, container *Container) (bool, error) {
+	list, err := this.api.ContainerList(ctx, client.ContainerListOptions{
+		Filters: make(client.Filters).Add("name", fmt.Sprintf("^/%s$", container.Name)),
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not list containers: %w", err)
+	}
+	return len(list.Items) > 0, nil
+}
+
+// Recreate replaces a container with a fresh one built from its (possibly updated) definition,
+// then waits for it to become ready and runs its after-start hook
+func (this *Docker) Recreate(ctx context.Context, container *Container) error {
+
+	// obtain lock
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	logger.Global().Debugf("removing container %s (%v) for recreation", container.Name, container.Image)
+	if _, err := this.api.ContainerRemove(ctx, container.Name, client.ContainerRemoveOptions{
+		RemoveVolumes: true,
+		Force:         true,
+	}); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("could not remove container %s: %w", container.Name, err)
+	}
+	container.created = nil
+	container.started = nil
+	container.adopted = false
+
+	if err := this.createContainer(ctx, container); err != nil {
+		return fmt.Errorf("could not create container %s: %w", container.Name, err)
+	}
+	if err := this.startContainer(ctx, container); err != nil {
+		return fmt.Errorf("could not start container %s: %w", container.Name, err)
+	}
+	if err := this.waitReady(ctx, container); err != nil {
+		return fmt.Errorf("container %s: %w", container.Name, err)
+	}
+	if container.AfterStart != nil {
+		if _, err := container.AfterStart(ctx, this, container); err != nil {
+			return fmt.Errorf("after-start hook on container %s: %w", container.Name, err)
+		}
+	}
+	return nil
+}
+
 // Run runs a list of containers using a given context
 func (this *Docker) Run(_ctx context.Context, containers []*Container) (context.Context, context.CancelCauseFunc) {
 
@@ -199,122 +265,98 @@ func (this *Docker) Run(_ctx context.Context, containers []*Container) (context.
 	defer this.lock.Unlock()
 
 	ctx, cancel := context.WithCancelCause(_ctx)
-	var completedWithoutInterruption bool = true
 
 	// handle the network
-	networkInspect, err := this.api.NetworkInspect(ctx, network.Name, client.NetworkInspectOptions{Verbose: true})
+	if err := this.ensureNetwork(ctx); err != nil {
+		logger.Global().Errorf("%v", err)
+		cancel(err)
+		return ctx, cancel
+	}
+
+	order, err := dependencyOrder(containers)
 	if err != nil {
-		if isNetworkNotFoundErr(err) {
-			networkCreate, err := this.api.NetworkCreate(ctx, network.Name, client.NetworkCreateOptions{
-				Driver:     "bridge",
-				Scope:      "local",
-				EnableIPv4: utils.Pointer(true),
-				EnableIPv6: utils.Pointer(true),
-				Internal:   false, // true = no external connectivity (usually keep false)
-				Attachable: true,  // allow standalone containers to attach/detach
-			})
-			if err != nil {
-				logger.Global().Errorf("error creating network: %v", err)
-				cancel(errors.New("network creation failed"))
-				return ctx, cancel
-			} else {
-				logger.Global().Debugf("created network: %s", networkCreate.ID)
-			}
-		} else {
-			logger.Global().Errorf("error inspecting network: %v", err)
-			cancel(errors.New("could not inspect network"))
-			return ctx, cancel
-		}
-	} else {
-		logger.Global().Debugf("found network: %s", networkInspect.Network.ID)
+		logger.Global().Errorf("invalid container dependencies: %v", err)
+		cancel(err)
+		return ctx, cancel
 	}
 
-	// process each container
-	for i, container := range containers {
-		select {
-		case <-ctx.Done():
-			logger.Global().Debugf("docker-run deteched parent context done (cancelling)")
-			completedWithoutInterruption = false
-			cancel(errors.New("parent context done"))
-			break
-		default:
-			logger.Global().Debugf("building docker container %d (%s)", i, container.Name)
-
-			// create the container
-			if err := this.createContainer(ctx, container); err != nil {
-				e := fmt.Errorf("could not create container %d (%v): %v", i, container.Name, err)
-				logger.Global().Errorf("%v", e)
+	// every container is created (pulling its image if needed) right away, and started as soon as its
+	// dependencies are ready; the first failure cancels the containers that have not started yet
+	started := time.Now()
+	ready := map[string]*readiness{}
+	for _, container := range order {
+		ready[container.Name] = &readiness{done: make(chan struct{})}
+	}
+	var wg sync.WaitGroup
+	for i, container := range order {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := ready[container.Name]
+			defer close(r.done)
+			if r.err = this.runContainer(ctx, container, ready); r.err != nil {
+				e := fmt.Errorf("container %d (%v): %w", i, container.Name, r.err)
+				logger.Global().Error(e)
 				cancel(e)
-				continue
-			} else {
-				logger.Global().Debugf("created container %d (%v): %v", i, container.Name, container.GetID())
 			}
+		}()
+	}
+	wg.Wait()
 
-			// start the container
-			if err := this.startContainer(ctx, container); err != nil {
-				e := fmt.Errorf("could not start container %d (%v): %v", i, container.Name, err)
-				logger.Global().Errorf("%v", e)
-				cancel(e)
-				continue
-			} else {
-				logger.Global().Debugf("started container %d (%v)", i, container.Name)
-			}
+	if ctx.Err() == nil {
+		logger.Global().Debugf("ran %d containers in %s", len(containers), time.Since(started).Round(time.Millisecond))
+	} else {
+		logger.Global().Warnf("container-run interrupted: %v", context.Cause(ctx))
+	}
+	return ctx, cancel
+}
 
-			// handle health check
-			if container.HealthCheck != nil {
-				healthy := false
-				var err error
-				for retries := 0; retries < 5; retries++ {
-					if inspect, inspectErr := this.api.ContainerInspect(ctx, container.GetID(), client.ContainerInspectOptions{}); inspectErr != nil {
-						err = fmt.Errorf("could not inspect container %d (%v): %v", i, container.Name, inspectErr)
-						break
-					} else {
-
-						if inspect.Container.State.Health.Status == "healthy" {
-							logger.Global().Debugf("container %d (%v) is healthy", i, container.Name)
-							healthy = true
-							break
-						} else if inspect.Container.State.Health.Status == "unhealthy" {
-							err = fmt.Errorf("container %d (%v) immediately stopped", i, container.Name)
-							break
-						} else {
-							logger.Global().Debugf("container %s (%s) is not healthy (status=%s;retry=%d)", container.Name, container.Image, inspect.Container.State.Health.Status, retries)
-						}
-					}
-					time.Sleep(time.Duration(math.Pow(2, float64(retries))) * time.Second)
-				}
-				if err != nil {
-					logger.Global().Error(err)
-					cancel(err)
-					continue
-				} else if !healthy {
-					err = fmt.Errorf("container %d (%v) is not healthy", i, container.Name)
-					logger.Global().Error(err)
-					cancel(err)
-					continue
-				}
-			}
+// readiness is closed once a container is ready and has run its after-start hook, or has failed
+type readiness struct {
+	done chan struct{}
+	err  error
+}
 
-			// run post-start
-			if container.AfterStart != nil {
-				logger.Global().Debugf("running after-start hook on container %d (%s)", i, container.Name)
-				if output, err := container.AfterStart(ctx, this, container); err != nil {
-					e := fmt.Errorf("after-start hook on container %d (%v): %v", i, container.Name, err)
-					logger.Global().Error(e)
-					cancel(e)
-					continue
-				} else {
-					logger.Global().Debugf("ran after-start hook on container %d (%v): %s", i, container.Name, strings.ReplaceAll(output, "\n", "\\n"))
-				}
-			}
+// runContainer creates a container, waits for its dependencies, then starts it, waits for it to become ready
+// and runs its after-start hook
+func (this *Docker) runContainer(ctx context.Context, container *Container, ready map[string]*readiness) error {
+
+	if err := this.createContainer(ctx, container); err != nil {
+		return fmt.Errorf("could not create container: %w", err)
+	}
+	logger.Global().Debugf("created (or adopted) container %v: %v", container.Name, container.GetID())
 
+	for _, dep := range container.DependsOn {
+		logger.Global().Debugf("container %s is waiting for %s", container.Name, dep)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("skipped (context done: %w)", ctx.Err())
+		case <-ready[dep].done:
+			if ready[dep].err != nil {
+				return fmt.Errorf("dependency %s failed", dep)
+			}
 		}
 	}
 
-	if completedWithoutInterruption {
-		logger.Global().Debugf("ran %d containers", len(containers))
+	if container.started != nil {
+		logger.Global().Debugf("container %v is already running", container.Name)
+	} else if err := this.startContainer(ctx, container); err != nil {
+		return fmt.Errorf("could not start container: %w", err)
 	} else {
-		logger.Global().Warnf("container-run interrupted")
+		logger.Global().Debugf("started container %v", container.Name)
 	}
-	return ctx, cancel
+
+	if err := this.waitReady(ctx, container); err != nil {
+		return err
+	}
+
+	if container.AfterStart != nil {
+		logger.Global().Debugf("running after-start hook on container %s", container.Name)
+		output, err := container.AfterStart(ctx, this, container)
+		if err != nil {
+			return fmt.Errorf("after-start hook: %v", err)
+		}
+		logger.Global().Debugf("ran after-start hook on container %v: %s", container.Name, strings.ReplaceAll(output, "\n", "\\n"))
+	}
+	return nil
 }
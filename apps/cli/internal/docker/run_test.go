@@ -0,0 +1,468 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/fake"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/network"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDocker() (*Docker, *fake.Engine) {
+	engine := fake.New()
+	return NewClient(engine), engine
+}
+
+// testContainer returns a container with a health check whose image is available locally
+func testContainer(engine *fake.Engine, name string, dependsOn ...string) *Container {
+	c := &Container{
+		Name:  name,
+		Image: "example.com/" + name + ":1",
+		HealthCheck: &container.HealthConfig{
+			Test:     []string{"CMD", "true"},
+			Interval: time.Second,
+			Timeout:  time.Second,
+			Retries:  1,
+		},
+		DependsOn: dependsOn,
+	}
+	engine.AddImage(c.Image)
+	return c
+}
+
+// indexOf returns the position of a call, failing the test if it was not made
+func indexOf(t *testing.T, calls []string, call string) int {
+	t.Helper()
+	i := slices.Index(calls, call)
+	if i < 0 {
+		t.Fatalf("expected call %q, got %v", call, calls)
+	}
+	return i
+}
+
+func run(t *testing.T, dkr *Docker, containers ...*Container) error {
+	t.Helper()
+	ctx, cancel := dkr.Run(context.Background(), containers)
+	defer cancel(nil)
+	return context.Cause(ctx)
+}
+
+func TestRunCreatesNetwork(t *testing.T) {
+	dkr, engine := newTestDocker()
+	if err := run(t, dkr, testContainer(engine, "a")); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if !engine.HasNetwork(network.Name) {
+		t.Fatalf("network %s was not created", network.Name)
+	}
+
+	// an existing network is reused
+	dkr, engine = newTestDocker()
+	if _, err := engine.NetworkCreate(context.Background(), network.Name, client.NetworkCreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(t, dkr, testContainer(engine, "a")); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if calls := engine.Calls(); slices.Contains(calls[1:], "NetworkCreate "+network.Name) {
+		t.Fatalf("network was created again: %v", calls)
+	}
+}
+
+func TestRunStartsDependenciesFirst(t *testing.T) {
+	dkr, engine := newTestDocker()
+	engine.SetHealth("db", container.Starting, container.Starting, container.Healthy)
+
+	err := run(t, dkr,
+		testContainer(engine, "storage", "db", "rest"),
+		testContainer(engine, "rest", "db"),
+		testContainer(engine, "db"),
+	)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	calls := engine.Calls()
+	if indexOf(t, calls, "ContainerStart db") > indexOf(t, calls, "ContainerStart rest") {
+		t.Errorf("rest was started before db: %v", calls)
+	}
+	if indexOf(t, calls, "ContainerStart rest") > indexOf(t, calls, "ContainerStart storage") {
+		t.Errorf("storage was started before rest: %v", calls)
+	}
+	for _, name := range []string{"db", "rest", "storage"} {
+		if c, ok := engine.Container(name); !ok || !c.Running {
+			t.Errorf("container %s is not running", name)
+		}
+	}
+}
+
+func TestRunStartsIndependentContainersInParallel(t *testing.T) {
+	dkr, engine := newTestDocker()
+
+	// a cannot start until b has started, which deadlocks unless they are started in parallel
+	bStarted := make(chan struct{})
+	engine.OnCall("ContainerStart", func(name string) {
+		switch name {
+		case "b":
+			close(bStarted)
+		case "a":
+			select {
+			case <-bStarted:
+			case <-time.After(5 * time.Second):
+				t.Error("a and b were not started in parallel")
+			}
+		}
+	})
+
+	if err := run(t, dkr, testContainer(engine, "a"), testContainer(engine, "b")); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+}
+
+func TestRunCreatesContainersBeforeDependenciesAreHealthy(t *testing.T) {
+	dkr, engine := newTestDocker()
+	engine.SetHealth("db", container.Starting, container.Starting, container.Healthy)
+
+	if err := run(t, dkr, testContainer(engine, "db"), testContainer(engine, "rest", "db")); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	// rest is created (and its image pulled) while db is starting, but only started once db is healthy
+	calls := engine.Calls()
+	lastInspect := -1
+	for i, call := range calls {
+		if call == "ContainerInspect db" {
+			lastInspect = i
+		}
+	}
+	if indexOf(t, calls, "ContainerCreate rest") > lastInspect {
+		t.Errorf("rest was not created until db was healthy: %v", calls)
+	}
+	if indexOf(t, calls, "ContainerStart rest") < lastInspect {
+		t.Errorf("rest was started before db was healthy: %v", calls)
+	}
+}
+
+func TestRunPullsMissingImages(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := &Container{Name: "a", Image: "example.com/a:1"}
+	engine.AddRemoteImage(c.Image)
+
+	if err := run(t, dkr, c); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	calls := engine.Calls()
+	if indexOf(t, calls, "ImagePull "+c.Image) > indexOf(t, calls, "ContainerCreate a") {
+		t.Errorf("container was created before its image was pulled: %v", calls)
+	}
+	if !engine.HasImage(c.Image) {
+		t.Errorf("image was not pulled")
+	}
+}
+
+func TestRunFailsWhenImageCannotBePulled(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := &Container{Name: "a", Image: "example.com/missing:1"}
+
+	err := run(t, dkr, c, testContainer(engine, "b", "a"))
+	if err == nil || !strings.Contains(err.Error(), "unable to pull image example.com/missing:1") {
+		t.Fatalf("expected pull error, got %v", err)
+	}
+	if slices.Contains(engine.Calls(), "ContainerStart b") {
+		t.Errorf("dependent container was started")
+	}
+}
+
+func TestRunReplacesConflictingContainer(t *testing.T) {
+	dkr, engine := newTestDocker()
+	stale := engine.AddContainer("a", "example.com/a:0", true)
+
+	if err := run(t, dkr, testContainer(engine, "a")); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	calls := engine.Calls()
+	if indexOf(t, calls, "ContainerRemove a") > indexOf(t, calls, "ContainerStart a") {
+		t.Errorf("stale container was not removed first: %v", calls)
+	}
+	c, ok := engine.Container("a")
+	if !ok || c.ID == stale.ID || c.Options.Config.Image != "example.com/a:1" {
+		t.Errorf("container was not recreated: %+v", c)
+	}
+}
+
+func TestRunFailsWhenConflictingContainerCannotBeRemoved(t *testing.T) {
+	dkr, engine := newTestDocker()
+	engine.AddContainer("a", "example.com/a:0", true)
+	engine.Fail("ContainerRemove", "a", errors.New("Error response from daemon: removal of container a is already in progress"))
+
+	err := run(t, dkr, testContainer(engine, "a"))
+	if err == nil || !strings.Contains(err.Error(), "conflicts and could not be removed") {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+}
+
+func TestRunFailsOnUnhealthyContainerAndSkipsDependents(t *testing.T) {
+	dkr, engine := newTestDocker()
+	engine.SetHealth("db", container.Starting, container.Unhealthy)
+
+	err := run(t, dkr, testContainer(engine, "db"), testContainer(engine, "rest", "db"))
+	if err == nil || !strings.Contains(err.Error(), "db") || !strings.Contains(err.Error(), "unhealthy") {
+		t.Fatalf("expected unhealthy error for db, got %v", err)
+	}
+	if slices.Contains(engine.Calls(), "ContainerStart rest") {
+		t.Errorf("dependent container was started")
+	}
+}
+
+func TestRunFailsWhenContainerStops(t *testing.T) {
+	dkr, engine := newTestDocker()
+	engine.SetHealth("db", container.Starting)
+	engine.OnCall("ContainerStart", func(name string) {
+		_, _ = engine.ContainerStop(context.Background(), name, client.ContainerStopOptions{})
+	})
+
+	err := run(t, dkr, testContainer(engine, "db"))
+	if err == nil || !strings.Contains(err.Error(), "container stopped") {
+		t.Fatalf("expected stopped error, got %v", err)
+	}
+}
+
+func TestRunRejectsInvalidDependencies(t *testing.T) {
+	for name, containers := range map[string]func(*fake.Engine) []*Container{
+		"cycle": func(engine *fake.Engine) []*Container {
+			return []*Container{testContainer(engine, "a", "b"), testContainer(engine, "b", "a")}
+		},
+		"self": func(engine *fake.Engine) []*Container {
+			return []*Container{testContainer(engine, "a", "a")}
+		},
+		"unknown": func(engine *fake.Engine) []*Container {
+			return []*Container{testContainer(engine, "a", "missing")}
+		},
+		"duplicate": func(engine *fake.Engine) []*Container {
+			return []*Container{testContainer(engine, "a"), testContainer(engine, "a")}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dkr, engine := newTestDocker()
+			if err := run(t, dkr, containers(engine)...); err == nil {
+				t.Fatalf("expected an error")
+			}
+			if slices.ContainsFunc(engine.Calls(), func(call string) bool { return strings.HasPrefix(call, "ContainerCreate") }) {
+				t.Errorf("containers were created: %v", engine.Calls())
+			}
+		})
+	}
+}
+
+func TestRunWritesEmbeddedFilesBeforeStart(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "a")
+	c.Embeds = []*EmbeddedFile{{Path: "/etc/a/config.yml", Data: []byte("key: value\n")}}
+
+	if err := run(t, dkr, c); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	calls := engine.Calls()
+	if indexOf(t, calls, "CopyToContainer a") > indexOf(t, calls, "ContainerStart a") {
+		t.Errorf("files were written after start: %v", calls)
+	}
+	created, _ := engine.Container("a")
+	file, ok := created.Files["/etc/a/config.yml"]
+	if !ok || string(file.Data) != "key: value\n" || file.Mode != 0o644 {
+		t.Errorf("file was not written: %+v", created.Files)
+	}
+	if dir, ok := created.Files["/etc/a"]; !ok || !dir.Dir {
+		t.Errorf("parent dir was not created: %+v", created.Files)
+	}
+}
+
+func TestRunRunsAfterStartHooks(t *testing.T) {
+	dkr, engine := newTestDocker()
+	engine.SetExecResult("db", fake.ExecResult{Output: "ALTER ROLE\n"})
+
+	var output string
+	db := testContainer(engine, "db")
+	db.AfterStart = func(ctx context.Context, docker *Docker, container *Container) (string, error) {
+		var err error
+		output, err = docker.ExecInContainer(ctx, container, []string{"psql", "-c", "ALTER ROLE"})
+		return output, err
+	}
+
+	if err := run(t, dkr, db, testContainer(engine, "rest", "db")); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if output != "ALTER ROLE\n" {
+		t.Errorf("unexpected exec output %q", output)
+	}
+	calls := engine.Calls()
+	if indexOf(t, calls, "ExecInspect db") > indexOf(t, calls, "ContainerStart rest") {
+		t.Errorf("dependent was started before the after-start hook finished: %v", calls)
+	}
+}
+
+func TestRunFailsWhenAfterStartHookFails(t *testing.T) {
+	dkr, engine := newTestDocker()
+	engine.SetExecResult("db", fake.ExecResult{Output: "ERROR: role does not exist\n", ExitCode: 1})
+
+	db := testContainer(engine, "db")
+	db.AfterStart = func(ctx context.Context, docker *Docker, container *Container) (string, error) {
+		return docker.ExecInContainer(ctx, container, []string{"psql"})
+	}
+
+	err := run(t, dkr, db, testContainer(engine, "rest", "db"))
+	if err == nil || !strings.Contains(err.Error(), "exited with code 1") {
+		t.Fatalf("expected exec error, got %v", err)
+	}
+	if slices.Contains(engine.Calls(), "ContainerStart rest") {
+		t.Errorf("dependent container was started")
+	}
+}
+
+func TestRunVerifiesPinnedDigests(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := &Container{Name: "a", Image: "example.com/a:1", Digest: fake.Digest}
+	engine.AddImage(c.Image, "example.com/a@"+fake.Digest)
+	if err := run(t, dkr, c); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	dkr, engine = newTestDocker()
+	c = &Container{Name: "a", Image: "example.com/a:1", Digest: fake.Digest}
+	engine.AddImage(c.Image, "example.com/a@sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210")
+	err := run(t, dkr, c)
+	if err == nil || !strings.Contains(err.Error(), "does not match its pinned digest") {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+	if slices.Contains(engine.Calls(), "ContainerCreate a") {
+		t.Errorf("container was created from an unverified image")
+	}
+}
+
+func TestRunStopsOnParentCancel(t *testing.T) {
+	dkr, engine := newTestDocker()
+	engine.SetHealth("db", container.Starting)
+
+	parent, cancelParent := context.WithCancel(context.Background())
+	engine.OnCall("ContainerStart", func(name string) {
+		if name == "db" {
+			cancelParent()
+		}
+	})
+
+	ctx, cancel := dkr.Run(parent, []*Container{testContainer(engine, "db"), testContainer(engine, "rest", "db")})
+	defer cancel(nil)
+	if ctx.Err() == nil {
+		t.Fatalf("run was not cancelled")
+	}
+	if slices.Contains(engine.Calls(), "ContainerStart rest") {
+		t.Errorf("dependent container was started after cancel")
+	}
+}
+
+func TestStopStopsDependentsFirst(t *testing.T) {
+	dkr, engine := newTestDocker()
+	containers := []*Container{
+		testContainer(engine, "db"),
+		testContainer(engine, "rest", "db"),
+		testContainer(engine, "kong"),
+	}
+	if err := run(t, dkr, containers...); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	if errs := dkr.Stop(context.Background(), containers); len(errs) > 0 {
+		t.Fatalf("stop failed: %v", errs)
+	}
+	calls := engine.Calls()
+	if indexOf(t, calls, "ContainerStop rest") > indexOf(t, calls, "ContainerStop db") {
+		t.Errorf("db was stopped before rest: %v", calls)
+	}
+	for _, name := range []string{"db", "rest", "kong"} {
+		if _, ok := engine.Container(name); ok {
+			t.Errorf("container %s was not removed", name)
+		}
+	}
+}
+
+func TestStopSkipsContainersThatWereNotCreated(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "a")
+	if errs := dkr.Stop(context.Background(), []*Container{c}); len(errs) > 0 {
+		t.Fatalf("stop failed: %v", errs)
+	}
+	if calls := engine.Calls(); len(calls) > 0 {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+}
+
+func TestStopReportsErrors(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "a")
+	if err := run(t, dkr, c); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	engine.Fail("ContainerStop", "a", errors.New("Error response from daemon: cannot stop container"))
+
+	if errs := dkr.Stop(context.Background(), []*Container{c}); len(errs) != 1 {
+		t.Fatalf("expected one error, got %v", errs)
+	}
+	if _, ok := engine.Container("a"); ok {
+		t.Errorf("container was not removed after failing to stop")
+	}
+}
+
+func TestRestartRewritesEmbeddedFiles(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "kong")
+	c.Embeds = []*EmbeddedFile{{Path: "/etc/kong.yml", Data: []byte("v1")}}
+	if err := run(t, dkr, c); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	c.Embeds[0].Data = []byte("v2")
+	if err := dkr.Restart(context.Background(), c); err != nil {
+		t.Fatalf("restart failed: %v", err)
+	}
+	restarted, _ := engine.Container("kong")
+	if string(restarted.Files["/etc/kong.yml"].Data) != "v2" || restarted.Started != 2 {
+		t.Errorf("container was not restarted with new files: %+v", restarted)
+	}
+}
+
+func TestRecreateReplacesContainer(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "auth")
+	if err := run(t, dkr, c); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	before, _ := engine.Container("auth")
+
+	c.Env = []string{"GOTRUE_JWT_SECRET=rotated"}
+	if err := dkr.Recreate(context.Background(), c); err != nil {
+		t.Fatalf("recreate failed: %v", err)
+	}
+	after, _ := engine.Container("auth")
+	if after.ID == before.ID || !slices.Equal(after.Options.Config.Env, c.Env) || !after.Running {
+		t.Errorf("container was not recreated: %+v", after)
+	}
+}
+
+func TestIsRunning(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "a")
+
+	if running, err := dkr.IsRunning(context.Background(), c); err != nil || running {
+		t.Fatalf("expected not running, got %v (%v)", running, err)
+	}
+	if err := run(t, dkr, c); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if running, err := dkr.IsRunning(context.Background(), c); err != nil || !running {
+		t.Fatalf("expected running, got %v (%v)", running, err)
+	}
+}
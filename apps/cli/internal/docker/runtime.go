@@ -0,0 +1,171 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/moby/moby/client"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/network"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Backend is the engine behind the Docker Engine API the orchestrator uses
+type Backend string
+
+const (
+	BackendDocker Backend = "docker"
+	BackendPodman Backend = "podman" // through its Docker-compatible API
+)
+
+const (
+	DockerHostEnv    = "DOCKER_HOST"    // the engine to connect to, of either backend
+	ContainerHostEnv = "CONTAINER_HOST" // the Podman service to connect to, as for `podman --remote`
+)
+
+// Runtime is a container engine to connect to
+type Runtime struct {
+	Backend Backend // a guess until the engine is connected to, which reports what it is (see Connect)
+	Host    string  // e.g. unix:///var/run/docker.sock
+}
+
+// Name returns the product name of the runtime's backend, e.g. for messages
+func (this Runtime) Name() string {
+	if this.Backend == BackendPodman {
+		return "Podman"
+	}
+	return "Docker"
+}
+
+func (this Runtime) String() string {
+	return this.Name() + " at " + this.Host
+}
+
+// networkCreateOptions returns the options the instance's network is created with
+func (this Runtime) networkCreateOptions() client.NetworkCreateOptions {
+	options := client.NetworkCreateOptions{
+		Driver:     "bridge",
+		Scope:      "local",
+		EnableIPv4: utils.Pointer(true),
+		EnableIPv6: utils.Pointer(true),
+		Internal:   false, // true = no external connectivity (usually keep false)
+		Attachable: true,  // allow standalone containers to attach/detach
+	}
+	if this.Backend == BackendPodman {
+		// podman only enables IPv6 with a subnet to allocate from, which a rootless network cannot route anyway;
+		// and as it has no swarm, every network is local and attachable
+		options.EnableIPv6 = utils.Pointer(false)
+		options.Scope = ""
+		options.Attachable = false
+	}
+	return options
+}
+
+// FindRuntime returns the runtime to connect to: the engine at DOCKER_HOST or CONTAINER_HOST if either is set, or
+// else the first of rootful Docker, rootless Docker, rootless Podman and rootful Podman whose socket exists (or
+// rootful Docker, if none does)
+func FindRuntime() Runtime {
+	return findRuntime(os.Getenv, runtimeSockets(os.Getenv))
+}
+
+// runtimeSockets returns the default sockets of each runtime, in the order they are tried
+func runtimeSockets(getenv func(string) string) []Runtime {
+	runDir := getenv("XDG_RUNTIME_DIR")
+	if runDir == "" {
+		runDir = filepath.Join("/run/user", strconv.Itoa(os.Getuid()))
+	}
+	return []Runtime{
+		{Backend: BackendDocker, Host: client.DefaultDockerHost},
+		{Backend: BackendDocker, Host: "unix://" + filepath.Join(runDir, "docker.sock")},
+		{Backend: BackendPodman, Host: "unix://" + filepath.Join(runDir, "podman", "podman.sock")},
+		{Backend: BackendPodman, Host: "unix:///run/podman/podman.sock"},
+	}
+}
+
+func findRuntime(getenv func(string) string, sockets []Runtime) Runtime {
+
+	if host := getenv(DockerHostEnv); host != "" {
+		backend := BackendDocker
+		if strings.Contains(host, "podman") {
+			backend = BackendPodman
+		}
+		return Runtime{Backend: backend, Host: host}
+	}
+	if host := getenv(ContainerHostEnv); host != "" {
+		return Runtime{Backend: BackendPodman, Host: host}
+	}
+
+	for _, rt := range sockets {
+		path, ok := strings.CutPrefix(rt.Host, "unix://")
+		if !ok {
+			continue
+		}
+		if stat, err := os.Stat(path); err == nil && stat.Mode()&os.ModeSocket != 0 {
+			return rt
+		}
+	}
+	return sockets[0]
+}
+
+// Connect creates a client for the engine of a runtime, checks that the engine is reachable (classifying the
+// error if not; see Classify), and finds which backend the engine is (see Runtime)
+func Connect(ctx context.Context, rt Runtime) (*Docker, error) {
+
+	api, err := client.New(client.WithHost(rt.Host), client.WithTLSClientConfigFromEnv())
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize client for %s: %w", rt, err)
+	}
+
+	ping, err := api.Ping(ctx, client.PingOptions{})
+	if err = Classify("Ping", err); err != nil {
+		return nil, err
+	}
+
+	// podman reports itself as a component of the engine
+	if version, err := api.ServerVersion(ctx, client.ServerVersionOptions{}); err != nil {
+		logger.Global().Debugf("could not get the version of %s, assuming it is %s: %v", rt.Host, rt.Name(), err)
+	} else {
+		rt.Backend = BackendDocker
+		for _, component := range version.Components {
+			if strings.Contains(strings.ToLower(component.Name), "podman") {
+				rt.Backend = BackendPodman
+			}
+		}
+		if strings.Contains(strings.ToLower(version.Platform.Name), "podman") {
+			rt.Backend = BackendPodman
+		}
+		logger.Global().Debugf("connected to %s v%s for %s (API v%s)", rt, version.Version, ping.OSType, ping.APIVersion)
+	}
+
+	dkr := NewClient(api)
+	dkr.runtime = rt
+	return dkr, nil
+}
+
+// Runtime returns the runtime of the engine the client is connected to
+func (this *Docker) Runtime() Runtime {
+	return this.runtime
+}
+
+// ensureNetwork creates the instance's network, unless it exists
+func (this *Docker) ensureNetwork(ctx context.Context) error {
+
+	inspect, err := this.api.NetworkInspect(ctx, network.Name, client.NetworkInspectOptions{Verbose: true})
+	if err == nil {
+		logger.Global().Debugf("found network: %s", inspect.Network.ID)
+		return nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("could not inspect network: %w", err)
+	}
+
+	created, err := this.api.NetworkCreate(ctx, network.Name, this.runtime.networkCreateOptions())
+	if err != nil {
+		return fmt.Errorf("could not create network: %w", err)
+	}
+	logger.Global().Debugf("created network: %s", created.ID)
+	return nil
+}
@@ -0,0 +1,172 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// fakeSocket is an engine API on a unix socket that answers what Connect and ensureNetwork ask, as Docker or Podman
+type fakeSocket struct {
+	Host string
+
+	lock     sync.Mutex
+	networks []map[string]any // the bodies of network create requests
+}
+
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+func newFakeSocket(t *testing.T, backend Backend) *fakeSocket {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), string(backend)+".sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("could not listen on %s: %v", path, err)
+	}
+
+	socket := &fakeSocket{Host: "unix://" + path}
+	apiVersion, version := "1.52", map[string]any{"Version": "29.0.0", "ApiVersion": "1.52", "Platform": map[string]any{"Name": "Docker Engine - Community"}}
+	if backend == BackendPodman {
+		apiVersion = "1.41"
+		version = map[string]any{
+			"Version":    "5.4.0",
+			"ApiVersion": "1.41",
+			"Platform":   map[string]any{"Name": "linux/amd64/fedora-41"},
+			"Components": []map[string]any{{"Name": "Podman Engine", "Version": "5.4.0"}},
+		}
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch path := apiVersionPrefix.ReplaceAllString(r.URL.Path, ""); {
+		case path == "/_ping":
+			w.Header().Set("Api-Version", apiVersion)
+			w.Header().Set("Ostype", "linux")
+			_, _ = io.WriteString(w, "OK")
+		case path == "/version":
+			_ = json.NewEncoder(w).Encode(version)
+		case path == "/networks/create" && r.Method == http.MethodPost:
+			var body map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			socket.lock.Lock()
+			socket.networks = append(socket.networks, body)
+			socket.lock.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			_, _ = io.WriteString(w, `{"Id":"0123456789ab"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"message":"not found"}`)
+		}
+	})}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+	return socket
+}
+
+// createdNetworks returns the bodies of the network create requests
+func (this *fakeSocket) createdNetworks() []map[string]any {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.networks
+}
+
+func TestFindRuntime(t *testing.T) {
+	podman := newFakeSocket(t, BackendPodman)
+	sockets := []Runtime{
+		{Backend: BackendDocker, Host: "unix://" + filepath.Join(t.TempDir(), "docker.sock")}, // does not exist
+		{Backend: BackendPodman, Host: podman.Host},
+	}
+
+	for _, test := range []struct {
+		name     string
+		env      map[string]string
+		sockets  []Runtime
+		expected Runtime
+	}{
+		{"DOCKER_HOST", map[string]string{DockerHostEnv: "tcp://10.0.0.1:2375", ContainerHostEnv: podman.Host}, sockets, Runtime{Backend: BackendDocker, Host: "tcp://10.0.0.1:2375"}},
+		{"podman at DOCKER_HOST", map[string]string{DockerHostEnv: "unix:///run/user/1000/podman/podman.sock"}, sockets, Runtime{Backend: BackendPodman, Host: "unix:///run/user/1000/podman/podman.sock"}},
+		{"CONTAINER_HOST", map[string]string{ContainerHostEnv: "unix:///run/podman/podman.sock"}, sockets, Runtime{Backend: BackendPodman, Host: "unix:///run/podman/podman.sock"}},
+		{"first socket that exists", nil, sockets, sockets[1]},
+		{"no socket exists", nil, sockets[:1], sockets[0]},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := findRuntime(func(k string) string { return test.env[k] }, test.sockets); actual != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRuntimeSocketsOfRootlessEngines(t *testing.T) {
+	sockets := runtimeSockets(func(k string) string { return map[string]string{"XDG_RUNTIME_DIR": "/run/user/1000"}[k] })
+	for _, expected := range []Runtime{
+		{Backend: BackendDocker, Host: "unix:///run/user/1000/docker.sock"},
+		{Backend: BackendPodman, Host: "unix:///run/user/1000/podman/podman.sock"},
+	} {
+		found := false
+		for _, rt := range sockets {
+			found = found || rt == expected
+		}
+		if !found {
+			t.Errorf("expected %+v among %+v", expected, sockets)
+		}
+	}
+}
+
+func TestConnectAdaptsNetworkToBackend(t *testing.T) {
+	for _, test := range []struct {
+		backend Backend
+		ipv6    bool
+	}{
+		{BackendDocker, true},
+		{BackendPodman, false},
+	} {
+		t.Run(string(test.backend), func(t *testing.T) {
+			socket := newFakeSocket(t, test.backend)
+
+			// guessed wrong, e.g. from DOCKER_HOST
+			guess := BackendPodman
+			if test.backend == BackendPodman {
+				guess = BackendDocker
+			}
+			dkr, err := Connect(context.Background(), Runtime{Backend: guess, Host: socket.Host})
+			if err != nil {
+				t.Fatalf("could not connect: %v", err)
+			}
+			if rt := dkr.Runtime(); rt.Backend != test.backend || rt.Host != socket.Host {
+				t.Errorf("expected %s at %s, got %+v", test.backend, socket.Host, rt)
+			}
+
+			if err := dkr.ensureNetwork(context.Background()); err != nil {
+				t.Fatalf("could not create network: %v", err)
+			}
+			networks := socket.createdNetworks()
+			if len(networks) != 1 {
+				t.Fatalf("expected the network to be created once, got %v", networks)
+			}
+			if ipv6, _ := networks[0]["EnableIPv6"].(bool); ipv6 != test.ipv6 {
+				t.Errorf("expected IPv6 to be enabled: %v, got %v", test.ipv6, networks[0])
+			}
+			if networks[0]["Name"] != "projdocs" || networks[0]["Driver"] != "bridge" {
+				t.Errorf("unexpected network: %v", networks[0])
+			}
+		})
+	}
+}
+
+func TestConnectToMissingSocketIsUnavailable(t *testing.T) {
+	_, err := Connect(context.Background(), Runtime{Backend: BackendPodman, Host: "unix://" + filepath.Join(t.TempDir(), "podman.sock")})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected the runtime to be unavailable, got %v", err)
+	}
+}
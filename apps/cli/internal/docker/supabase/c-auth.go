@@ -6,14 +6,19 @@ import (
 	"github.com/projdocs/projdocs/apps/cli/internal/config"
 	"github.com/projdocs/projdocs/apps/cli/internal/docker"
 	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase/postgres"
+	"strings"
 	"time"
 )
 
 var Auth docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supabase) docker.ContainerConstructor {
 	return func() (*docker.Container, error) {
-		return &docker.Container{
-			Name:  "projdocs-supabase-auth",
-			Image: "ghcr.io/supabase/gotrue:v2.184.0",
+		c := &docker.Container{
+			Name:      "projdocs-supabase-auth",
+			Aliases:   []string{"auth", "gotrue"},
+			Image:     cfg.Images["auth"].Image,
+			Digest:    cfg.Images["auth"].Digest,
+			DependsOn: []string{postgres.ContainerName},
+			Expose:    []uint16{9999},
 			HealthCheck: &container.HealthConfig{
 				Interval: 5 * time.Second,
 				Timeout:  5 * time.Second,
@@ -44,14 +49,14 @@ var Auth docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supabase
 				),
 
 				fmt.Sprintf("%s=%s", "GOTRUE_SITE_URL", cfg.Kong.URLs.Site),
-				fmt.Sprintf("%s=%s", "GOTRUE_URI_ALLOW_LIST", ""),
+				fmt.Sprintf("%s=%s", "GOTRUE_URI_ALLOW_LIST", strings.Join(cfg.Kong.URLs.Redirects, ",")),
 				fmt.Sprintf("%s=%s", "GOTRUE_DISABLE_SIGNUP", "true"),
 
 				fmt.Sprintf("%s=%s", "GOTRUE_JWT_ADMIN_ROLES", "service_role"),
 				fmt.Sprintf("%s=%s", "GOTRUE_JWT_AUD", "authenticated"),
 				fmt.Sprintf("%s=%s", "GOTRUE_JWT_DEFAULT_GROUP_NAME", "authenticated"),
 				fmt.Sprintf("%s=%s", "GOTRUE_JWT_EXP", "3600"),
-				fmt.Sprintf("%s=%s", "GOTRUE_JWT_SECRET", cfg.Keys.JwtSecret),
+				fmt.Sprintf("%s=%s", "GOTRUE_JWT_SECRET", cfg.Keys.ServiceSecret("auth")),
 
 				fmt.Sprintf("%s=%s", "GOTRUE_EXTERNAL_EMAIL_ENABLED", "false"),
 				fmt.Sprintf("%s=%s", "GOTRUE_EXTERNAL_ANONYMOUS_USERS_ENABLED", "false"),
@@ -80,6 +85,14 @@ var Auth docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supabase
 				"GOTRUE_MFA_WEB_AUTHN_VERIFY_ENABLED=true",
 				"GOTRUE_MFA_MAX_ENROLLED_FACTORS=10",
 			},
-		}, nil
+		}
+
+		// sign sessions with auth's own key, and accept tokens signed with the keys replaced by a rotation until the grace window ends;
+		// the other services cannot restrict auth's key to user sessions (see `keys rotate --help`)
+		if cfg.Keys.UsesJWKS() {
+			c.Env = append(c.Env, fmt.Sprintf("%s=%s", "GOTRUE_JWT_KEYS", cfg.Keys.AuthJWKs()))
+		}
+
+		return c, nil
 	}
 }
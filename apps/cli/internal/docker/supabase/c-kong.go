@@ -5,50 +5,73 @@ import (
 	"github.com/projdocs/projdocs/apps/cli/internal/config"
 	"github.com/projdocs/projdocs/apps/cli/internal/docker"
 	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase/kong"
+	"strings"
 )
 
+// KongConfig returns the declarative config of kong, which accepts the keys replaced by a rotation until the grace
+// window ends
+func KongConfig(cfg *config.Supabase) *kong.Config {
+	credentials := kong.Credentials{
+		AnonKeys:          []string{cfg.Keys.PublicJwt},
+		ServiceKeys:       []string{cfg.Keys.PrivateJwt},
+		DashboardUsername: cfg.Dashboard.Username,
+		DashboardPassword: cfg.Dashboard.Password,
+	}
+	if cfg.Keys.Previous != nil {
+		credentials.AnonKeys = append(credentials.AnonKeys, cfg.Keys.Previous.PublicJwt)
+		credentials.ServiceKeys = append(credentials.ServiceKeys, cfg.Keys.Previous.PrivateJwt)
+	}
+	return kong.New(credentials, cfg.Kong.URLs.CORSOrigins)
+}
+
 var Kong docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supabase) docker.ContainerConstructor {
 	return func() (*docker.Container, error) {
+
+		declarative := KongConfig(cfg)
+		if err := declarative.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid kong config:\n%w", err)
+		}
+		configFile, err := declarative.YAML()
+		if err != nil {
+			return nil, err
+		}
+
 		return &docker.Container{
-			Name:  kong.ContainerName,
-			Image: "docker.io/kong:3.9.1",
+			Name:    kong.ContainerName,
+			Aliases: []string{"kong", "gateway"},
+			Image:   cfg.Images["kong"].Image,
+			Digest:  cfg.Images["kong"].Digest,
 			Embeds: []*docker.EmbeddedFile{
 				{
-					Data: kong.ConfigFile,
-					Path: "/var/tmp/kong.yml",
+					Data:      configFile,
+					Path:      kong.ConfigPath,
+					Mode:      0o600,
+					User:      "kong",
+					Sensitive: true,
 				},
 			},
-			Entrypoint: []string{
-				"sh",
-				"-c",
-				`set -euo
-_contents="$(cat /var/tmp/kong.yml)";
-_rendered="$(eval "echo \"$_contents\"")";
-printf '%s' "$_rendered" > /usr/local/kong/kong.yml;
-/docker-entrypoint.sh kong docker-start;`,
-			},
 			Env: []string{
 				fmt.Sprintf("%s=%s", "KONG_STATUS_LISTEN", "127.0.0.1:8100"),
 				fmt.Sprintf("%s=%s", "KONG_DATABASE", "off"),
-				fmt.Sprintf("%s=%s", "KONG_DECLARATIVE_CONFIG", "/usr/local/kong/kong.yml"),
+				fmt.Sprintf("%s=%s", "KONG_DECLARATIVE_CONFIG", kong.ConfigPath),
 				fmt.Sprintf("%s=%s", "KONG_DNS_ORDER", "LAST,A,CNAME"),
-				fmt.Sprintf("%s=%s", "KONG_PLUGINS", "request-transformer,cors,key-auth,acl,basic-auth,request-termination,ip-restriction"),
+				fmt.Sprintf("%s=%s", "KONG_PLUGINS", strings.Join(kong.Plugins, ",")),
 				fmt.Sprintf("%s=%s", "KONG_NGINX_PROXY_PROXY_BUFFER_SIZE", "160k"),
 				fmt.Sprintf("%s=%s", "KONG_NGINX_PROXY_PROXY_BUFFERS", "64 160k"),
-				fmt.Sprintf("%s=%s", "SUPABASE_ANON_KEY", cfg.Keys.PublicJwt),
-				fmt.Sprintf("%s=%s", "SUPABASE_SERVICE_KEY", cfg.Keys.PrivateJwt),
-				fmt.Sprintf("%s=%s", "DASHBOARD_USERNAME", cfg.Dashboard.Username),
-				fmt.Sprintf("%s=%s", "DASHBOARD_PASSWORD", cfg.Dashboard.Password),
 			},
 			Ports: []*docker.PortBindingMap{
 				{
 					ContainerPort: 8000,
 					Server: &docker.PortBinding{
-						Host: "127.0.0.1",
-						Port: 8000,
+						Host: cfg.Kong.Listen.Host,
+						Port: cfg.Kong.Listen.Port,
 					},
 				},
 			},
+			// the image has no health check; kong reports whether it has loaded its config and is proxying
+			Readiness: &docker.Readiness{
+				Probe: docker.ExecProbe{Command: []string{"kong", "health"}},
+			},
 		}, nil
 	}
 }
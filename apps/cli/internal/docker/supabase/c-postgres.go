@@ -8,7 +8,6 @@ import (
 	"github.com/projdocs/projdocs/apps/cli/internal/config"
 	"github.com/projdocs/projdocs/apps/cli/internal/docker"
 	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase/postgres"
-	"os"
 	"strings"
 	"time"
 )
@@ -28,25 +27,18 @@ var Postgres docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supa
 						  ALTER USER supabase_replication_admin WITH PASSWORD '%s';
 						  ALTER USER supabase_storage_admin     WITH PASSWORD '%s';`
 
-	return func() (*docker.Container, error) {
+	// updatePasswords returns the SQL that sets the password of every role
+	updatePasswords := func(p string) string {
+		return fmt.Sprintf(fmtUpdatePassword, p, p, p, p, p, p, p, p, p, p, p, p)
+	}
 
-		if stat, err := os.Stat(cfg.Database.DataDirectory); err != nil {
-			if os.IsNotExist(err) {
-				if err := os.MkdirAll(cfg.Database.DataDirectory, 0755); err != nil {
-					return nil, fmt.Errorf("could not create database dir: %w", err)
-				} else {
-					stat, err = os.Stat(cfg.Database.DataDirectory)
-				}
-			} else {
-				return nil, fmt.Errorf("could not get database dir (%s): %w", cfg.Database.DataDirectory, err)
-			}
-		} else if !stat.IsDir() {
-			return nil, fmt.Errorf("database dir (%s) is not a directory", cfg.Database.DataDirectory)
-		}
+	return func() (*docker.Container, error) {
 
 		return &docker.Container{
-			Name:  postgres.ContainerName,
-			Image: "ghcr.io/supabase/postgres:17.6.1.066",
+			Name:    postgres.ContainerName,
+			Aliases: []string{"db", "postgres"},
+			Image:   cfg.Images["postgres"].Image,
+			Digest:  cfg.Images["postgres"].Digest,
 			Command: []string{
 				"postgres",
 				"-c", "config_file=/etc/postgresql/postgresql.conf",
@@ -60,6 +52,7 @@ var Postgres docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supa
 					Target: "/var/lib/postgresql/data",
 				},
 			},
+			Expose: []uint16{5432},
 			Env: []string{
 				"POSTGRES_HOST=/var/run/postgresql",
 				"PGPORT=5432",
@@ -68,7 +61,7 @@ var Postgres docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supa
 				fmt.Sprintf("POSTGRES_PASSWORD=%s", cfg.Database.Password),
 				"PGDATABASE=postgres",
 				"POSTGRES_DB=postgres",
-				fmt.Sprintf("JWT_SECRET=%s", cfg.Keys.JwtSecret),
+				fmt.Sprintf("JWT_SECRET=%s", cfg.Keys.ServiceSecret("postgres")),
 				"JWT_EXP=3600",
 			},
 			HealthCheck: &container.HealthConfig{
@@ -85,7 +78,6 @@ var Postgres docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supa
 			AfterStart: func(ctx context.Context, docker *docker.Docker, container *docker.Container) (string, error) {
 
 				// patch postgres password
-				p := cfg.Database.Password
 				output, err := docker.ExecInContainer(ctx, container, []string{
 					"psql",
 					"-h", "127.0.0.1",
@@ -93,45 +85,31 @@ var Postgres docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supa
 					"-d", "postgres",
 					"-v", "ON_ERROR_STOP=1",
 					"-c",
-					fmt.Sprintf(fmtUpdatePassword, p, p, p, p, p, p, p, p, p, p, p, p),
+					updatePasswords(cfg.Database.Password),
 				})
 				if err != nil {
 					return output, fmt.Errorf("failed to patch postgres password: %v (%s)", err, strings.ReplaceAll(strings.TrimSpace(output), "\n", "\\n"))
 				}
 				return output, nil
 			},
+			// from another container, once the database accepts connections; the password is taken from the environment
+			AfterStartJob: []string{
+				"sh",
+				"-c",
+				fmt.Sprintf(`until pg_isready -h %[1]s -U postgres; do sleep 2; done
+exec psql -h %[1]s -U supabase_admin -d postgres -v ON_ERROR_STOP=1 -c "%[2]s"`, postgres.ContainerName, updatePasswords("$POSTGRES_PASSWORD")),
+			},
 			Embeds: []*docker.EmbeddedFile{
 				{
-					Path: "/etc/postgresql-custom/pgsodium_root.key",
-					Data: []byte(cfg.Keys.PgSodiumEncryption),
-				},
-				{
-					Data: postgres.RealtimeSQL,
-					Path: "/docker-entrypoint-initdb.d/migrations/99-realtime.sql",
-				},
-				{
-					Data: postgres.WebhooksSQL,
-					Path: "/docker-entrypoint-initdb.d/init-scripts/98-webhooks.sql",
-				},
-				{
-					Data: postgres.RolesSQL,
-					Path: "/docker-entrypoint-initdb.d/init-scripts/99-roles.sql",
-				},
-				{
-					Data: postgres.JwtSQL,
-					Path: "/docker-entrypoint-initdb.d/init-scripts/99-jwt.sql",
-				},
-				{
-					Data: postgres.SupabaseSQL,
-					Path: "/docker-entrypoint-initdb.d/migrations/97-_supabase.sql",
-				},
-				{
-					Data: postgres.LogsSQL,
-					Path: "/docker-entrypoint-initdb.d/migrations/99-logs.sql",
+					Path:      postgres.RootKeyPath,
+					Data:      []byte(cfg.Keys.PgSodiumEncryption),
+					Mode:      0o600,
+					User:      "postgres",
+					Sensitive: true,
 				},
 				{
-					Data: postgres.PoolerSQL,
-					Path: "/docker-entrypoint-initdb.d/migrations/99-pooler.sql",
+					Path: postgres.InitDBPath,
+					Tree: postgres.InitDB,
 				},
 			},
 		}, nil
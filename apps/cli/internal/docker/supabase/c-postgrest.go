@@ -7,22 +7,29 @@ import (
 	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase/postgres"
 )
 
+// PostgrestContainerName is the name of the PostgREST container
+const PostgrestContainerName = "projdocs-supabase-rest"
+
 var Postgrest docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supabase) docker.ContainerConstructor {
 	return func() (*docker.Container, error) {
 		return &docker.Container{
-			Name:       "projdocs-supabase-rest",
-			Image:      "docker.io/postgrest/postgrest:v14.1",
+			Name:       PostgrestContainerName,
+			Aliases:    []string{"rest", "postgrest"},
+			Image:      cfg.Images["postgrest"].Image,
+			Digest:     cfg.Images["postgrest"].Digest,
 			Embeds:     nil,
 			Ports:      nil,
+			Expose:     []uint16{3000},
 			Entrypoint: nil,
+			DependsOn:  []string{postgres.ContainerName},
 			Env: []string{
 				fmt.Sprintf("PGRST_DB_URI=postgres://authenticator:%s@%s:5432/postgres", cfg.Database.Password, postgres.ContainerName),
 				"PGRST_ADMIN_SERVER_PORT=3001",
 				"PGRST_DB_SCHEMAS=public",
 				"PGRST_DB_ANON_ROLE=anon",
-				fmt.Sprintf("PGRST_JWT_SECRET=%s", cfg.Keys.JwtSecret),
+				fmt.Sprintf("PGRST_JWT_SECRET=%s", cfg.Keys.VerificationSecret()),
 				"PGRST_DB_USE_LEGACY_GUCS=false",
-				fmt.Sprintf("PGRST_APP_SETTINGS_JWT_SECRET=%s", cfg.Keys.JwtSecret),
+				fmt.Sprintf("PGRST_APP_SETTINGS_JWT_SECRET=%s", cfg.Keys.ServiceSecret("postgrest")),
 				"PGRST_APP_SETTINGS_JWT_EXP=3600",
 			},
 		}, nil
@@ -6,15 +6,18 @@ import (
 	"github.com/projdocs/projdocs/apps/cli/internal/config"
 	"github.com/projdocs/projdocs/apps/cli/internal/docker"
 	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase/postgres"
-	"github.com/projdocs/projdocs/apps/cli/internal/utils"
 	"time"
 )
 
 var Realtime docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supabase) docker.ContainerConstructor {
 	return func() (*docker.Container, error) {
-		return &docker.Container{
-			Name:  "realtime-dev.supabase-realtime",
-			Image: "ghcr.io/supabase/realtime:v2.68.0",
+		c := &docker.Container{
+			Name:      "realtime-dev.supabase-realtime",
+			Aliases:   []string{"realtime"},
+			Image:     cfg.Images["realtime"].Image,
+			Digest:    cfg.Images["realtime"].Digest,
+			DependsOn: []string{postgres.ContainerName},
+			Expose:    []uint16{4000},
 			HealthCheck: &container.HealthConfig{
 				Interval: 5 * time.Second,
 				Timeout:  5 * time.Second,
@@ -33,8 +36,8 @@ var Realtime docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supa
 				fmt.Sprintf("%s=%s", "DB_NAME", "postgres"),
 				fmt.Sprintf("%s=%s", "DB_AFTER_CONNECT_QUERY", "SET search_path TO _realtime"),
 				fmt.Sprintf("%s=%s", "DB_ENC_KEY", "supabaserealtime"),
-				fmt.Sprintf("%s=%s", "API_JWT_SECRET", cfg.Keys.JwtSecret),
-				fmt.Sprintf("%s=%s", "SECRET_KEY_BASE", utils.RandomString(64)),
+				fmt.Sprintf("%s=%s", "API_JWT_SECRET", cfg.Keys.ServiceSecret("realtime")),
+				fmt.Sprintf("%s=%s", "SECRET_KEY_BASE", cfg.Realtime.SecretKeyBase),
 				fmt.Sprintf("%s=%s", "ERL_AFLAGS", "-proto_dist inet_tcp"),
 				fmt.Sprintf("%s=%s", "DNS_NODES", "''"),
 				fmt.Sprintf("%s=%s", "RLIMIT_NOFILE", "10000"),
@@ -42,6 +45,13 @@ var Realtime docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supa
 				fmt.Sprintf("%s=%s", "SEED_SELF_HOST", "true"),
 				fmt.Sprintf("%s=%s", "RUN_JANITOR", "true"),
 			},
-		}, nil
+		}
+
+		// verify asymmetric tokens, and accept tokens signed with the keys replaced by a rotation until the grace window ends
+		if cfg.Keys.UsesJWKS() {
+			c.Env = append(c.Env, fmt.Sprintf("%s=%s", "API_JWT_JWKS", cfg.Keys.VerificationJWKS()))
+		}
+
+		return c, nil
 	}
 }
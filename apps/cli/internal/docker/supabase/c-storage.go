@@ -7,30 +7,19 @@ import (
 	"github.com/projdocs/projdocs/apps/cli/internal/config"
 	"github.com/projdocs/projdocs/apps/cli/internal/docker"
 	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase/postgres"
-	"os"
 	"time"
 )
 
 var Storage docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supabase) docker.ContainerConstructor {
 	return func() (*docker.Container, error) {
 
-		if stat, err := os.Stat(cfg.Storage.DataDirectory); err != nil {
-			if os.IsNotExist(err) {
-				if err := os.MkdirAll(cfg.Storage.DataDirectory, 0755); err != nil {
-					return nil, fmt.Errorf("could not create storage dir: %w", err)
-				} else {
-					stat, err = os.Stat(cfg.Storage.DataDirectory)
-				}
-			} else {
-				return nil, fmt.Errorf("could not get storage dir (%s): %w", cfg.Storage.DataDirectory, err)
-			}
-		} else if !stat.IsDir() {
-			return nil, fmt.Errorf("storage dir (%s) is not a directory", cfg.Storage.DataDirectory)
-		}
-
-		return &docker.Container{
-			Name:  "projdocs-supabase-storage",
-			Image: "ghcr.io/supabase/storage-api:v1.33.0",
+		c := &docker.Container{
+			Name:      "projdocs-supabase-storage",
+			Aliases:   []string{"storage"},
+			Image:     cfg.Images["storage"].Image,
+			Digest:    cfg.Images["storage"].Digest,
+			DependsOn: []string{postgres.ContainerName, PostgrestContainerName},
+			Expose:    []uint16{5000},
 			Mounts: []mount.Mount{
 				{
 					Type:   mount.TypeBind,
@@ -42,7 +31,7 @@ var Storage docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supab
 				fmt.Sprintf("%s=%s", "ANON_KEY", cfg.Keys.PublicJwt),
 				fmt.Sprintf("%s=%s", "SERVICE_KEY", cfg.Keys.PrivateJwt),
 				fmt.Sprintf("%s=%s", "POSTGREST_URL", "http://rest:3000"),
-				fmt.Sprintf("%s=%s", "PGRST_JWT_SECRET", cfg.Keys.JwtSecret),
+				fmt.Sprintf("%s=%s", "PGRST_JWT_SECRET", cfg.Keys.ServiceSecret("storage")),
 				fmt.Sprintf("%s=%s", "DATABASE_URL", fmt.Sprintf("postgres://supabase_storage_admin:%s@%s:5432/postgres", cfg.Database.Password, postgres.ContainerName)),
 				fmt.Sprintf("%s=%s", "REQUEST_ALLOW_X_FORWARDED_PATH", "true"),
 				fmt.Sprintf("%s=%s", "FILE_SIZE_LIMIT", "52428800"),
@@ -67,6 +56,13 @@ var Storage docker.SupabaseAbstractContainerConstructor = func(cfg *config.Supab
 					"http://127.0.0.1:5000/status",
 				},
 			},
-		}, nil
+		}
+
+		// verify asymmetric tokens, and accept tokens signed with the keys replaced by a rotation until the grace window ends
+		if cfg.Keys.UsesJWKS() {
+			c.Env = append(c.Env, fmt.Sprintf("%s=%s", "JWT_JWKS", cfg.Keys.VerificationJWKS()))
+		}
+
+		return c, nil
 	}
 }
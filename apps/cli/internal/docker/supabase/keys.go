@@ -0,0 +1,43 @@
+package supabase
+
+import (
+	"context"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+)
+
+// KeyConsumersC returns the constructors of every service configured with the jwt secret or the anon/service keys,
+// in the order they should be restarted after a rotation: backends first, then the gateway
+func KeyConsumersC(cfg *config.Supabase) []docker.ContainerConstructor {
+	return []docker.ContainerConstructor{
+		Auth(cfg),
+		Postgrest(cfg),
+		Realtime(cfg),
+		Storage(cfg),
+		Kong(cfg),
+	}
+}
+
+// ApplyKeys recreates every running key consumer, in order, so that it picks up the keys in cfg.
+// Services that are not running are skipped; they pick up the keys when they are next started.
+func ApplyKeys(ctx context.Context, dkr *docker.Docker, cfg *config.Supabase) error {
+	for i, constructor := range KeyConsumersC(cfg) {
+		c, err := constructor()
+		if err != nil {
+			return fmt.Errorf("error creating supabase container %d: %w", i, err)
+		}
+		if running, err := dkr.IsRunning(ctx, c); err != nil {
+			return err
+		} else if !running {
+			logger.Global().Debugf("container %s is not running; skipping", c.Name)
+			continue
+		}
+		logger.Global().Infof("restarting %s with the new keys", c.Name)
+		if err := dkr.Recreate(ctx, c); err != nil {
+			return fmt.Errorf("could not restart %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
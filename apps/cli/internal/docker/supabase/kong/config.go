@@ -0,0 +1,387 @@
+package kong
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Config is a declarative config of kong, in the DB-less mode it runs in
+// (see https://docs.konghq.com/gateway/latest/production/deployment-topologies/db-less-and-declarative-config/)
+type Config struct {
+	FormatVersion        string                `yaml:"_format_version"`
+	Transform            bool                  `yaml:"_transform"`
+	Consumers            []Consumer            `yaml:"consumers"`
+	ACLs                 []ACL                 `yaml:"acls"`
+	BasicAuthCredentials []BasicAuthCredential `yaml:"basicauth_credentials"`
+	Services             []Service             `yaml:"services"`
+}
+
+type Consumer struct {
+	Username           string              `yaml:"username"`
+	KeyAuthCredentials []KeyAuthCredential `yaml:"keyauth_credentials,omitempty"`
+}
+
+type KeyAuthCredential struct {
+	Key string `yaml:"key"`
+}
+
+// ACL puts a consumer in a group, which the acl plugin of a service allows (or not)
+type ACL struct {
+	Consumer string `yaml:"consumer"`
+	Group    string `yaml:"group"`
+}
+
+type BasicAuthCredential struct {
+	Consumer string `yaml:"consumer"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type Service struct {
+	Name     string   `yaml:"name"`
+	Comment  string   `yaml:"_comment,omitempty"`
+	URL      string   `yaml:"url"`
+	Protocol string   `yaml:"protocol,omitempty"` // defaults to the URL's scheme
+	Routes   []Route  `yaml:"routes"`
+	Plugins  []Plugin `yaml:"plugins,omitempty"`
+}
+
+type Route struct {
+	Name      string   `yaml:"name"`
+	StripPath bool     `yaml:"strip_path"`
+	Paths     []string `yaml:"paths"`
+}
+
+// Plugin is a plugin of a service, with its config: one of the *XxxConfig types, matching its name, or nil
+type Plugin struct {
+	Name   string `yaml:"name"`
+	Config any    `yaml:"config,omitempty"`
+}
+
+type CORSConfig struct {
+	Origins []string `yaml:"origins"`
+}
+
+type KeyAuthConfig struct {
+	HideCredentials bool `yaml:"hide_credentials"`
+}
+
+type BasicAuthConfig struct {
+	HideCredentials bool `yaml:"hide_credentials"`
+}
+
+type ACLConfig struct {
+	HideGroupsHeader bool     `yaml:"hide_groups_header"`
+	Allow            []string `yaml:"allow"`
+}
+
+type RequestTransformerConfig struct {
+	Add struct {
+		Headers []string `yaml:"headers"` // as name:value
+	} `yaml:"add"`
+}
+
+type RequestTerminationConfig struct {
+	StatusCode int    `yaml:"status_code"`
+	Message    string `yaml:"message"`
+}
+
+// Credentials are what the consumers of the config authenticate with
+type Credentials struct {
+	AnonKeys          []string // the JWTs of the anon role; the first is current, the others were replaced by a rotation
+	ServiceKeys       []string // the JWTs of the service role, likewise
+	DashboardUsername string
+	DashboardPassword string
+}
+
+// New returns the config that routes the supabase services, adapted from
+// https://github.com/supabase/supabase/blob/ab2e1e8918711364bb8ebd3fe27ce5b913d6deb9/docker/volumes/api/kong.yml.
+// Browsers may call it from the given origins; "*" allows any origin.
+func New(credentials Credentials, origins []string) *Config {
+
+	keys := func(keys []string) []KeyAuthCredential {
+		credentials := make([]KeyAuthCredential, len(keys))
+		for i, key := range keys {
+			credentials[i] = KeyAuthCredential{Key: key}
+		}
+		return credentials
+	}
+
+	cors := Plugin{Name: "cors"}
+	if !slices.Contains(origins, "*") {
+		cors.Config = &CORSConfig{Origins: slices.Clone(origins)}
+	}
+	keyAuth := func(hide bool) Plugin {
+		return Plugin{Name: "key-auth", Config: &KeyAuthConfig{HideCredentials: hide}}
+	}
+	acl := func(groups ...string) Plugin {
+		return Plugin{Name: "acl", Config: &ACLConfig{HideGroupsHeader: true, Allow: groups}}
+	}
+	forbidden := Plugin{Name: "request-termination", Config: &RequestTerminationConfig{StatusCode: 403, Message: "Access is forbidden."}}
+	graphql := &RequestTransformerConfig{}
+	graphql.Add.Headers = []string{"Content-Profile:graphql_public"}
+
+	// service returns a service with a single route
+	service := func(name string, comment string, url string, route string, path string, plugins ...Plugin) Service {
+		return Service{
+			Name:    name,
+			Comment: comment,
+			URL:     url,
+			Routes:  []Route{{Name: route, StripPath: true, Paths: []string{path}}},
+			Plugins: plugins,
+		}
+	}
+
+	realtimeWS := service("realtime-v1-ws", "Realtime: /realtime/v1/* -> ws://realtime:4000/socket/*", "http://realtime-dev.supabase-realtime:4000/socket", "realtime-v1-ws", "/realtime/v1/", cors, keyAuth(false), acl("admin", "anon"))
+	realtimeWS.Protocol = "ws"
+	realtimeREST := service("realtime-v1-rest", "Realtime: /realtime/v1/* -> ws://realtime:4000/socket/*", "http://realtime-dev.supabase-realtime:4000/api", "realtime-v1-rest", "/realtime/v1/api", cors, keyAuth(false), acl("admin", "anon"))
+	realtimeREST.Protocol = "http"
+
+	return &Config{
+		FormatVersion: "2.1",
+		Transform:     true,
+		Consumers: []Consumer{
+			{Username: "DASHBOARD"},
+			{Username: "anon", KeyAuthCredentials: keys(credentials.AnonKeys)},
+			{Username: "service_role", KeyAuthCredentials: keys(credentials.ServiceKeys)},
+		},
+		ACLs: []ACL{
+			{Consumer: "anon", Group: "anon"},
+			{Consumer: "service_role", Group: "admin"},
+		},
+		BasicAuthCredentials: []BasicAuthCredential{
+			{Consumer: "DASHBOARD", Username: credentials.DashboardUsername, Password: credentials.DashboardPassword},
+		},
+		Services: []Service{
+			// open auth routes
+			service("auth-v1-open", "", "http://auth:9999/verify", "auth-v1-open", "/auth/v1/verify", cors),
+			service("auth-v1-open-callback", "", "http://auth:9999/callback", "auth-v1-open-callback", "/auth/v1/callback", cors),
+			service("auth-v1-open-authorize", "", "http://auth:9999/authorize", "auth-v1-open-authorize", "/auth/v1/authorize", cors),
+
+			// secure routes
+			service("auth-v1", "GoTrue: /auth/v1/* -> http://auth:9999/*", "http://auth:9999/", "auth-v1-all", "/auth/v1/", cors, keyAuth(false), acl("admin", "anon")),
+			service("rest-v1", "PostgREST: /rest/v1/* -> http://rest:3000/*", "http://rest:3000/", "rest-v1-all", "/rest/v1/", cors, keyAuth(true), acl("admin", "anon")),
+			service("graphql-v1", "PostgREST: /graphql/v1/* -> http://rest:3000/rpc/graphql", "http://rest:3000/rpc/graphql", "graphql-v1-all", "/graphql/v1", cors, keyAuth(true), Plugin{Name: "request-transformer", Config: graphql}, acl("admin", "anon")),
+			realtimeWS,
+			realtimeREST,
+
+			// the storage server manages its own auth
+			service("storage-v1", "Storage: /storage/v1/* -> http://storage:5000/*", "http://storage:5000/", "storage-v1-all", "/storage/v1/", cors),
+			service("functions-v1", "Edge Functions: /functions/v1/* -> http://functions:9000/*", "http://functions:9000/", "functions-v1-all", "/functions/v1/", cors),
+			service("analytics-v1", "Analytics: /analytics/v1/* -> http://logflare:4000/*", "http://analytics:4000/", "analytics-v1-all", "/analytics/v1/"),
+			service("meta", "pg-meta: /pg/* -> http://pg-meta:8080/*", "http://meta:8080/", "meta-all", "/pg/", keyAuth(false), acl("admin")),
+
+			// the MCP endpoint of studio is blocked; to allow local access, replace the request-termination plugin of
+			// mcp with cors and an ip-restriction allowing the local IPs
+			service("mcp-blocker", "Block direct access to /api/mcp", "http://studio:3000/api/mcp", "mcp-blocker-route", "/api/mcp", forbidden),
+			service("mcp", "MCP: /mcp -> http://studio:3000/api/mcp (local access)", "http://studio:3000/api/mcp", "mcp", "/mcp", forbidden),
+
+			// the dashboard catches every remaining route
+			service("dashboard", "Studio: /* -> http://studio:3000/*", "http://studio:3000/", "dashboard-all", "/", cors, Plugin{Name: "basic-auth", Config: &BasicAuthConfig{HideCredentials: true}}),
+		},
+	}
+}
+
+// Validate returns every problem with the config that kong would reject it (or fail to authenticate) for, joined
+func (this *Config) Validate() error {
+
+	var problems []error
+	problem := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if this.FormatVersion == "" {
+		problem("_format_version is not set")
+	}
+
+	consumers := map[string]bool{}
+	keys := map[string]string{} // consumer, by key
+	for _, c := range this.Consumers {
+		if c.Username == "" {
+			problem("a consumer has no username")
+		} else if consumers[c.Username] {
+			problem("consumer %s is defined more than once", c.Username)
+		}
+		consumers[c.Username] = true
+		for _, credential := range c.KeyAuthCredentials {
+			if credential.Key == "" {
+				problem("consumer %s has an empty key", c.Username)
+			} else if other, ok := keys[credential.Key]; ok {
+				problem("consumer %s has the same key as consumer %s", c.Username, other)
+			} else {
+				keys[credential.Key] = c.Username
+			}
+		}
+	}
+
+	groups := map[string]bool{}
+	for _, acl := range this.ACLs {
+		if !consumers[acl.Consumer] {
+			problem("acl of group %q is for consumer %s, which is not defined", acl.Group, acl.Consumer)
+		}
+		if acl.Group == "" {
+			problem("acl of consumer %s has no group", acl.Consumer)
+		}
+		groups[acl.Group] = true
+	}
+
+	for _, credential := range this.BasicAuthCredentials {
+		if !consumers[credential.Consumer] {
+			problem("basic auth credential is for consumer %s, which is not defined", credential.Consumer)
+		}
+		if credential.Username == "" || credential.Password == "" {
+			problem("basic auth credential of consumer %s has no username or password", credential.Consumer)
+		}
+	}
+
+	services := map[string]bool{}
+	routes := map[string]bool{}
+	for _, s := range this.Services {
+		if s.Name == "" {
+			problem("a service has no name")
+		} else if services[s.Name] {
+			problem("service %s is defined more than once", s.Name)
+		}
+		services[s.Name] = true
+
+		if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("service %s has an invalid url %q: expected an http(s) URL", s.Name, s.URL)
+		}
+		if !slices.Contains([]string{"", "http", "https", "ws", "wss", "grpc", "grpcs", "tcp", "tls", "udp"}, s.Protocol) {
+			problem("service %s has an unknown protocol %q", s.Name, s.Protocol)
+		}
+
+		if len(s.Routes) == 0 {
+			problem("service %s has no routes", s.Name)
+		}
+		for _, r := range s.Routes {
+			if r.Name == "" {
+				problem("a route of service %s has no name", s.Name)
+			} else if routes[r.Name] {
+				problem("route %s is defined more than once", r.Name)
+			}
+			routes[r.Name] = true
+			if len(r.Paths) == 0 {
+				problem("route %s has no paths", r.Name)
+			}
+			for _, p := range r.Paths {
+				if !strings.HasPrefix(p, "/") {
+					problem("route %s has a path that does not start with /: %q", r.Name, p)
+				}
+			}
+		}
+
+		for _, p := range s.Plugins {
+			if err := p.validate(groups); err != nil {
+				problem("plugin %s of service %s: %w", p.Name, s.Name, err)
+			}
+		}
+	}
+
+	return errors.Join(problems...)
+}
+
+// validate checks that the plugin is loaded and that its config matches its name; groups are the groups of the ACLs
+func (this *Plugin) validate(groups map[string]bool) error {
+
+	if !slices.Contains(Plugins, this.Name) {
+		return fmt.Errorf("is not loaded (expected one of %s)", strings.Join(Plugins, ", "))
+	}
+
+	switch config := this.Config.(type) {
+	case nil:
+		if this.Name == "acl" || this.Name == "request-termination" || this.Name == "request-transformer" {
+			return errors.New("has no config")
+		}
+	case *CORSConfig:
+		if this.Name != "cors" {
+			return errors.New("has the config of plugin cors")
+		}
+		if len(config.Origins) == 0 {
+			return errors.New("allows no origins")
+		}
+		for _, origin := range config.Origins {
+			if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+				return fmt.Errorf("invalid origin %q: expected a scheme and host, e.g. https://example.com", origin)
+			}
+		}
+	case *KeyAuthConfig:
+		if this.Name != "key-auth" {
+			return errors.New("has the config of plugin key-auth")
+		}
+	case *BasicAuthConfig:
+		if this.Name != "basic-auth" {
+			return errors.New("has the config of plugin basic-auth")
+		}
+	case *ACLConfig:
+		if this.Name != "acl" {
+			return errors.New("has the config of plugin acl")
+		}
+		if len(config.Allow) == 0 {
+			return errors.New("allows no groups")
+		}
+		for _, group := range config.Allow {
+			if !groups[group] {
+				return fmt.Errorf("allows group %q, which no consumer is in", group)
+			}
+		}
+	case *RequestTransformerConfig:
+		if this.Name != "request-transformer" {
+			return errors.New("has the config of plugin request-transformer")
+		}
+		for _, header := range config.Add.Headers {
+			if name, _, ok := strings.Cut(header, ":"); !ok || name == "" {
+				return fmt.Errorf("invalid header %q: expected name:value", header)
+			}
+		}
+	case *RequestTerminationConfig:
+		if this.Name != "request-termination" {
+			return errors.New("has the config of plugin request-termination")
+		}
+		if config.StatusCode < 100 || config.StatusCode > 599 {
+			return fmt.Errorf("invalid status code %d", config.StatusCode)
+		}
+	default:
+		return fmt.Errorf("has an unknown config %T", this.Config)
+	}
+	return nil
+}
+
+// redacted replaces a secret in the output of Redacted
+const redacted = "<redacted>"
+
+// Redacted returns a copy of the config with its keys and basic auth credentials replaced, e.g. to print it
+func (this *Config) Redacted() *Config {
+	copied := *this
+	copied.Consumers = slices.Clone(this.Consumers)
+	for i, c := range copied.Consumers {
+		copied.Consumers[i].KeyAuthCredentials = make([]KeyAuthCredential, len(c.KeyAuthCredentials))
+		for j := range c.KeyAuthCredentials {
+			copied.Consumers[i].KeyAuthCredentials[j].Key = redacted
+		}
+	}
+	copied.BasicAuthCredentials = slices.Clone(this.BasicAuthCredentials)
+	for i := range copied.BasicAuthCredentials {
+		copied.BasicAuthCredentials[i].Username = redacted
+		copied.BasicAuthCredentials[i].Password = redacted
+	}
+	return &copied
+}
+
+// YAML returns the config as kong reads it
+func (this *Config) YAML() ([]byte, error) {
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(this); err != nil {
+		return nil, fmt.Errorf("could not encode kong config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("could not encode kong config: %w", err)
+	}
+	return []byte(b.String()), nil
+}
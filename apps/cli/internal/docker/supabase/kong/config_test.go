@@ -0,0 +1,130 @@
+package kong
+
+import (
+	"gopkg.in/yaml.v3"
+	"strings"
+	"testing"
+)
+
+var testCredentials = Credentials{
+	AnonKeys:          []string{"anon.jwt"},
+	ServiceKeys:       []string{"service.jwt"},
+	DashboardUsername: "dashboard-user",
+	DashboardPassword: `dashboard"pa$$word'\`,
+}
+
+// decode parses the YAML of a config as kong would, failing the test if it is invalid
+func decode(t *testing.T, config *Config) map[string]any {
+	t.Helper()
+	data, err := config.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := yaml.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("invalid YAML: %v\n%s", err, data)
+	}
+	return decoded
+}
+
+func TestNewIsValid(t *testing.T) {
+	for _, origins := range [][]string{{"https://example.com"}, {"*"}} {
+		if err := New(testCredentials, origins).Validate(); err != nil {
+			t.Errorf("expected the config with origins %v to be valid, got %v", origins, err)
+		}
+	}
+}
+
+func TestSecretsAreWrittenVerbatim(t *testing.T) {
+	decoded := decode(t, New(testCredentials, []string{"https://example.com"}))
+	credential := decoded["basicauth_credentials"].([]any)[0].(map[string]any)
+	if credential["password"] != testCredentials.DashboardPassword {
+		t.Errorf("expected password %q, got %q", testCredentials.DashboardPassword, credential["password"])
+	}
+}
+
+func TestPreviousKeysAreAccepted(t *testing.T) {
+	credentials := testCredentials
+	credentials.AnonKeys = []string{"anon.jwt", "anon.previous.jwt"}
+	credentials.ServiceKeys = []string{"service.jwt", "service.previous.jwt"}
+	config := New(credentials, []string{"*"})
+	if err := config.Validate(); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	for _, c := range config.Consumers {
+		if c.Username == "anon" && (len(c.KeyAuthCredentials) != 2 || c.KeyAuthCredentials[1].Key != "anon.previous.jwt") {
+			t.Errorf("expected anon to accept the previous key, got %+v", c.KeyAuthCredentials)
+		}
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	for _, s := range New(testCredentials, []string{"*"}).Services {
+		for _, p := range s.Plugins {
+			if p.Name == "cors" && p.Config != nil {
+				t.Errorf("expected cors of %s to allow any origin, got %+v", s.Name, p.Config)
+			}
+		}
+	}
+
+	origins := []string{"https://example.com", "http://localhost:3000"}
+	restricted := 0
+	for _, s := range New(testCredentials, origins).Services {
+		for _, p := range s.Plugins {
+			if config, ok := p.Config.(*CORSConfig); ok {
+				restricted++
+				if strings.Join(config.Origins, ",") != strings.Join(origins, ",") {
+					t.Errorf("expected cors of %s to allow %v, got %v", s.Name, origins, config.Origins)
+				}
+			}
+		}
+	}
+	if restricted == 0 {
+		t.Errorf("expected cors to be restricted")
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	credentials := testCredentials
+	credentials.ServiceKeys = []string{"anon.jwt"}
+	credentials.DashboardPassword = ""
+	config := New(credentials, []string{"example.com"})
+	config.Services[0].Routes[0].Paths = []string{"auth/v1/verify"}
+	config.Services[1].Plugins = append(config.Services[1].Plugins, Plugin{Name: "rate-limiting"})
+	config.Services[2].URL = "auth:9999"
+	config.Services[3].Plugins = append(config.Services[3].Plugins, Plugin{Name: "acl", Config: &ACLConfig{Allow: []string{"nobody"}}})
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatalf("expected the config to be invalid")
+	}
+	for _, expected := range []string{
+		"consumer service_role has the same key as consumer anon",
+		"basic auth credential of consumer DASHBOARD has no username or password",
+		`route auth-v1-open has a path that does not start with /: "auth/v1/verify"`,
+		`invalid origin "example.com"`,
+		"plugin rate-limiting of service auth-v1-open-callback: is not loaded",
+		`service auth-v1-open-authorize has an invalid url "auth:9999"`,
+		`plugin acl of service auth-v1: allows group "nobody", which no consumer is in`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected problem %q, got:\n%v", expected, err)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	config := New(testCredentials, []string{"*"})
+	data, err := config.Redacted().YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"anon.jwt", "service.jwt", testCredentials.DashboardUsername, "dashboard\"pa"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("expected %q to be redacted:\n%s", secret, data)
+		}
+	}
+	if config.Consumers[1].KeyAuthCredentials[0].Key != "anon.jwt" || config.BasicAuthCredentials[0].Password != testCredentials.DashboardPassword {
+		t.Errorf("redacting changed the config: %+v", config)
+	}
+}
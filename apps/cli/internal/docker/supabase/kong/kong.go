@@ -1,9 +1,9 @@
 package kong
 
-import (
-	_ "embed"
-)
-
-//go:embed kong.yml
-var ConfigFile []byte
 var ContainerName string = "projdocs-supabase-kong"
+
+// ConfigPath is where kong reads its declarative config from
+const ConfigPath = "/usr/local/kong/kong.yml"
+
+// Plugins are the plugins kong loads; the config may only use these
+var Plugins = []string{"request-transformer", "cors", "key-auth", "acl", "basic-auth", "request-termination", "ip-restriction"}
@@ -1,28 +1,20 @@
 package postgres
 
 import (
-	_ "embed"
+	"embed"
+	"io/fs"
 )
 
 var ContainerName string = "projdocs-supabase-db"
 
-//go:embed realtime.sql
-var RealtimeSQL []byte
+// RootKeyPath is where pgsodium (and therefore vault) reads its root key from
+const RootKeyPath = "/etc/postgresql-custom/pgsodium_root.key"
 
-//go:embed webhooks.sql
-var WebhooksSQL []byte
+// InitDBPath is where the image reads the scripts it runs when it initialises the database from
+const InitDBPath = "/docker-entrypoint-initdb.d"
 
-//go:embed roles.sql
-var RolesSQL []byte
+//go:embed initdb
+var initdb embed.FS
 
-//go:embed jwt.sql
-var JwtSQL []byte
-
-//go:embed _supabase.sql
-var SupabaseSQL []byte
-
-//go:embed logs.sql
-var LogsSQL []byte
-
-//go:embed pooler.sql
-var PoolerSQL []byte
+// InitDB is the tree of scripts written to InitDBPath
+var InitDB, _ = fs.Sub(initdb, "initdb")
@@ -0,0 +1,128 @@
+package supabase
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase/postgres"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"strings"
+)
+
+type vaultSecret struct {
+	ID     string  `json:"id"`
+	Secret *string `json:"secret"`
+}
+
+// psql runs a single SQL command as supabase_admin in the (running) database container
+func psql(ctx context.Context, dkr *docker.Docker, c *docker.Container, sql string) (string, error) {
+	output, err := dkr.ExecInContainer(ctx, c, []string{
+		"psql",
+		"-h", "127.0.0.1",
+		"-U", "supabase_admin",
+		"-d", "postgres",
+		"-v", "ON_ERROR_STOP=1",
+		"-At",
+		"-c", sql,
+	})
+	if err != nil {
+		return output, fmt.Errorf("%v (%s)", err, strings.ReplaceAll(strings.TrimSpace(output), "\n", "\\n"))
+	}
+	return output, nil
+}
+
+// readVaultSecrets returns every vault secret, decrypted with the root key the database is currently running with
+func readVaultSecrets(ctx context.Context, dkr *docker.Docker, c *docker.Container) ([]vaultSecret, error) {
+	output, err := psql(ctx, dkr, c, `SELECT coalesce(json_agg(json_build_object('id', id, 'secret', decrypted_secret)), '[]') FROM vault.decrypted_secrets`)
+	if err != nil {
+		return nil, fmt.Errorf("could not read vault secrets: %w", err)
+	}
+	var all []vaultSecret
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &all); err != nil {
+		return nil, fmt.Errorf("could not parse vault secrets: %w", err)
+	}
+	for _, s := range all {
+		if s.Secret == nil {
+			return nil, fmt.Errorf("vault secret %s could not be decrypted with the current root key", s.ID)
+		}
+	}
+	return all, nil
+}
+
+// writeVaultSecrets re-encrypts every secret with the root key the database is currently running with
+func writeVaultSecrets(ctx context.Context, dkr *docker.Docker, c *docker.Container, all []vaultSecret) error {
+	for _, s := range all {
+		// base64 avoids any quoting issues with arbitrary secret contents
+		encoded := base64.StdEncoding.EncodeToString([]byte(*s.Secret))
+		if _, err := psql(ctx, dkr, c, fmt.Sprintf(
+			`SELECT vault.update_secret('%s'::uuid, convert_from(decode('%s', 'base64'), 'UTF8'))`,
+			strings.ReplaceAll(s.ID, "'", ""),
+			encoded,
+		)); err != nil {
+			return fmt.Errorf("could not re-encrypt vault secret %s: %w", s.ID, err)
+		}
+	}
+	return nil
+}
+
+// setRootKey replaces the root key embedded in the database container definition
+func setRootKey(c *docker.Container, key string) error {
+	for _, file := range c.Embeds {
+		if file.Path == postgres.RootKeyPath {
+			file.Data = []byte(key)
+			return nil
+		}
+	}
+	return fmt.Errorf("container %s does not embed %s", c.Name, postgres.RootKeyPath)
+}
+
+// RotateVaultRootKey swaps the running database over to newKey and re-encrypts every vault secret with it.
+// On failure, the database is restarted with the previous key.
+func RotateVaultRootKey(ctx context.Context, dkr *docker.Docker, cfg *config.Supabase, newKey string) error {
+
+	c, err := Postgres(cfg)()
+	if err != nil {
+		return fmt.Errorf("could not construct database container: %w", err)
+	}
+
+	all, err := readVaultSecrets(ctx, dkr, c)
+	if err != nil {
+		return err
+	}
+	logger.Global().Infof("read %d vault secret(s)", len(all))
+
+	rollback := func(cause error) error {
+		logger.Global().Errorf("vault key rotation failed, restoring previous root key: %v", cause)
+		if err := setRootKey(c, cfg.Keys.PgSodiumEncryption); err != nil {
+			return errors.Join(cause, err)
+		}
+		if err := dkr.Restart(ctx, c); err != nil {
+			return errors.Join(cause, fmt.Errorf("could not restore previous root key: %w", err))
+		}
+		// secrets that were already re-encrypted with the new key must be written back with the previous one
+		if err := writeVaultSecrets(ctx, dkr, c, all); err != nil {
+			return errors.Join(cause, fmt.Errorf("could not restore vault secrets: %w", err))
+		}
+		return cause
+	}
+
+	// restart the database with the new root key
+	if err := setRootKey(c, newKey); err != nil {
+		return err
+	}
+	logger.Global().Infof("restarting database with the new vault root key")
+	if err := dkr.Restart(ctx, c); err != nil {
+		return rollback(fmt.Errorf("could not restart database: %w", err))
+	}
+
+	if err := writeVaultSecrets(ctx, dkr, c, all); err != nil {
+		return rollback(err)
+	}
+	logger.Global().Infof("re-encrypted %d vault secret(s)", len(all))
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package supabase
+
+import (
+	"context"
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/fake"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/images"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker/supabase/postgres"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateVaultRootKeyRollsBack(t *testing.T) {
+	const previous, next = "previous-root-key", "next-root-key"
+	const secrets = `[{"id":"1","secret":"value"}]`
+
+	for _, test := range []struct {
+		name    string
+		fail    func(engine *fake.Engine)
+		wantErr string
+	}{
+		{
+			name: "database does not restart",
+			fail: func(engine *fake.Engine) {
+				engine.Fail("ContainerRestart", postgres.ContainerName, context.DeadlineExceeded)
+			},
+			wantErr: "could not restart database",
+		},
+		{
+			name: "secrets cannot be re-encrypted",
+			fail: func(engine *fake.Engine) {
+				// psql fails with the new key, and works again once the previous key is restored
+				restarts := 0
+				engine.OnCall("ContainerRestart", func(string) {
+					restarts++
+					if restarts == 1 {
+						engine.SetExecResult(postgres.ContainerName, fake.ExecResult{Output: "ERROR: invalid key", ExitCode: 1})
+					} else {
+						engine.SetExecResult(postgres.ContainerName, fake.ExecResult{Output: secrets})
+					}
+				})
+			},
+			wantErr: "could not re-encrypt vault secret 1",
+		},
+	} {
+		engine := fake.New()
+		image := images.Image{Image: images.Get("postgres").Image}
+		engine.AddImage(image.Image)
+		engine.AddImageFile(image.Image, "/etc/passwd", []byte("postgres:x:101:102::/var/lib/postgresql:/bin/sh\n"))
+		engine.SetExecResult(postgres.ContainerName, fake.ExecResult{Output: secrets})
+
+		// the database is running with the previous key, as serve started it
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		cfg := &config.Supabase{
+			Images: config.ImagesConfig{"postgres": image},
+			Keys:   config.KeysConfig{PgSodiumEncryption: previous},
+		}
+		dkr := docker.NewClient(engine)
+		db, err := Postgres(cfg)()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dkr.Recreate(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+		test.fail(engine)
+
+		err = RotateVaultRootKey(ctx, dkr, cfg, next)
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: expected an error containing %q, got %v", test.name, test.wantErr, err)
+		}
+
+		// the database is running with the previous key again
+		c, _ := engine.Container(postgres.ContainerName)
+		if key := string(c.Files[postgres.RootKeyPath].Data); key != previous {
+			t.Errorf("%s: expected the previous root key to be restored, got %q", test.name, key)
+		}
+	}
+}
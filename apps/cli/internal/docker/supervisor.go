@@ -0,0 +1,341 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/moby/moby/api/types/events"
+	"github.com/moby/moby/client"
+	"github.com/projdocs/projdocs/apps/cli/internal/logger"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceState is the state of a supervised container
+type ServiceState string
+
+const (
+	ServiceStarting   ServiceState = "starting"   // not supervised yet
+	ServiceRunning    ServiceState = "running"    // running (and ready, if restarted by the supervisor)
+	ServiceRestarting ServiceState = "restarting" // crashed, and being restarted
+	ServiceFailed     ServiceState = "failed"     // crashed too often, and no longer restarted
+	ServiceStopped    ServiceState = "stopped"    // stopped on purpose (e.g. by another command), and not restarted
+)
+
+// ServiceStatus is the status of a supervised container
+type ServiceStatus struct {
+	Name      string       `json:"name"`
+	State     ServiceState `json:"state"`
+	Since     time.Time    `json:"since"`
+	Restarts  int          `json:"restarts"`
+	LastError string       `json:"last_error,omitempty"`
+}
+
+// SupervisorPolicy is how the supervisor restarts crashed containers
+type SupervisorPolicy struct {
+	InitialBackoff  time.Duration // delay before restarting a crashed container; doubles with each crash in the window
+	MaxBackoff      time.Duration // bounds the delay
+	CrashLoopLimit  int           // number of crashes within the window after which a container is no longer restarted
+	CrashLoopWindow time.Duration
+}
+
+// DefaultSupervisorPolicy gives up on a container that crashes 5 times within 10 minutes
+var DefaultSupervisorPolicy = SupervisorPolicy{
+	InitialBackoff:  time.Second,
+	MaxBackoff:      time.Minute,
+	CrashLoopLimit:  5,
+	CrashLoopWindow: 10 * time.Minute,
+}
+
+// supervisorPollInterval is how often a restart checks whether the container's dependencies are running
+const supervisorPollInterval = 250 * time.Millisecond
+
+// Supervisor watches the events of running containers and restarts those that crash, once their dependencies
+// are running; a container that crashes too often (see SupervisorPolicy) is given up on. A container that exits
+// after it was killed through the API (by a stop, restart or removal, e.g. from `projdocs restart`) did not crash,
+// and is left to whoever stopped it.
+type Supervisor struct {
+	docker     *Docker
+	containers map[string]*Container
+	policy     SupervisorPolicy
+	failed     chan ServiceStatus
+	wg         sync.WaitGroup
+
+	mu      sync.Mutex
+	status  map[string]*ServiceStatus
+	crashes map[string][]time.Time
+	killed  map[string]bool // containers killed through the API, whose next exit is not a crash
+}
+
+// NewSupervisor returns a supervisor of containers started by Run; it does nothing until it is run
+func NewSupervisor(docker *Docker, containers []*Container, policy SupervisorPolicy) *Supervisor {
+	s := &Supervisor{
+		docker:     docker,
+		containers: make(map[string]*Container),
+		policy:     policy,
+		failed:     make(chan ServiceStatus, len(containers)),
+		status:     make(map[string]*ServiceStatus),
+		crashes:    make(map[string][]time.Time),
+		killed:     make(map[string]bool),
+	}
+	for _, c := range containers {
+		s.containers[c.Name] = c
+		s.status[c.Name] = &ServiceStatus{Name: c.Name, State: ServiceStarting, Since: time.Now()}
+	}
+	return s
+}
+
+// Status returns the status of every supervised container, sorted by name
+func (this *Supervisor) Status() []ServiceStatus {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	var statuses []ServiceStatus
+	for _, status := range this.status {
+		statuses = append(statuses, *status)
+	}
+	slices.SortFunc(statuses, func(a, b ServiceStatus) int { return strings.Compare(a.Name, b.Name) })
+	return statuses
+}
+
+// Failed receives the status of each container the supervisor gives up on
+func (this *Supervisor) Failed() <-chan ServiceStatus {
+	return this.failed
+}
+
+// setState updates a container's status, logging the change; the lock must be held
+func (this *Supervisor) setState(name string, state ServiceState, lastError string) {
+	status := this.status[name]
+	if status.State == state && lastError == "" {
+		return
+	}
+	logger.Global().Debugf("service %s: %s -> %s", name, status.State, state)
+	status.State, status.Since = state, time.Now()
+	if lastError != "" {
+		status.LastError = lastError
+	}
+}
+
+// Run supervises the containers until the context is done, then waits for restarts in progress to give up
+func (this *Supervisor) Run(ctx context.Context) {
+
+	logger.Global().Debugf("supervising %d containers", len(this.containers))
+	for ctx.Err() == nil {
+
+		// missed events are made up for by inspecting every container
+		this.reconcile(ctx)
+
+		res := this.docker.api.Events(ctx, client.EventsListOptions{
+			Filters: make(client.Filters).
+				Add("type", string(events.ContainerEventType)).
+				Add("label", ProjectLabel+"="+ProjectName).
+				Add("event", string(events.ActionKill), string(events.ActionDie), string(events.ActionStart)),
+		})
+		err := this.watch(ctx, res)
+		if ctx.Err() != nil {
+			break
+		}
+		logger.Global().Warnf("lost the docker event stream (%v); resubscribing", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(this.policy.InitialBackoff):
+		}
+	}
+
+	this.wg.Wait()
+	logger.Global().Debugf("stopped supervising containers")
+}
+
+// watch handles events until the stream ends
+func (this *Supervisor) watch(ctx context.Context, res client.EventsResult) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-res.Err:
+			if err == nil {
+				err = errors.New("stream closed")
+			}
+			return err
+		case msg := <-res.Messages:
+			name := msg.Actor.Attributes["name"]
+			if _, ok := this.containers[name]; !ok {
+				continue
+			}
+			switch msg.Action {
+			case events.ActionKill:
+				this.mu.Lock()
+				this.killed[name] = true
+				this.mu.Unlock()
+			case events.ActionDie:
+				if this.stopped(name) {
+					continue
+				}
+				this.crashed(ctx, name, fmt.Sprintf("exited with code %s", msg.Actor.Attributes["exitCode"]))
+			case events.ActionStart:
+				this.mu.Lock()
+				delete(this.killed, name)
+				if state := this.status[name].State; state == ServiceFailed || state == ServiceStopped {
+					logger.Global().Infof("service %s was started again; supervising it again", name)
+					this.crashes[name] = nil
+					this.setState(name, ServiceRunning, "")
+				}
+				this.mu.Unlock()
+			}
+		}
+	}
+}
+
+// stopped reports whether a container that exited had been killed through the API, and if so marks it as stopped
+// unless it is being restarted by the supervisor
+func (this *Supervisor) stopped(name string) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if !this.killed[name] {
+		return false
+	}
+	delete(this.killed, name)
+	if state := this.status[name].State; state != ServiceRestarting && state != ServiceFailed {
+		logger.Global().Infof("service %s was stopped; it is not restarted until it is started again", name)
+		this.setState(name, ServiceStopped, "")
+	}
+	return true
+}
+
+// reconcile inspects every container that is not being restarted, treating those that are not running as crashed
+func (this *Supervisor) reconcile(ctx context.Context) {
+	for name, c := range this.containers {
+		this.mu.Lock()
+		state := this.status[name].State
+		this.mu.Unlock()
+		if state == ServiceRestarting || state == ServiceFailed || state == ServiceStopped {
+			continue
+		}
+
+		inspect, err := this.docker.api.ContainerInspect(ctx, c.Name, client.ContainerInspectOptions{})
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, ErrNotFound):
+			this.crashed(ctx, name, "container was removed")
+		case err != nil:
+			logger.Global().Warnf("could not inspect service %s: %v", name, err)
+		case inspect.Container.State == nil || !inspect.Container.State.Running:
+			this.crashed(ctx, name, "container is not running")
+		default:
+			this.mu.Lock()
+			this.setState(name, ServiceRunning, "")
+			this.mu.Unlock()
+		}
+	}
+}
+
+// crashed records a crash of a container, unless it is being restarted already or has been given up on
+func (this *Supervisor) crashed(ctx context.Context, name string, reason string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if state := this.status[name].State; state == ServiceRestarting || state == ServiceFailed || state == ServiceStopped {
+		return
+	}
+	this.recordCrash(ctx, name, reason)
+}
+
+// recordCrash restarts a crashed container after a backoff, or gives up on it if it has crashed too often; the
+// lock must be held
+func (this *Supervisor) recordCrash(ctx context.Context, name string, reason string) {
+
+	now := time.Now()
+	crashes := slices.DeleteFunc(this.crashes[name], func(t time.Time) bool { return now.Sub(t) > this.policy.CrashLoopWindow })
+	crashes = append(crashes, now)
+	this.crashes[name] = crashes
+
+	if len(crashes) >= this.policy.CrashLoopLimit {
+		this.setState(name, ServiceFailed, reason)
+		logger.Global().Errorf("service %s crashed %d times within %s (%s); it is no longer restarted", name, len(crashes), this.policy.CrashLoopWindow, reason)
+		select {
+		case this.failed <- *this.status[name]:
+		default:
+		}
+		return
+	}
+
+	backoff := this.policy.InitialBackoff << (len(crashes) - 1)
+	if backoff > this.policy.MaxBackoff || backoff <= 0 {
+		backoff = this.policy.MaxBackoff
+	}
+	this.setState(name, ServiceRestarting, reason)
+	logger.Global().Warnf("service %s crashed (%s); restarting it in %s", name, reason, backoff)
+
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+		if err := this.restart(ctx, this.containers[name], backoff); err != nil && ctx.Err() == nil {
+			this.mu.Lock()
+			defer this.mu.Unlock()
+			this.recordCrash(ctx, name, fmt.Sprintf("restart failed: %v", err))
+		}
+	}()
+}
+
+// restart waits for the backoff and for the container's dependencies to run, then starts the container (or
+// recreates it, if it was removed), waits for it to become ready and runs its after-start hook
+func (this *Supervisor) restart(ctx context.Context, c *Container, backoff time.Duration) error {
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(backoff):
+	}
+
+	for _, dep := range c.DependsOn {
+		for {
+			this.mu.Lock()
+			state := this.status[dep].State
+			this.mu.Unlock()
+			if state == ServiceRunning {
+				break
+			}
+			if state == ServiceFailed {
+				return fmt.Errorf("dependency %s failed", dep)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(supervisorPollInterval):
+			}
+		}
+	}
+
+	inspect, err := this.docker.api.ContainerInspect(ctx, c.Name, client.ContainerInspectOptions{})
+	switch {
+	case errors.Is(err, ErrNotFound):
+		logger.Global().Infof("service %s was removed; recreating it", c.Name)
+		if err := this.docker.Recreate(ctx, c); err != nil {
+			return err
+		}
+	case err != nil:
+		return fmt.Errorf("could not inspect container: %w", err)
+	default:
+		if inspect.Container.State != nil && inspect.Container.State.Running {
+			logger.Global().Infof("service %s was restarted by someone else", c.Name)
+		} else if _, err := this.docker.api.ContainerStart(ctx, c.Name, client.ContainerStartOptions{}); err != nil {
+			return fmt.Errorf("could not start container: %w", err)
+		}
+		if err := this.docker.waitReady(ctx, c); err != nil {
+			return err
+		}
+		if c.AfterStart != nil {
+			if _, err := c.AfterStart(ctx, this.docker, c); err != nil {
+				return fmt.Errorf("after-start hook: %w", err)
+			}
+		}
+	}
+
+	this.mu.Lock()
+	this.status[c.Name].Restarts++
+	this.setState(c.Name, ServiceRunning, "")
+	this.mu.Unlock()
+	logger.Global().Infof("restarted service %s", c.Name)
+	return nil
+}
@@ -0,0 +1,213 @@
+package docker
+
+import (
+	"context"
+	"github.com/moby/moby/client"
+	"testing"
+	"time"
+)
+
+var testSupervisorPolicy = SupervisorPolicy{
+	InitialBackoff:  10 * time.Millisecond,
+	MaxBackoff:      50 * time.Millisecond,
+	CrashLoopLimit:  3,
+	CrashLoopWindow: time.Minute,
+}
+
+// supervise runs containers, then supervises them until the test ends
+func supervise(t *testing.T, dkr *Docker, containers ...*Container) *Supervisor {
+	t.Helper()
+	if err := run(t, dkr, containers...); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	supervisor := NewSupervisor(dkr, containers, testSupervisorPolicy)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		supervisor.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	eventually(t, "services are running", func() bool {
+		for _, status := range supervisor.Status() {
+			if status.State != ServiceRunning {
+				return false
+			}
+		}
+		return true
+	})
+	return supervisor
+}
+
+// eventually fails the test if a condition does not become true within a few seconds
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func statusOf(supervisor *Supervisor, name string) ServiceStatus {
+	for _, status := range supervisor.Status() {
+		if status.Name == name {
+			return status
+		}
+	}
+	return ServiceStatus{}
+}
+
+func TestSupervisorRestartsCrashedContainer(t *testing.T) {
+	dkr, engine := newTestDocker()
+	supervisor := supervise(t, dkr, testContainer(engine, "realtime"))
+
+	engine.Crash("realtime", 1)
+	eventually(t, "realtime is restarted", func() bool {
+		status := statusOf(supervisor, "realtime")
+		return status.State == ServiceRunning && status.Restarts == 1
+	})
+	if status := statusOf(supervisor, "realtime"); status.LastError != "exited with code 1" {
+		t.Errorf("expected the crash to be recorded, got %+v", status)
+	}
+	if c, _ := engine.Container("realtime"); !c.Running || c.Started != 2 {
+		t.Errorf("expected realtime to be started twice and running, got %+v", c)
+	}
+}
+
+func TestSupervisorRestartsDependenciesFirst(t *testing.T) {
+	dkr, engine := newTestDocker()
+	supervise(t, dkr, testContainer(engine, "db"), testContainer(engine, "auth", "db"))
+
+	// both are due for a restart at once, but auth has to wait for db
+	engine.Crash("db", 1)
+	engine.Crash("auth", 1)
+	eventually(t, "both are restarted", func() bool {
+		db, _ := engine.Container("db")
+		auth, _ := engine.Container("auth")
+		return db.Started == 2 && auth.Started == 2
+	})
+
+	calls := engine.Calls()
+	lastStart := func(name string) int {
+		last := -1
+		for i, call := range calls {
+			if call == "ContainerStart "+name {
+				last = i
+			}
+		}
+		return last
+	}
+	if lastStart("db") > lastStart("auth") {
+		t.Errorf("auth was restarted before db: %v", calls)
+	}
+}
+
+func TestSupervisorGivesUpOnCrashLoop(t *testing.T) {
+	dkr, engine := newTestDocker()
+	supervisor := supervise(t, dkr, testContainer(engine, "auth"))
+
+	// every restart crashes right away
+	engine.OnCall("ContainerStart", func(name string) {
+		engine.Crash(name, 2)
+	})
+	engine.Crash("auth", 2)
+
+	select {
+	case status := <-supervisor.Failed():
+		if status.Name != "auth" || status.State != ServiceFailed {
+			t.Errorf("expected auth to fail, got %+v", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("supervisor did not give up: %+v", supervisor.Status())
+	}
+	if c, _ := engine.Container("auth"); c.Started != 1+testSupervisorPolicy.CrashLoopLimit-1 {
+		t.Errorf("expected %d restarts, got %d", testSupervisorPolicy.CrashLoopLimit-1, c.Started-1)
+	}
+}
+
+func TestSupervisorIgnoresContainersRestartedByOthers(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "kong")
+	supervisor := supervise(t, dkr, c)
+
+	// e.g. a key rotation restarting kong from another process
+	if err := dkr.Restart(context.Background(), c); err != nil {
+		t.Fatalf("restart failed: %v", err)
+	}
+	eventually(t, "kong is running again", func() bool {
+		return statusOf(supervisor, "kong").State == ServiceRunning
+	})
+	time.Sleep(5 * testSupervisorPolicy.InitialBackoff)
+	if c, _ := engine.Container("kong"); c.Started != 2 {
+		t.Errorf("expected kong to be started twice, got %d", c.Started)
+	}
+}
+
+func TestSupervisorLeavesStoppedContainers(t *testing.T) {
+	dkr, engine := newTestDocker()
+	supervisor := supervise(t, dkr, testContainer(engine, "kong"))
+
+	// e.g. `docker stop` from another process
+	if _, err := engine.ContainerStop(context.Background(), "kong", client.ContainerStopOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "kong is stopped", func() bool {
+		return statusOf(supervisor, "kong").State == ServiceStopped
+	})
+	time.Sleep(5 * testSupervisorPolicy.InitialBackoff)
+	if c, _ := engine.Container("kong"); c.Running || c.Started != 1 {
+		t.Errorf("expected kong to stay stopped, got %+v", c)
+	}
+
+	if _, err := engine.ContainerStart(context.Background(), "kong", client.ContainerStartOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "kong is supervised again", func() bool {
+		return statusOf(supervisor, "kong").State == ServiceRunning
+	})
+	engine.Crash("kong", 1)
+	eventually(t, "kong is restarted", func() bool {
+		return statusOf(supervisor, "kong").Restarts == 1
+	})
+}
+
+func TestSupervisorRecreatesRemovedContainer(t *testing.T) {
+	dkr, engine := newTestDocker()
+	c := testContainer(engine, "auth")
+	if err := run(t, dkr, c); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	engine.Crash("auth", 1)
+	if _, err := engine.ContainerRemove(context.Background(), "auth", client.ContainerRemoveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	supervisor := NewSupervisor(dkr, []*Container{c}, testSupervisorPolicy)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		supervisor.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	eventually(t, "auth is recreated", func() bool {
+		status := statusOf(supervisor, "auth")
+		return status.State == ServiceRunning && status.Restarts == 1
+	})
+	if created, ok := engine.Container("auth"); !ok || !created.Running {
+		t.Errorf("expected auth to be recreated and running, got %+v", created)
+	}
+	if status := statusOf(supervisor, "auth"); status.LastError != "container was removed" {
+		t.Errorf("expected the removal to be recorded, got %+v", status)
+	}
+}
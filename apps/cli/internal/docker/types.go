@@ -10,18 +10,42 @@ import (
 	"github.com/projdocs/projdocs/apps/cli/internal/config"
 	net "github.com/projdocs/projdocs/apps/cli/internal/docker/network"
 	"github.com/projdocs/projdocs/apps/cli/pkg"
+	"io/fs"
 	"net/netip"
+	"os"
+	"slices"
+	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	// ProjectLabel is the label that marks the containers of an instance, with ProjectName as its value
+	ProjectLabel = "com.docker.compose.project"
+	ProjectName  = "projdocs"
+
+	// ServiceLabel, AliasesLabel and DependsOnLabel record a container's service name, (comma-separated) aliases
+	// and dependencies, so that the containers of an instance can be managed without its definitions
+	ServiceLabel   = "com.projdocs.service"
+	AliasesLabel   = "com.projdocs.aliases"
+	DependsOnLabel = "com.projdocs.depends-on"
 )
 
 type Docker struct {
-	api  *client.Client
-	lock sync.Mutex
+	api           Engine
+	runtime       Runtime
+	lock          sync.Mutex
+	pullReporter  PullReporter
+	trustedImages map[string]string
 }
 
-func NewClient(api *client.Client) *Docker {
+// NewClient returns a client for the given engine; the engine's errors are classified (see Classify)
+func NewClient(api Engine) *Docker {
 	return &Docker{
-		api: api,
+		api:           classifyingEngine{api: api},
+		runtime:       Runtime{Backend: BackendDocker, Host: client.DefaultDockerHost},
+		pullReporter:  LogPullProgress(),
+		trustedImages: map[string]string{},
 	}
 }
 
@@ -35,25 +59,44 @@ type PortBindingMap struct {
 	ContainerPort uint16
 }
 
+// EmbeddedFile is a file, or a dir tree, written into a container before it starts (see Files)
 type EmbeddedFile struct {
-	Data []byte
-	Path string
+	Data      []byte
+	Path      string
+	Tree      fs.FS            // if set, a dir tree written at Path instead of Data
+	Mode      os.FileMode      // of the file, or each file of the tree; defaults to 0644
+	Uid, Gid  int              // owner of the file, or the tree; defaults to root
+	User      string           // if set, the owner as a user (and optionally ":group") of the container, as for COPY --chown; overrides Uid and Gid
+	Template  *config.Supabase // if set, Data (or each file of the tree) is a text/template rendered against it
+	Sensitive bool             // e.g. a key, which exports must keep secret
 }
 
 type Container struct {
 	created *client.ContainerCreateResult
 	started *client.ContainerStartResult
+	adopted bool // found running (e.g. left by an earlier serve --detach) rather than created; Stop leaves it running
 
 	Name        string
+	Aliases     []string // short names of the service, e.g. for the logs command; the first is shown in its place
 	Image       string
+	Digest      string // if set, the image must match this sha256 digest
 	Embeds      []*EmbeddedFile
 	Mounts      []mount.Mount
 	Ports       []*PortBindingMap
+	Expose      []uint16 // ports other services connect to, which need not be published; e.g. for exports
 	Entrypoint  []string
 	Command     []string
 	Env         []string
 	HealthCheck *container.HealthConfig
+	Readiness   *Readiness // how to wait for the container to become ready; see Readiness for the defaults
 	AfterStart  func(ctx context.Context, docker *Docker, container *Container) (string, error)
+
+	// AfterStartJob is a command that does what AfterStart does from another container with the same image and
+	// environment (e.g. a Kubernetes job), so that exports can run it; nil if it cannot be run that way
+	AfterStartJob []string
+
+	// DependsOn names the containers that must be ready (and have run their after-start hook) before this one starts
+	DependsOn []string
 }
 
 func (this *Container) GetID() string {
@@ -63,6 +106,39 @@ func (this *Container) GetID() string {
 	return this.created.ID
 }
 
+// ServiceName returns the short name of the container's service (its first alias), or its name
+func (this *Container) ServiceName() string {
+	if len(this.Aliases) > 0 {
+		return this.Aliases[0]
+	}
+	return this.Name
+}
+
+// FindContainers returns the containers with the given names or aliases, in the order given; every container if
+// no names are given
+func FindContainers(containers []*Container, names ...string) ([]*Container, error) {
+	if len(names) == 0 {
+		return containers, nil
+	}
+	var found []*Container
+	for _, name := range names {
+		i := slices.IndexFunc(containers, func(c *Container) bool {
+			return c.Name == name || slices.Contains(c.Aliases, name)
+		})
+		if i < 0 {
+			var known []string
+			for _, c := range containers {
+				known = append(known, c.ServiceName())
+			}
+			return nil, fmt.Errorf("unknown service %q (services: %s)", name, strings.Join(known, ", "))
+		}
+		if !slices.Contains(found, containers[i]) {
+			found = append(found, containers[i])
+		}
+	}
+	return found, nil
+}
+
 type ContainerConstructor func() (*Container, error)
 
 type SupabaseAbstractContainerConstructor func(*config.Supabase) ContainerConstructor
@@ -85,11 +161,14 @@ func (c *Container) GetContainerCreateOptions() (*client.ContainerCreateOptions,
 			Entrypoint:   c.Entrypoint,
 			Cmd:          c.Command,
 			Env:          c.Env,
-			Healthcheck:  c.HealthCheck,
+			Healthcheck:  c.startupHealthCheck(),
 			ExposedPorts: exposedPorts,
 			Labels: map[string]string{
-				"com.docker.compose.project": "projdocs",
-				"com.projdocs.version":       pkg.Version,
+				ProjectLabel:           ProjectName,
+				ServiceLabel:           c.ServiceName(),
+				AliasesLabel:           strings.Join(c.Aliases, ","),
+				DependsOnLabel:         strings.Join(c.DependsOn, ","),
+				"com.projdocs.version": pkg.Version,
 			},
 		},
 		HostConfig: &container.HostConfig{
@@ -108,6 +187,28 @@ func (c *Container) GetContainerCreateOptions() (*client.ContainerCreateOptions,
 	}, nil
 }
 
+// startupHealthCheck returns the container's health check, probing every second while it starts (unless configured
+// otherwise), so that dependent containers can be started as soon as it is healthy
+func (c *Container) startupHealthCheck() *container.HealthConfig {
+	if c.HealthCheck == nil {
+		return nil
+	}
+	hc := *c.HealthCheck
+	if hc.StartPeriod == 0 {
+		hc.StartPeriod = hc.Interval * time.Duration(max(hc.Retries, 1))
+	}
+	if hc.StartInterval == 0 {
+		hc.StartInterval = time.Second
+	}
+	return &hc
+}
+
+// healthTimeout returns how long a container may take to become healthy
+func (c *Container) healthTimeout() time.Duration {
+	hc := c.startupHealthCheck()
+	return hc.StartPeriod + (hc.Interval+hc.Timeout)*time.Duration(max(hc.Retries, 1)+1)
+}
+
 func (c *Container) GetPortBindings() (network.PortMap, error) {
 
 	bindings := network.PortMap{}
@@ -7,89 +7,74 @@ import (
 	"fmt"
 	"github.com/moby/moby/api/pkg/stdcopy"
 	"github.com/moby/moby/client"
-	"path"
 	"strings"
 	"time"
 )
 
-// copyToContainer copies a single file's contents into a docker container at file.Path.
-// It creates any missing parent directories with mode 0755 and writes the file as 0644.
-// Ownership will be the container default (usually root:root).
-func (this *Docker) copyToContainer(
-	ctx context.Context,
-	c *Container,
-	file *EmbeddedFile,
-) (*client.CopyToContainerResult, error) {
-	if file.Path == "" || !strings.HasPrefix(file.Path, "/") {
-		return nil, fmt.Errorf("container path must be absolute, got %q", file.Path)
-	}
+// copyToContainer writes the embedded files of a container (see EmbeddedFile.Files) into it, in a single tar.
+// Missing parent dirs are created by the engine (as root, with mode 0755); each file (and dir of a tree) is
+// written with its mode and owner.
+func (this *Docker) copyToContainer(ctx context.Context, c *Container) error {
 
-	// BuildE a tar that places the file at its absolute path by extracting under "/"
-	rel := strings.TrimPrefix(file.Path, "/")
-	if rel == "" || rel == "." || strings.Contains(rel, "..") {
-		return nil, fmt.Errorf("invalid container path %q", file.Path)
+	files, err := c.EmbeddedFiles()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
 	}
-	parent := path.Dir(rel) // e.g. "etc/postgresql-custom"
-	base := path.Base(rel)  // e.g. "postgresql.custom.conf"
 
+	var owners *owners
 	var buf bytes.Buffer
 	tw := tar.NewWriter(&buf)
 	now := time.Now()
+	for _, file := range files {
 
-	// Emit mkdir -p style directory headers (0755)
-	if parent != "." && parent != "/" {
-		parts := strings.Split(parent, "/")
-		cur := ""
-		for _, seg := range parts {
-			if seg == "" {
-				continue
+		uid, gid := file.Uid, file.Gid
+		if file.User != "" {
+			if owners == nil {
+				if owners, err = this.readOwners(ctx, c); err != nil {
+					return err
+				}
 			}
-			if cur == "" {
-				cur = seg
-			} else {
-				cur = cur + "/" + seg
-			}
-			hdr := &tar.Header{
-				Name:     cur + "/", // relative dir entry
-				Typeflag: tar.TypeDir,
-				Mode:     0o755, // drwxr-xr-x
-				ModTime:  now,
-			}
-			if err := tw.WriteHeader(hdr); err != nil {
-				_ = tw.Close()
-				return nil, fmt.Errorf("write dir header %q: %w", cur, err)
+			if uid, gid, err = owners.resolve(file.User); err != nil {
+				return fmt.Errorf("owner of %s: %w", file.Path, err)
 			}
 		}
-	}
 
-	// File header at full relative path (parent/base) with 0644
-	fhdr := &tar.Header{
-		Name:     path.Join(parent, base),
-		Typeflag: tar.TypeReg,
-		Mode:     0o644, // -rw-r--r--
-		Size:     int64(len(file.Data)),
-		ModTime:  now,
-	}
-	if err := tw.WriteHeader(fhdr); err != nil {
-		_ = tw.Close()
-		return nil, fmt.Errorf("write file header %q: %w", file.Path, err)
-	}
-	if _, err := tw.Write(file.Data); err != nil {
-		_ = tw.Close()
-		return nil, fmt.Errorf("write file data %q: %w", file.Path, err)
+		// relative to "/", where the tar is extracted
+		hdr := &tar.Header{
+			Name:    strings.TrimPrefix(file.Path, "/"),
+			Mode:    int64(file.Mode.Perm()),
+			Uid:     uid,
+			Gid:     gid,
+			ModTime: now,
+		}
+		if file.Mode.IsDir() {
+			hdr.Name += "/"
+			hdr.Typeflag = tar.TypeDir
+		} else {
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(file.Data))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("write header %q: %w", file.Path, err)
+		}
+		if _, err := tw.Write(file.Data); err != nil {
+			return fmt.Errorf("write file data %q: %w", file.Path, err)
+		}
 	}
 	if err := tw.Close(); err != nil {
-		return nil, fmt.Errorf("close tar: %w", err)
+		return fmt.Errorf("close tar: %w", err)
 	}
 
-	// Extract under "/" so the tar's relative paths land at absolute locations
-	cpy, cpyErr := this.api.CopyToContainer(ctx, c.GetID(), client.CopyToContainerOptions{
+	_, err = this.api.CopyToContainer(ctx, c.GetID(), client.CopyToContainerOptions{
 		DestinationPath:           "/",
 		Content:                   bytes.NewReader(buf.Bytes()),
 		AllowOverwriteDirWithFile: true,
-		CopyUIDGID:                false, // do NOT preserve uid/gid from headers; use container defaults
+		CopyUIDGID:                false, // keep the owners of the headers, rather than the container's user
 	})
-	return &cpy, cpyErr
+	return err
 }
 
 // ExecInContainer runs "cmd" inside container cid and streams output to stdout/stderr
@@ -0,0 +1,46 @@
+package encoders
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+)
+
+// prefixColors are the colors of prefixes, assigned in order
+var prefixColors = []*color.Color{
+	color.New(color.FgHiCyan),
+	color.New(color.FgHiMagenta),
+	color.New(color.FgHiGreen),
+	color.New(color.FgHiYellow),
+	color.New(color.FgHiBlue),
+	color.New(color.FgCyan),
+	color.New(color.FgMagenta),
+	color.New(color.FgGreen),
+}
+
+// PrefixEncoder prefixes interleaved lines (e.g. the logs of several services) with their source's name,
+// padded to the longest name and colored per source
+type PrefixEncoder struct {
+	width  int
+	colors map[string]*color.Color
+}
+
+func NewPrefixEncoder(names ...string) *PrefixEncoder {
+	e := &PrefixEncoder{colors: make(map[string]*color.Color)}
+	for i, name := range names {
+		e.width = max(e.width, len(name))
+		e.colors[name] = prefixColors[i%len(prefixColors)]
+	}
+	return e
+}
+
+// EncodeLine returns a line with its source's prefix; lines written to stderr are marked in red
+func (e *PrefixEncoder) EncodeLine(name string, stderr bool, line string) string {
+	prefix := color.New(color.Reset)
+	if c, ok := e.colors[name]; ok {
+		prefix = c
+	}
+	if stderr {
+		line = color.HiRedString(line)
+	}
+	return fmt.Sprintf("%s %s\n", prefix.Sprintf("%-*s |", e.width, name), line)
+}
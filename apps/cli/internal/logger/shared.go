@@ -44,3 +44,14 @@ func Global() *zap.SugaredLogger {
 	})
 	return global
 }
+
+// LogToFile additionally writes every entry (as JSON) to a file, which is appended to; call the returned func to close it
+func LogToFile(file string) (func() error, error) {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("could not open log file (%s): %w", file, err)
+	}
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(f), level)
+	global = zap.New(zapcore.NewTee(Global().Desugar().Core(), core)).Sugar()
+	return f.Close, nil
+}
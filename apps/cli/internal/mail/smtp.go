@@ -0,0 +1,269 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timeout bounds a whole SMTP conversation
+const Timeout = 30 * time.Second
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Transcript records an SMTP conversation; credentials are redacted
+type Transcript struct {
+	Lines []string
+}
+
+func (t *Transcript) client(line string) {
+	t.Lines = append(t.Lines, "C: "+line)
+}
+
+func (t *Transcript) server(code int, msg string) {
+	for _, line := range strings.Split(msg, "\n") {
+		t.Lines = append(t.Lines, fmt.Sprintf("S: %d %s", code, line))
+	}
+}
+
+func (t *Transcript) note(format string, args ...any) {
+	t.Lines = append(t.Lines, "* "+fmt.Sprintf(format, args...))
+}
+
+// String returns the conversation, one line per command or reply
+func (t *Transcript) String() string {
+	return strings.Join(t.Lines, "\n")
+}
+
+// session is an SMTP conversation over a (possibly upgraded) connection
+type session struct {
+	cfg        config.KongSMTPConfig
+	conn       net.Conn
+	text       *textproto.Conn
+	transcript *Transcript
+	extensions map[string]string
+}
+
+// cmd sends a command and reads its reply, which must have the expected code.
+// display is what is recorded in the transcript, so that credentials can be redacted.
+func (s *session) cmd(expect int, display string, line string) (string, error) {
+	s.transcript.client(display)
+	id, err := s.text.Cmd("%s", line)
+	if err != nil {
+		return "", err
+	}
+	s.text.StartResponse(id)
+	defer s.text.EndResponse(id)
+	return s.read(expect)
+}
+
+// read reads a reply, which must have the expected code
+func (s *session) read(expect int) (string, error) {
+	code, msg, err := s.text.ReadResponse(expect)
+	if code != 0 {
+		s.transcript.server(code, msg)
+	}
+	return msg, err
+}
+
+// hello sends EHLO and records the extensions the server offers
+func (s *session) hello() error {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		name = "localhost"
+	}
+	msg, err := s.cmd(250, "EHLO "+name, "EHLO "+name)
+	if err != nil {
+		return err
+	}
+	s.extensions = map[string]string{}
+	for _, line := range strings.Split(msg, "\n")[1:] {
+		ext, args, _ := strings.Cut(line, " ")
+		s.extensions[strings.ToUpper(ext)] = args
+	}
+	return nil
+}
+
+// startTLS upgrades the connection with STARTTLS if the server offers it, as Auth does; otherwise the
+// conversation stays in plaintext
+func (s *session) startTLS() error {
+	if _, ok := s.extensions["STARTTLS"]; !ok {
+		s.transcript.note("server does not offer STARTTLS; continuing in plaintext")
+		return nil
+	}
+	if _, err := s.cmd(220, "STARTTLS", "STARTTLS"); err != nil {
+		return err
+	}
+	conn := tls.Client(s.conn, &tls.Config{ServerName: s.cfg.Host})
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	s.transcript.note("TLS established (%s)", tls.VersionName(conn.ConnectionState().Version))
+	s.conn = conn
+	s.text = textproto.NewConn(conn)
+	return s.hello()
+}
+
+// auth authenticates with PLAIN, or LOGIN if the server does not offer PLAIN
+func (s *session) auth() error {
+	if s.cfg.User == "" {
+		return nil
+	}
+	if _, ok := s.conn.(*tls.Conn); !ok {
+		return errors.New("refusing to send credentials over an unencrypted connection")
+	}
+	mechanisms := strings.Fields(strings.ToUpper(s.extensions["AUTH"]))
+	switch {
+	case slices.Contains(mechanisms, "PLAIN"):
+		token := base64.StdEncoding.EncodeToString([]byte("\x00" + s.cfg.User + "\x00" + s.cfg.Pass))
+		_, err := s.cmd(235, "AUTH PLAIN <redacted>", "AUTH PLAIN "+token)
+		return err
+	case slices.Contains(mechanisms, "LOGIN"):
+		if _, err := s.cmd(334, "AUTH LOGIN", "AUTH LOGIN"); err != nil {
+			return err
+		}
+		if _, err := s.cmd(334, "<redacted username>", base64.StdEncoding.EncodeToString([]byte(s.cfg.User))); err != nil {
+			return err
+		}
+		_, err := s.cmd(235, "<redacted password>", base64.StdEncoding.EncodeToString([]byte(s.cfg.Pass)))
+		return err
+	default:
+		return fmt.Errorf("server offers no supported AUTH mechanism (offered: %q)", s.extensions["AUTH"])
+	}
+}
+
+// Send delivers a message through the configured relay, negotiating TLS as Auth does: over implicit TLS on
+// port 465, and with STARTTLS on other ports if the relay offers it. The transcript is returned even if sending
+// fails.
+func Send(ctx context.Context, cfg config.KongSMTPConfig, msg Message) (*Transcript, error) {
+
+	transcript := &Transcript{}
+	if cfg.Host == "" {
+		return transcript, errors.New("no SMTP relay is configured (set smtp.host)")
+	}
+
+	from := mail.Address{Name: cfg.From.Name, Address: cfg.From.Email}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return transcript, fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	address := net.JoinHostPort(cfg.Host, strconv.Itoa(int(cfg.Port)))
+	implicitTLS := cfg.Port == config.SMTPImplicitTLSPort
+	if implicitTLS {
+		transcript.note("connecting to %s over TLS", address)
+	} else {
+		transcript.note("connecting to %s", address)
+	}
+	var conn net.Conn
+	if implicitTLS {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: cfg.Host}}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return transcript, fmt.Errorf("could not connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	s := &session{
+		cfg:        cfg,
+		conn:       conn,
+		text:       textproto.NewConn(conn),
+		transcript: transcript,
+	}
+
+	fail := func(stage string, err error) (*Transcript, error) {
+		return transcript, fmt.Errorf("%s: %w", stage, err)
+	}
+
+	if _, err := s.read(220); err != nil {
+		return fail("greeting", err)
+	}
+	if err := s.hello(); err != nil {
+		return fail("EHLO", err)
+	}
+	if !implicitTLS {
+		if err := s.startTLS(); err != nil {
+			return fail("STARTTLS", err)
+		}
+	}
+	if err := s.auth(); err != nil {
+		return fail("authentication", err)
+	}
+	if _, err := s.cmd(250, "MAIL FROM:<"+from.Address+">", "MAIL FROM:<"+from.Address+">"); err != nil {
+		return fail("sender", err)
+	}
+	if _, err := s.cmd(250, "RCPT TO:<"+to.Address+">", "RCPT TO:<"+to.Address+">"); err != nil {
+		return fail("recipient", err)
+	}
+	if _, err := s.cmd(354, "DATA", "DATA"); err != nil {
+		return fail("data", err)
+	}
+
+	w := s.text.DotWriter()
+	if _, err := w.Write(render(from, *to, msg)); err != nil {
+		return fail("data", err)
+	}
+	if err := w.Close(); err != nil {
+		return fail("data", err)
+	}
+	s.transcript.client("<message>")
+	if _, err := s.read(250); err != nil {
+		return fail("delivery", err)
+	}
+
+	_, _ = s.cmd(221, "QUIT", "QUIT")
+	return transcript, nil
+}
+
+// render returns a message with its headers
+func render(from mail.Address, to mail.Address, msg Message) []byte {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var buf bytes.Buffer
+	for _, header := range [][2]string{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "8bit"},
+	} {
+		fmt.Fprintf(&buf, "%s: %s\r\n", header[0], header[1])
+	}
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes()
+}
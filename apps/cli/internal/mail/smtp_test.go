@@ -0,0 +1,156 @@
+package mail
+
+import (
+	"context"
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// fakeServer is an SMTP server that accepts one conversation, offering the given EHLO extensions
+type fakeServer struct {
+	config.KongSMTPConfig
+	commands chan string
+	message  chan string
+}
+
+func newFakeServer(t *testing.T, extensions ...string) *fakeServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeServer{
+		KongSMTPConfig: config.KongSMTPConfig{
+			Host: "127.0.0.1",
+			Port: uint16(listener.Addr().(*net.TCPAddr).Port),
+			From: config.KongSMTPFromConfig{Email: "noreply@example.com", Name: "ProjDocs"},
+		},
+		commands: make(chan string, 32),
+		message:  make(chan string, 1),
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.serve(textproto.NewConn(conn), extensions)
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(text *textproto.Conn, extensions []string) {
+	_ = text.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		s.commands <- line
+		verb, _, _ := strings.Cut(strings.ToUpper(line), " ")
+		switch verb {
+		case "EHLO":
+			reply := append([]string{"fake"}, extensions...)
+			for i, ext := range reply {
+				sep := "-"
+				if i == len(reply)-1 {
+					sep = " "
+				}
+				_ = text.PrintfLine("250%s%s", sep, ext)
+			}
+		case "STARTTLS":
+			// the fake server has no certificate, so the handshake that follows fails
+			_ = text.PrintfLine("220 ready")
+			return
+		case "DATA":
+			_ = text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.message <- string(data)
+			_ = text.PrintfLine("250 queued")
+		case "QUIT":
+			_ = text.PrintfLine("221 bye")
+			return
+		default:
+			_ = text.PrintfLine("250 ok")
+		}
+	}
+}
+
+// received returns the commands the server received so far
+func (s *fakeServer) received() []string {
+	var commands []string
+	for {
+		select {
+		case command := <-s.commands:
+			commands = append(commands, command)
+		default:
+			return commands
+		}
+	}
+}
+
+func TestSendInPlaintextWithoutStartTLS(t *testing.T) {
+	server := newFakeServer(t, "8BITMIME")
+
+	transcript, err := Send(context.Background(), server.KongSMTPConfig, Message{To: "user@example.com", Subject: "Test", Body: "line 1\nline 2"})
+	if err != nil {
+		t.Fatalf("send failed: %v\n%s", err, transcript)
+	}
+	if !strings.Contains(transcript.String(), "continuing in plaintext") {
+		t.Errorf("expected the transcript to note the plaintext connection, got\n%s", transcript)
+	}
+
+	message := <-server.message
+	for _, want := range []string{"From: \"ProjDocs\" <noreply@example.com>", "To: <user@example.com>", "Subject: Test", "line 1\nline 2"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("expected the message to contain %q, got\n%s", want, message)
+		}
+	}
+	commands := strings.Join(server.received(), "\n")
+	for _, want := range []string{"MAIL FROM:<noreply@example.com>", "RCPT TO:<user@example.com>", "DATA", "QUIT"} {
+		if !strings.Contains(commands, want) {
+			t.Errorf("expected the command %q, got\n%s", want, commands)
+		}
+	}
+}
+
+func TestSendRefusesCredentialsInPlaintext(t *testing.T) {
+	server := newFakeServer(t, "AUTH PLAIN LOGIN")
+	cfg := server.KongSMTPConfig
+	cfg.User, cfg.Pass = "user", "hunter2"
+
+	transcript, err := Send(context.Background(), cfg, Message{To: "user@example.com", Subject: "Test", Body: "body"})
+	if err == nil || !strings.Contains(err.Error(), "refusing to send credentials over an unencrypted connection") {
+		t.Fatalf("expected the credentials to be withheld, got %v", err)
+	}
+	if strings.Contains(transcript.String(), "hunter2") || strings.Contains(strings.Join(server.received(), "\n"), "AUTH") {
+		t.Errorf("credentials were sent")
+	}
+}
+
+func TestSendUpgradesWithStartTLS(t *testing.T) {
+	server := newFakeServer(t, "STARTTLS")
+
+	_, err := Send(context.Background(), server.KongSMTPConfig, Message{To: "user@example.com", Subject: "Test", Body: "body"})
+	if err == nil || !strings.Contains(err.Error(), "TLS handshake failed") {
+		t.Fatalf("expected the connection to be upgraded, got %v", err)
+	}
+	if commands := server.received(); len(commands) < 2 || commands[1] != "STARTTLS" {
+		t.Errorf("expected STARTTLS after EHLO, got %v", commands)
+	}
+}
+
+func TestSendRejectsInvalidRecipient(t *testing.T) {
+	_, err := Send(context.Background(), config.KongSMTPConfig{Host: "127.0.0.1", Port: 25}, Message{To: "not an address"})
+	if err == nil || !strings.Contains(err.Error(), "invalid recipient") {
+		t.Fatalf("expected the recipient to be rejected, got %v", err)
+	}
+}
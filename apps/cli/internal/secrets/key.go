@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// PassphraseEnv is the environment variable holding the master passphrase (takes precedence over the key file)
+	PassphraseEnv = "PROJDOCS_SECRETS_PASSPHRASE"
+
+	// KeyFileEnv is the environment variable overriding the location of the master key file
+	KeyFileEnv = "PROJDOCS_SECRETS_KEY_FILE"
+
+	// KeyFileName is the default name of the master key file within the config dir
+	KeyFileName = "master.key"
+
+	keySize         = 32 // AES-256
+	saltSize        = 16
+	pbkdf2Iteration = 600_000
+)
+
+const (
+	kdfNone   = "none"
+	kdfPBKDF2 = "pbkdf2-sha256"
+)
+
+var ErrPassphraseRequired = fmt.Errorf("secrets store is passphrase-protected: set %s to unlock it", PassphraseEnv)
+
+// masterKey is a source of the key used to encrypt the secrets store
+type masterKey struct {
+	kdf        string
+	key        []byte // set for kdfNone
+	passphrase string // set for kdfPBKDF2
+}
+
+// derive returns the AES key for the given envelope parameters
+func (m *masterKey) derive(kdf string, salt []byte, iterations int) ([]byte, error) {
+	switch kdf {
+	case kdfNone:
+		if m.kdf != kdfNone {
+			return nil, fmt.Errorf("secrets store is encrypted with a key file, but %s is set", PassphraseEnv)
+		}
+		return m.key, nil
+	case kdfPBKDF2:
+		if m.kdf != kdfPBKDF2 {
+			return nil, ErrPassphraseRequired
+		}
+		return pbkdf2.Key(sha256.New, m.passphrase, salt, iterations, keySize)
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function %q", kdf)
+	}
+}
+
+// keyFilePath returns the location of the master key file
+func keyFilePath(configDir string) string {
+	if p := os.Getenv(KeyFileEnv); p != "" {
+		return p
+	}
+	return filepath.Join(configDir, KeyFileName)
+}
+
+// loadMasterKey resolves the master key from the passphrase env var or the key file.
+// If neither exists and create is true, a new random key file is generated.
+func loadMasterKey(configDir string, create bool) (*masterKey, error) {
+
+	if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+		if passphrase == "" {
+			return nil, fmt.Errorf("%s is set but empty", PassphraseEnv)
+		}
+		return &masterKey{kdf: kdfPBKDF2, passphrase: passphrase}, nil
+	}
+
+	file := keyFilePath(configDir)
+	stat, err := os.Stat(file)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("could not stat key file (%s): %w", file, err)
+		}
+		if !create {
+			return nil, fmt.Errorf("key file (%s) does not exist and %s is not set", file, PassphraseEnv)
+		}
+		key := make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("could not generate master key: %w", err)
+		}
+		if err := utils.WriteFileAtomic(file, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("could not write key file (%s): %w", file, err)
+		}
+		return &masterKey{kdf: kdfNone, key: key}, nil
+	}
+
+	if runtime.GOOS != "windows" && stat.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("key file (%s) has permissions %#o; it must not be accessible by group or others (chmod 600)", file, stat.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file (%s): %w", file, err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("key file (%s) is not valid hex: %w", file, err)
+	} else if len(key) != keySize {
+		return nil, fmt.Errorf("key file (%s) must contain %d bytes, found %d", file, keySize, len(key))
+	}
+	return &masterKey{kdf: kdfNone, key: key}, nil
+}
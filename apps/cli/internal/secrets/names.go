@@ -0,0 +1,22 @@
+package secrets
+
+// names of the well-known secrets kept in the store
+const (
+	JwtAlgorithm          = "keys.algorithm"
+	JwtSecret             = "keys.jwt_secret"
+	SigningKey            = "keys.signing_key"
+	AuthSigningKey        = "keys.auth_signing_key"
+	AnonKey               = "keys.anon"
+	ServiceKey            = "keys.service_role"
+	PreviousJWKs          = "keys.previous.jwks"
+	PreviousAnonKey       = "keys.previous.anon"
+	PreviousServiceKey    = "keys.previous.service_role"
+	PreviousKeysExpireAt  = "keys.previous.expires_at"
+	DatabasePassword      = "database.password"
+	DashboardUsername     = "dashboard.username"
+	DashboardPassword     = "dashboard.password"
+	RealtimeSecretKeyBase = "realtime.secret_key_base"
+	VaultRootKey          = "vault.root_key"
+	VaultPreviousRootKey  = "vault.root_key.previous"
+	SmtpPassword          = "smtp.password"
+)
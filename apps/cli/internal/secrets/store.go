@@ -0,0 +1,226 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// FileName is the name of the encrypted secrets file within the config dir
+const FileName = "secrets.enc"
+
+// Version is the current schema version of the encrypted secrets file
+const Version = 1
+
+// envelope is the on-disk representation of the store
+type envelope struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// aad binds the ciphertext to the schema version and key derivation parameters
+func (e *envelope) aad() []byte {
+	return []byte(fmt.Sprintf("projdocs-secrets:v%d:%s:%d", e.Version, e.KDF, e.Iterations))
+}
+
+// Store is an unlocked, encrypted-at-rest key-value store of instance secrets
+type Store struct {
+	path   string
+	master *masterKey
+	values map[string]string
+	lock   sync.RWMutex
+}
+
+// ErrNoStore is returned by Open when the config dir has no secrets store
+var ErrNoStore = errors.New("no secrets store")
+
+// Unlock opens the secrets store in the config dir, creating it (and a master key file, if no passphrase is set) on first use
+func Unlock(configDir string) (*Store, error) {
+	return open(configDir, true)
+}
+
+// Open opens the existing secrets store in the config dir; unlike Unlock, it never creates one
+func Open(configDir string) (*Store, error) {
+	return open(configDir, false)
+}
+
+func open(configDir string, create bool) (*Store, error) {
+
+	path := filepath.Join(configDir, FileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("could not read secrets file (%s): %w", path, err)
+		}
+		if !create {
+			return nil, fmt.Errorf("%w at %s", ErrNoStore, path)
+		}
+		master, err := loadMasterKey(configDir, true)
+		if err != nil {
+			return nil, err
+		}
+		store := &Store{path: path, master: master, values: map[string]string{}}
+		if err := store.Save(); err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("could not parse secrets file (%s): %w", path, err)
+	}
+	if env.Version > Version || env.Version < 1 {
+		return nil, fmt.Errorf("secrets file (%s) has unsupported version %d", path, env.Version)
+	}
+
+	master, err := loadMasterKey(configDir, false)
+	if err != nil {
+		return nil, err
+	}
+	key, err := master.derive(env.KDF, env.Salt, env.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, env.Nonce, env.Ciphertext, env.aad())
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt secrets file (%s): wrong master key or passphrase", path)
+	}
+
+	values := map[string]string{}
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("could not decode secrets file (%s): %w", path, err)
+	}
+
+	return &Store{path: path, master: master, values: values}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create gcm: %w", err)
+	}
+	return gcm, nil
+}
+
+// Save encrypts the store with a fresh nonce (and salt) and atomically writes it to disk
+func (s *Store) Save() error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	plaintext, err := json.Marshal(s.values)
+	if err != nil {
+		return fmt.Errorf("could not encode secrets: %w", err)
+	}
+
+	env := envelope{
+		Version: Version,
+		KDF:     s.master.kdf,
+	}
+	if env.KDF == kdfPBKDF2 {
+		env.Iterations = pbkdf2Iteration
+		env.Salt = make([]byte, saltSize)
+		if _, err := rand.Read(env.Salt); err != nil {
+			return fmt.Errorf("could not generate salt: %w", err)
+		}
+	}
+
+	key, err := s.master.derive(env.KDF, env.Salt, env.Iterations)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	env.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return fmt.Errorf("could not generate nonce: %w", err)
+	}
+	env.Ciphertext = gcm.Seal(nil, env.Nonce, plaintext, env.aad())
+
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode secrets file: %w", err)
+	}
+	if err := utils.WriteFileAtomic(s.path, data, 0600); err != nil {
+		return fmt.Errorf("could not write secrets file (%s): %w", s.path, err)
+	}
+	return nil
+}
+
+// Get returns the secret with the given name
+func (s *Store) Get(name string) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	value, ok := s.values[name]
+	return value, ok
+}
+
+// Set stores a secret in memory; call Save to persist it
+func (s *Store) Set(name string, value string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.values[name] = value
+}
+
+// Delete removes a secret from memory; call Save to persist the removal
+func (s *Store) Delete(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.values, name)
+}
+
+// GetOrCreate returns the named secret, generating (but not saving) it if it does not exist yet.
+// The boolean result reports whether the secret was generated.
+func (s *Store) GetOrCreate(name string, generate func() (string, error)) (string, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if value, ok := s.values[name]; ok {
+		return value, false, nil
+	}
+	value, err := generate()
+	if err != nil {
+		return "", false, fmt.Errorf("could not generate secret %s: %w", name, err)
+	}
+	s.values[name] = value
+	return value, true, nil
+}
+
+// Names returns the names of every stored secret, sorted
+func (s *Store) Names() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	names := make([]string, 0, len(s.values))
+	for name := range s.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Path returns the location of the encrypted secrets file
+func (s *Store) Path() string {
+	return s.path
+}
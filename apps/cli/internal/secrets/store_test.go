@@ -0,0 +1,152 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestOpenDoesNotCreateStore(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(KeyFileEnv, "")
+
+	if _, err := Open(dir); !errors.Is(err, ErrNoStore) {
+		t.Fatalf("expected ErrNoStore, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected nothing to be created, found %v", entries)
+	}
+
+	if _, err := Unlock(dir); err != nil {
+		t.Fatalf("could not create store: %v", err)
+	}
+	if _, err := Open(dir); err != nil {
+		t.Errorf("could not open created store: %v", err)
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	for _, passphrase := range []string{"", "correct horse"} {
+		dir := t.TempDir()
+		t.Setenv(KeyFileEnv, "")
+		t.Setenv(PassphraseEnv, passphrase)
+		if passphrase == "" {
+			os.Unsetenv(PassphraseEnv) // restored by t.Setenv
+		}
+
+		store, err := Unlock(dir)
+		if err != nil {
+			t.Fatalf("could not create store: %v", err)
+		}
+		store.Set("jwt_secret", "s3cr3t-value")
+		if err := store.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(store.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "s3cr3t-value") {
+			t.Errorf("secret is stored in plaintext")
+		}
+
+		store, err = Open(dir)
+		if err != nil {
+			t.Fatalf("could not reopen store: %v", err)
+		}
+		if value, ok := store.Get("jwt_secret"); !ok || value != "s3cr3t-value" {
+			t.Errorf("expected the secret to survive a round trip, got %q (%v)", value, ok)
+		}
+	}
+}
+
+func TestStoreRejectsWrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(KeyFileEnv, "")
+	t.Setenv(PassphraseEnv, "right")
+	if _, err := Unlock(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(PassphraseEnv, "wrong")
+	if _, err := Open(dir); err == nil || !strings.Contains(err.Error(), "wrong master key or passphrase") {
+		t.Fatalf("expected the wrong passphrase to be rejected, got %v", err)
+	}
+
+	os.Unsetenv(PassphraseEnv) // restored by t.Setenv
+	if _, err := Open(dir); err == nil || !strings.Contains(err.Error(), PassphraseEnv) {
+		t.Fatalf("expected a passphrase to be required, got %v", err)
+	}
+}
+
+func TestStoreRejectsTamperedCiphertext(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(KeyFileEnv, "")
+	t.Setenv(PassphraseEnv, "")
+	os.Unsetenv(PassphraseEnv) // restored by t.Setenv
+	store, err := Unlock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set("jwt_secret", "value")
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(store.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatal(err)
+	}
+	env.Ciphertext[0] ^= 0xff
+	if data, err = json.Marshal(env); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(store.Path(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir); err == nil {
+		t.Fatal("expected a tampered store to be rejected")
+	}
+}
+
+func TestKeyFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on windows")
+	}
+	dir := t.TempDir()
+	t.Setenv(KeyFileEnv, "")
+	t.Setenv(PassphraseEnv, "")
+	os.Unsetenv(PassphraseEnv) // restored by t.Setenv
+	store, err := Unlock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{filepath.Join(dir, KeyFileName), store.Path()} {
+		stat, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := stat.Mode().Perm(); mode != 0600 {
+			t.Errorf("expected %s to have mode 0600, got %#o", file, mode)
+		}
+	}
+
+	// a key file readable by others is refused
+	if err := os.Chmod(filepath.Join(dir, KeyFileName), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); err == nil || !strings.Contains(err.Error(), "must not be accessible by group or others") {
+		t.Fatalf("expected the key file to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"github.com/projdocs/projdocs/apps/cli/internal/server/utils"
+	"net/http"
+)
+
+// JWKS serves the instance's public signing keys as a plain JWK set (RFC 7517), so that
+// third-party services can verify tokens without sharing a secret
+func JWKS(keys func() config.JWKS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		utils.RespondRaw(w, keys())
+	})
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
+	"github.com/projdocs/projdocs/apps/cli/internal/server/utils"
+	"net/http"
+)
+
+type ServicesResponse struct {
+	Status   string                 `json:"status"` // "ok", or "degraded" if a service is not running
+	Services []docker.ServiceStatus `json:"services"`
+}
+
+// Services serves the supervised state of every docker service, with a 503 status if any is not running
+func Services(status func() []docker.ServiceStatus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := ServicesResponse{Status: "ok", Services: status()}
+		for _, service := range res.Services {
+			if service.State != docker.ServiceRunning {
+				res.Status = "degraded"
+			}
+		}
+		if res.Status == "ok" {
+			utils.Respond(w, res)
+		} else {
+			utils.RespondWithStatus(w, http.StatusServiceUnavailable, res)
+		}
+	})
+}
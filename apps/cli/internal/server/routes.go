@@ -5,6 +5,12 @@ import (
 	"net/http"
 )
 
-func registerRoutes(mux *http.ServeMux) {
+func registerRoutes(mux *http.ServeMux, cfg RunConfig) {
 	mux.Handle("GET /healthz", handlers.Healthz)
+	if cfg.JWKS != nil {
+		mux.Handle("GET /.well-known/jwks.json", handlers.JWKS(cfg.JWKS))
+	}
+	if cfg.Services != nil {
+		mux.Handle("GET /services", handlers.Services(cfg.Services))
+	}
 }
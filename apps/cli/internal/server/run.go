@@ -3,6 +3,8 @@ package server
 import (
 	"context"
 	"fmt"
+	"github.com/projdocs/projdocs/apps/cli/internal/config"
+	"github.com/projdocs/projdocs/apps/cli/internal/docker"
 	"github.com/projdocs/projdocs/apps/cli/internal/logger"
 	"net"
 	"net/http"
@@ -41,8 +43,10 @@ func (s *Server) Stop(ctx context.Context) error {
 }
 
 type RunConfig struct {
-	Host *string
-	Port *uint16
+	Host     *string
+	Port     *uint16
+	JWKS     func() config.JWKS            // public keys published at /.well-known/jwks.json; nil disables the endpoint
+	Services func() []docker.ServiceStatus // state of the docker services published at /services; nil disables the endpoint
 }
 
 func (cfg RunConfig) GetAddress() string {
@@ -61,7 +65,7 @@ func NewServer(config RunConfig) (*Server, error) {
 
 	srv := http.Server{
 		Addr:              config.GetAddress(),
-		Handler:           NewHandler(),
+		Handler:           NewHandler(config),
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
@@ -5,9 +5,9 @@ import (
 	"net/http"
 )
 
-func NewHandler() http.Handler {
+func NewHandler(cfg RunConfig) http.Handler {
 	mux := http.NewServeMux()
-	registerRoutes(mux)
+	registerRoutes(mux, cfg)
 
 	var handler http.Handler = mux
 
@@ -26,6 +26,11 @@ func RespondWithErrorStatus(w http.ResponseWriter, status int, err error) {
 	sendJSON(w, status, types.NewErrorResponse(err))
 }
 
+// RespondRaw send v as JSON, without the types.DataResponse envelope, with a 200 status
+func RespondRaw(w http.ResponseWriter, v any) {
+	sendJSON(w, http.StatusOK, v)
+}
+
 // sendJSON send a JSON response to a writer with a 200 status code
 func sendJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
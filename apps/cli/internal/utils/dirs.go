@@ -1,23 +1,141 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"runtime"
 )
 
-func GetHomeDir() (string, error) {
+const (
+	// HomeEnv is the environment variable that relocates an instance into a single dir (like --home)
+	HomeEnv = "PROJDOCS_HOME"
+
+	// LegacyHomeDir is where every file of an instance was kept on Linux before config, data and logs were separated
+	LegacyHomeDir = "/etc/projdocs"
+)
+
+// Dirs are the locations an instance keeps its files in
+type Dirs struct {
+	Config string // config file, secrets store, master key and instance state
+	Data   string // database, uploaded files and backups
+	Log    string // log files
+
+	// Legacy is set when the data has not been moved out of LegacyHomeDir yet (see `projdocs home migrate`)
+	Legacy bool
+}
+
+// All returns every dir, in the order they should be created
+func (d Dirs) All() []string {
+	return []string{d.Config, d.Data, d.Log}
+}
+
+var homeOverride string
+
+// SetHomeDir relocates the instance into a single dir, taking precedence over HomeEnv; an empty dir restores the defaults
+func SetHomeDir(home string) {
+	homeOverride = home
+}
+
+// singleDir returns the layout of an instance kept in one dir, as with --home (and the legacy layout)
+func singleDir(home string) (Dirs, error) {
+	home, err := filepath.Abs(home)
+	if err != nil {
+		return Dirs{}, fmt.Errorf("invalid home dir: %w", err)
+	}
+	return Dirs{Config: home, Data: home, Log: filepath.Join(home, "logs")}, nil
+}
+
+// xdgDir returns an XDG base dir from its env var, falling back to a dir in the user's home
+func xdgDir(env string, fallback ...string) (string, error) {
+	if dir := os.Getenv(env); dir != "" && filepath.IsAbs(dir) {
+		return dir, nil // relative paths are invalid per the spec and must be ignored
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not get user home dir for %s: %w", env, err)
+	}
+	return filepath.Join(append([]string{home}, fallback...)...), nil
+}
+
+// GetTargetDirs returns the dirs an instance should use: the --home or PROJDOCS_HOME dir if set, otherwise
+//
+//   - Linux, as root: /etc/projdocs, /var/lib/projdocs and /var/log/projdocs
+//   - Linux, as any other user: projdocs in $XDG_CONFIG_HOME, $XDG_DATA_HOME and $XDG_STATE_HOME
+//   - macOS: the app-data dir next to the binary
+//
+// Unlike GetDirs, it does not fall back to the legacy layout, so it is where `home migrate` moves files to.
+func GetTargetDirs() (Dirs, error) {
+
+	if homeOverride != "" {
+		return singleDir(homeOverride)
+	}
+	if home := os.Getenv(HomeEnv); home != "" {
+		return singleDir(home)
+	}
+
 	switch runtime.GOOS {
 	case "linux":
-		return "/etc/projdocs", nil
+		if os.Geteuid() == 0 {
+			return Dirs{Config: LegacyHomeDir, Data: "/var/lib/projdocs", Log: "/var/log/projdocs"}, nil
+		}
+		var dirs Dirs
+		var err error
+		if dirs.Config, err = xdgDir("XDG_CONFIG_HOME", ".config"); err != nil {
+			return Dirs{}, err
+		}
+		if dirs.Data, err = xdgDir("XDG_DATA_HOME", ".local", "share"); err != nil {
+			return Dirs{}, err
+		}
+		if dirs.Log, err = xdgDir("XDG_STATE_HOME", ".local", "state"); err != nil {
+			return Dirs{}, err
+		}
+		dirs.Config = filepath.Join(dirs.Config, "projdocs")
+		dirs.Data = filepath.Join(dirs.Data, "projdocs")
+		dirs.Log = filepath.Join(dirs.Log, "projdocs")
+		return dirs, nil
 	case "darwin":
 		binaryPath, err := os.Executable()
 		if err != nil {
-			return "", err
+			return Dirs{}, err
 		}
-		return path.Join(path.Dir(path.Dir(binaryPath)), "app-data"), nil
+		return singleDir(path.Join(path.Dir(path.Dir(binaryPath)), "app-data"))
 	default:
-		return "", fmt.Errorf("OS %s not supported", runtime.GOOS)
+		return Dirs{}, fmt.Errorf("OS %s not supported", runtime.GOOS)
 	}
 }
+
+// GetDirs returns the dirs the instance uses (see GetTargetDirs). If the config dir is the legacy home dir and its
+// data has not been migrated yet, the data is used where it is.
+func GetDirs() (Dirs, error) {
+
+	dirs, err := GetTargetDirs()
+	if err != nil {
+		return Dirs{}, err
+	}
+	if dirs.Config == LegacyHomeDir && dirs.Data != LegacyHomeDir && HasLegacyData(LegacyHomeDir) && !exists(dirs.Data) {
+		dirs.Data = LegacyHomeDir
+		dirs.Legacy = true
+	}
+	return dirs, nil
+}
+
+// LegacyDataDirs are the dirs of a single-dir home that hold data rather than configuration
+var LegacyDataDirs = []string{"postgres", "storage", "backups"}
+
+// HasLegacyData reports whether a single-dir home holds any data dirs
+func HasLegacyData(home string) bool {
+	for _, name := range LegacyDataDirs {
+		if exists(filepath.Join(home, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return !errors.Is(err, os.ErrNotExist)
+}
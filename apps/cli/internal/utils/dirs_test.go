@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestGetTargetDirsHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv(HomeEnv, home)
+
+	dirs, err := GetTargetDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Dirs{Config: home, Data: home, Log: filepath.Join(home, "logs")}); dirs != want {
+		t.Errorf("expected %+v, got %+v", want, dirs)
+	}
+
+	// --home takes precedence over the env var
+	override := t.TempDir()
+	SetHomeDir(override)
+	t.Cleanup(func() { SetHomeDir("") })
+	if dirs, err = GetTargetDirs(); err != nil {
+		t.Fatal(err)
+	}
+	if dirs.Config != override || dirs.Data != override {
+		t.Errorf("expected the --home dir %s, got %+v", override, dirs)
+	}
+}
+
+func TestGetTargetDirsLinux(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the layout is specific to linux")
+	}
+	t.Setenv(HomeEnv, "")
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+	t.Setenv("XDG_DATA_HOME", "relative/data") // ignored, as the spec requires
+	t.Setenv("XDG_STATE_HOME", "")
+
+	dirs, err := GetTargetDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Dirs{
+		Config: filepath.Join(home, "config", "projdocs"),
+		Data:   filepath.Join(home, ".local", "share", "projdocs"),
+		Log:    filepath.Join(home, ".local", "state", "projdocs"),
+	}
+	if os.Geteuid() == 0 {
+		want = Dirs{Config: LegacyHomeDir, Data: "/var/lib/projdocs", Log: "/var/log/projdocs"}
+	}
+	if dirs != want {
+		t.Errorf("expected %+v, got %+v", want, dirs)
+	}
+}
+
+func TestHasLegacyData(t *testing.T) {
+	home := t.TempDir()
+	if HasLegacyData(home) {
+		t.Errorf("expected an empty home to hold no data")
+	}
+	if err := os.Mkdir(filepath.Join(home, "storage"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if !HasLegacyData(home) {
+		t.Errorf("expected the storage dir to be found")
+	}
+}